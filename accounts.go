@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// roleSessionName identifies our AssumeRole calls in CloudTrail
+const roleSessionName = "elb-pruner"
+
+// resolveAccounts turns the -accounts and -org flags into the list of account IDs to scan. Accounts
+// discovered from the Organization are only included if they are active.
+func resolveAccounts(value string, discover bool, orgSvc organizationsiface.OrganizationsAPI) ([]string, error) {
+	res := make([]string, 0)
+	seen := make(map[string]struct{})
+
+	add := func(id string) {
+		if _, ok := seen[id]; ok || id == "" {
+			return
+		}
+		seen[id] = struct{}{}
+		res = append(res, id)
+	}
+
+	for _, id := range strings.Split(value, ",") {
+		add(strings.TrimSpace(id))
+	}
+
+	if discover {
+		err := orgSvc.ListAccountsPages(&organizations.ListAccountsInput{}, func(page *organizations.ListAccountsOutput, lastPage bool) bool {
+			for _, account := range page.Accounts {
+				if aws.StringValue(account.Status) == organizations.AccountStatusActive {
+					add(aws.StringValue(account.Id))
+				}
+			}
+			return !lastPage
+		})
+		if err != nil {
+			return nil, newAWSError("ListAccounts", err)
+		}
+	}
+
+	sort.Strings(res)
+
+	return res, nil
+}
+
+// roleARN is the ARN of the named role in the specified account
+func roleARN(accountID, roleName string) string {
+	return fmt.Sprintf("arn:aws:iam::%s:role/%s", accountID, strings.TrimPrefix(roleName, "/"))
+}
+
+// assumeRole returns a session which uses the named role in the specified account. The credentials
+// are fetched lazily, so the first API call made with the session is the one that might fail.
+func assumeRole(sess *session.Session, accountID, roleName string) *session.Session {
+	creds := stscreds.NewCredentials(sess, roleARN(accountID, roleName), func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = roleSessionName
+	})
+
+	return sess.Copy(aws.NewConfig().WithCredentials(creds))
+}
+
+// checkAccess confirms that we can read the specified account. This catches a missing or
+// misconfigured role before we start reading load balancers, so that one bad account doesn't stop
+// us from reporting on the rest of the Organization.
+func checkAccess(stsSvc stsiface.STSAPI, accountID string) error {
+	identity, err := stsSvc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return newAWSError("GetCallerIdentity", err)
+	}
+
+	if aws.StringValue(identity.Account) != accountID {
+		return &authError{fmt.Errorf("expected to be in account %s, but was in %s", accountID, aws.StringValue(identity.Account))}
+	}
+
+	return nil
+}
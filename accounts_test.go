@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOrganizations is a stand-in for the Organizations API which returns its accounts over 2 pages
+type fakeOrganizations struct {
+	organizationsiface.OrganizationsAPI
+	accounts []*organizations.Account
+}
+
+func (f *fakeOrganizations) ListAccountsPages(input *organizations.ListAccountsInput, fn func(*organizations.ListAccountsOutput, bool) bool) error {
+	half := len(f.accounts) / 2
+	if fn(&organizations.ListAccountsOutput{Accounts: f.accounts[:half]}, false) {
+		fn(&organizations.ListAccountsOutput{Accounts: f.accounts[half:]}, true)
+	}
+	return nil
+}
+
+// fakeSTS is a stand-in for the STS API which reports that we're in a particular account
+type fakeSTS struct {
+	stsiface.STSAPI
+	account string
+	err     error
+}
+
+func (f *fakeSTS) GetCallerIdentity(input *sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &sts.GetCallerIdentityOutput{Account: &f.account}, nil
+}
+
+func orgAccount(id, status string) *organizations.Account {
+	return &organizations.Account{Id: &id, Status: &status}
+}
+
+func TestAccountsCanBeListedExplicitly(t *testing.T) {
+	accounts, err := resolveAccounts("222222222222, 111111111111,222222222222", false, &fakeOrganizations{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"111111111111", "222222222222"}, accounts)
+}
+
+func TestNoAccountsMeansTheProfileAccount(t *testing.T) {
+	accounts, err := resolveAccounts("", false, &fakeOrganizations{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, accounts)
+}
+
+func TestActiveAccountsAreDiscoveredFromTheOrganization(t *testing.T) {
+	orgSvc := &fakeOrganizations{
+		accounts: []*organizations.Account{
+			orgAccount("333333333333", organizations.AccountStatusActive),
+			orgAccount("444444444444", organizations.AccountStatusSuspended),
+			orgAccount("111111111111", organizations.AccountStatusActive),
+			orgAccount("555555555555", organizations.AccountStatusActive),
+		},
+	}
+
+	accounts, err := resolveAccounts("111111111111,999999999999", true, orgSvc)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"111111111111", "333333333333", "555555555555", "999999999999"}, accounts)
+}
+
+func TestRoleARN(t *testing.T) {
+	assert.Equal(t, "arn:aws:iam::111111111111:role/elb-pruner-readonly", roleARN("111111111111", "elb-pruner-readonly"))
+	assert.Equal(t, "arn:aws:iam::111111111111:role/audit/readonly", roleARN("111111111111", "/audit/readonly"))
+}
+
+func TestCheckAccessConfirmsTheAssumedAccount(t *testing.T) {
+	assert.NoError(t, checkAccess(&fakeSTS{account: "111111111111"}, "111111111111"))
+	assert.Error(t, checkAccess(&fakeSTS{account: "222222222222"}, "111111111111"))
+	assert.Error(t, checkAccess(&fakeSTS{err: errors.New("AccessDenied")}, "111111111111"))
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+// listenerCertificates are the certificates that the listener on one of an LB's ports needs, so that
+// it can serve every ELB which shares the port
+type listenerCertificates struct {
+	port               int
+	defaultCertificate string      // for clients which don't use SNI. It's the one which covers the most hostnames.
+	sni                []string    // the other certificates, which clients choose between with SNI
+	uncovered          []string    // the Route 53 names of the ELBs on the port which none of the certificates cover
+	unknown            []string    // the certificates whose names we couldn't read, so coverage wasn't checked
+	missing            []string    // the certificates which no longer exist in ACM or IAM, so they cover nothing
+	exceeded           *quotaSplit // the certificates per listener quota, if sni has more than it allows
+}
+
+// collectCertificateNames reads the names that each certificate of the ELBs' listeners covers, from
+// ACM or IAM, keyed by ARN. A certificate which no longer exists has nil names, so that it's reported
+// as missing. One which we aren't allowed to read is left out, along with a warning, so that its
+// coverage is reported as unknown.
+func collectCertificateNames(elbs []*elb.LoadBalancerDescription, acmSvc acmiface.ACMAPI, iamSvc iamiface.IAMAPI) (map[string][]string, []string, error) {
+	res := make(map[string][]string)
+	warnings := make([]string, 0)
+
+	for _, arn := range certificateARNs(elbs) {
+		var (
+			names []string
+			err   error
+		)
+
+		if strings.Contains(arn, ":iam:") {
+			names, err = iamCertificateNames(arn, iamSvc)
+		} else {
+			names, err = acmCertificateNames(arn, acmSvc)
+		}
+
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && (aerr.Code() == acm.ErrCodeResourceNotFoundException || aerr.Code() == iam.ErrCodeNoSuchEntityException) {
+			res[arn] = nil
+			continue
+		}
+		if isAccessDenied(err) {
+			warnings = append(warnings, fmt.Sprintf("unable to read the names of certificate %s, so the hostnames it covers aren't checked: %v", arn, err))
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		res[arn] = names
+	}
+
+	return res, warnings, nil
+}
+
+// certificateARNs returns the distinct certificates of the ELBs' listeners, in order
+func certificateARNs(elbs []*elb.LoadBalancerDescription) []string {
+	seen := make(map[string]struct{})
+	for _, lb := range elbs {
+		for _, ld := range lb.ListenerDescriptions {
+			if arn := aws.StringValue(ld.Listener.SSLCertificateId); arn != "" {
+				seen[arn] = struct{}{}
+			}
+		}
+	}
+
+	res := make([]string, 0, len(seen))
+	for arn := range seen {
+		res = append(res, arn)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func acmCertificateNames(arn string, acmSvc acmiface.ACMAPI) ([]string, error) {
+	result, err := acmSvc.DescribeCertificate(&acm.DescribeCertificateInput{CertificateArn: aws.String(arn)})
+	if err != nil {
+		return nil, newAWSError("DescribeCertificate", err)
+	}
+	if result.Certificate == nil {
+		return []string{}, nil
+	}
+
+	// The subject alternative names include the domain name
+	names := aws.StringValueSlice(result.Certificate.SubjectAlternativeNames)
+	if domain := aws.StringValue(result.Certificate.DomainName); domain != "" {
+		names = append(names, domain)
+	}
+	return distinctNames(names), nil
+}
+
+// iamCertificateNames reads the names from the body of a server certificate which was uploaded to IAM.
+// The name of the certificate is the last part of its ARN, after the path.
+func iamCertificateNames(arn string, iamSvc iamiface.IAMAPI) ([]string, error) {
+	name := arn[strings.LastIndex(arn, "/")+1:]
+
+	result, err := iamSvc.GetServerCertificate(&iam.GetServerCertificateInput{ServerCertificateName: aws.String(name)})
+	if err != nil {
+		return nil, newAWSError("GetServerCertificate", err)
+	}
+	if result.ServerCertificate == nil {
+		return []string{}, nil
+	}
+
+	block, _ := pem.Decode([]byte(aws.StringValue(result.ServerCertificate.CertificateBody)))
+	if block == nil {
+		return nil, &dataError{fmt.Errorf("the IAM server certificate %s isn't PEM encoded", name)}
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, &dataError{fmt.Errorf("unable to read the IAM server certificate %s: %w", name, err)}
+	}
+
+	// Clients ignore the common name when there are subject alternative names
+	if len(cert.DNSNames) > 0 {
+		return distinctNames(cert.DNSNames), nil
+	}
+	return distinctNames([]string{cert.Subject.CommonName}), nil
+}
+
+// distinctNames returns the non-empty names in lower case, sorted and without duplicates
+func distinctNames(names []string) []string {
+	seen := make(map[string]struct{})
+	res := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if _, ok := seen[name]; !ok && name != "" {
+			seen[name] = struct{}{}
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// certificateCovers is whether one of the certificate's names matches the hostname. A wildcard only
+// matches a single label, as in TLS.
+func certificateCovers(names []string, hostname string) bool {
+	hostname = strings.ToLower(hostname)
+
+	for _, name := range names {
+		if name == hostname {
+			return true
+		}
+		if suffix := strings.TrimPrefix(name, "*"); suffix != name {
+			if label := strings.TrimSuffix(hostname, suffix); label != hostname && label != "" && !strings.Contains(label, ".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// planCertificates chooses the default and SNI certificates for each of the LB's ports which has any.
+// names has the names of each certificate, keyed by ARN, or is nil if they weren't read. ELBs aren't
+// split over the certificates quota, since their certificates could be replaced by fewer which cover
+// more names, so a listener which needs more SNI certificates than the quota is flagged instead.
+func (lb *LB) planCertificates(names map[string][]string, quota int) {
+	lb.listenerCertificates = make([]listenerCertificates, 0)
+
+	for _, port := range lb.portNumbers() {
+		certificates := lb.certificates[port]
+		if len(certificates) == 0 {
+			continue
+		}
+
+		plan := listenerCertificates{port: port, sni: []string{}, uncovered: []string{}, unknown: []string{}, missing: []string{}}
+
+		hostnames := make([]string, 0)
+		for _, description := range lb.descriptions {
+			for _, p := range listenerPorts(description.ListenerDescriptions) {
+				if p == port {
+					hostnames = append(hostnames, lb.Hostnames(*description.LoadBalancerName)...)
+				}
+			}
+		}
+		hostnames = distinctNames(hostnames)
+
+		// The default is the certificate which covers the most hostnames, or the first if none do
+		best, covered := 0, -1
+		for i, arn := range certificates {
+			certNames, ok := names[arn]
+			if ok && certNames == nil {
+				plan.missing = append(plan.missing, arn)
+			} else if !ok && names != nil {
+				plan.unknown = append(plan.unknown, arn)
+			}
+
+			count := 0
+			for _, hostname := range hostnames {
+				if certificateCovers(certNames, hostname) {
+					count++
+				}
+			}
+			if count > covered {
+				best, covered = i, count
+			}
+		}
+
+		plan.defaultCertificate = certificates[best]
+		for i, arn := range certificates {
+			if i != best {
+				plan.sni = append(plan.sni, arn)
+			}
+		}
+
+		if names != nil && len(plan.unknown) == 0 {
+			for _, hostname := range hostnames {
+				if !anyCertificateCovers(certificates, names, hostname) {
+					plan.uncovered = append(plan.uncovered, hostname)
+				}
+			}
+		}
+
+		if len(plan.sni) > quota {
+			plan.exceeded = &quotaSplit{quota: quotaCertificates, limit: quota}
+		}
+
+		lb.listenerCertificates = append(lb.listenerCertificates, plan)
+	}
+}
+
+func anyCertificateCovers(certificates []string, names map[string][]string, hostname string) bool {
+	for _, arn := range certificates {
+		if certificateCovers(names[arn], hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// Certificates returns the non-nil array of the certificates needed by each port which has any, in
+// ascending order of port
+func (lb *LB) Certificates() []listenerCertificates {
+	if lb.listenerCertificates == nil {
+		return []listenerCertificates{}
+	}
+	return lb.listenerCertificates
+}
+
+// certificatesFor returns the certificates for the port, with the default first
+func (lb *LB) certificatesFor(port int) []string {
+	for _, plan := range lb.Certificates() {
+		if plan.port == port {
+			return append([]string{plan.defaultCertificate}, plan.sni...)
+		}
+	}
+	return lb.certificates[port]
+}
+
+// planCertificates chooses the certificates for the listeners of every ALB and NLB in the tier. A
+// classic ELB can only have one certificate on each listener, so it keeps what it has.
+func (r *recommendation) planCertificates(names map[string][]string, quota int) {
+	for _, lbs := range [][]*LB{r.albs, r.nlbs} {
+		for _, lb := range lbs {
+			lb.planCertificates(names, quota)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeACM is a stand-in for ACM which knows some certificates, keyed by ARN
+type fakeACM struct {
+	acmiface.ACMAPI
+	certificates map[string]*acm.CertificateDetail
+	err          error
+}
+
+func (f *fakeACM) DescribeCertificate(input *acm.DescribeCertificateInput) (*acm.DescribeCertificateOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	cert, ok := f.certificates[*input.CertificateArn]
+	if !ok {
+		return nil, awserr.New(acm.ErrCodeResourceNotFoundException, "not found", nil)
+	}
+	return &acm.DescribeCertificateOutput{Certificate: cert}, nil
+}
+
+// fakeIAM is a stand-in for IAM which knows some server certificates, keyed by name
+type fakeIAM struct {
+	iamiface.IAMAPI
+	bodies map[string]string
+}
+
+func (f *fakeIAM) GetServerCertificate(input *iam.GetServerCertificateInput) (*iam.GetServerCertificateOutput, error) {
+	body, ok := f.bodies[*input.ServerCertificateName]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "not found", nil)
+	}
+	return &iam.GetServerCertificateOutput{ServerCertificate: &iam.ServerCertificate{CertificateBody: aws.String(body)}}, nil
+}
+
+// selfSignedPEM creates a certificate for the names, in the form that IAM returns it
+func selfSignedPEM(t *testing.T, commonName string, names ...string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     names,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestCertificateNamesAreReadFromACMAndIAM(t *testing.T) {
+	elbs := []*elb.LoadBalancerDescription{
+		createELB("web").
+			withSubnets("a").
+			withListenerDescriptions(
+				listenerDescription{port: 443, protocol: "HTTPS", certificate: "arn:aws:acm:eu-west-1:111111111111:certificate/web"},
+				listenerDescription{port: 8443, protocol: "HTTPS", certificate: "arn:aws:acm:eu-west-1:111111111111:certificate/deleted"},
+			).
+			build(),
+		createELB("legacy").
+			withSubnets("a").
+			withListenerDescriptions(
+				listenerDescription{port: 443, protocol: "HTTPS", certificate: "arn:aws:iam::111111111111:server-certificate/legacy/old"},
+				listenerDescription{port: 636, protocol: "SSL", certificate: "arn:aws:iam::111111111111:server-certificate/ldap"},
+			).
+			build(),
+	}
+
+	acmSvc := &fakeACM{certificates: map[string]*acm.CertificateDetail{
+		"arn:aws:acm:eu-west-1:111111111111:certificate/web": {
+			DomainName:              aws.String("www.example.com"),
+			SubjectAlternativeNames: aws.StringSlice([]string{"www.example.com", "API.example.com"}),
+		},
+	}}
+	iamSvc := &fakeIAM{bodies: map[string]string{
+		"old":  selfSignedPEM(t, "old.example.com", "old.example.com", "*.old.example.com"),
+		"ldap": selfSignedPEM(t, "ldap.example.com"),
+	}}
+
+	names, warnings, err := collectCertificateNames(elbs, acmSvc, iamSvc)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, warnings)
+	assert.Equal(t, map[string][]string{
+		"arn:aws:acm:eu-west-1:111111111111:certificate/web":      {"api.example.com", "www.example.com"},
+		"arn:aws:acm:eu-west-1:111111111111:certificate/deleted":  nil,
+		"arn:aws:iam::111111111111:server-certificate/legacy/old": {"*.old.example.com", "old.example.com"},
+		"arn:aws:iam::111111111111:server-certificate/ldap":       {"ldap.example.com"},
+	}, names, "Deleted certificates have no names")
+
+	_, _, err = collectCertificateNames(elbs, &fakeACM{err: awserr.New("ThrottlingException", "slow down", nil)}, iamSvc)
+	assert.Equal(t, exitThrottled, exitCodeFor(err))
+}
+
+func TestCertificatesWhichCantBeReadAreUnknown(t *testing.T) {
+	elbs := []*elb.LoadBalancerDescription{
+		createELB("web").
+			withSubnets("a").
+			withListenerDescriptions(
+				listenerDescription{port: 443, protocol: "HTTPS", certificate: "arn:aws:acm:eu-west-1:111111111111:certificate/web"},
+			).
+			build(),
+		createELB("ldap").
+			withSubnets("a").
+			withListenerDescriptions(
+				listenerDescription{port: 636, protocol: "SSL", certificate: "arn:aws:iam::111111111111:server-certificate/ldap"},
+			).
+			build(),
+	}
+	iamSvc := &fakeIAM{bodies: map[string]string{"ldap": selfSignedPEM(t, "ldap.example.com")}}
+
+	names, warnings, err := collectCertificateNames(elbs, &fakeACM{err: awserr.New("AccessDeniedException", "denied", nil)}, iamSvc)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"arn:aws:iam::111111111111:server-certificate/ldap": {"ldap.example.com"}}, names)
+	assert.Equal(t, 1, len(warnings))
+	assert.Contains(t, warnings[0], "arn:aws:acm:eu-west-1:111111111111:certificate/web")
+}
+
+func TestWildcardsOnlyCoverOneLabel(t *testing.T) {
+	names := []string{"*.example.com", "example.org"}
+
+	assert.True(t, certificateCovers(names, "www.example.com"))
+	assert.True(t, certificateCovers(names, "WWW.Example.com"))
+	assert.True(t, certificateCovers(names, "example.org"))
+	assert.False(t, certificateCovers(names, "example.com"))
+	assert.False(t, certificateCovers(names, "a.b.example.com"))
+	assert.False(t, certificateCovers(names, "www.example.org"))
+}
+
+// sniFixture is three HTTPS ELBs which share an ALB, each with its own certificate
+func sniFixture() *inventory {
+	inv := tierInventory()
+	for _, name := range []string{"api", "shop", "web"} {
+		inv.elbs = append(inv.elbs, elbInTier(name, listenerDescription{port: 443, protocol: "HTTPS", certificate: "arn:cert/" + name}).build())
+	}
+	inv.hostnames = map[string][]string{
+		"api":  {"api.example.com"},
+		"shop": {"shop.example.org"},
+		"web":  {"example.com", "www.example.com"},
+	}
+	inv.certificates = map[string][]string{
+		"arn:cert/api":  {"api.example.com"},
+		"arn:cert/shop": {"shop.example.net"},
+		"arn:cert/web":  {"*.example.com", "example.com"},
+	}
+	return inv
+}
+
+func TestTheDefaultCertificateCoversTheMostHostnames(t *testing.T) {
+	recommendations, _ := analyse(sniFixture(), &analysisOptions{sgPolicy: sgPolicyEquivalent})
+
+	alb := recommendations[0].ALBs()[0]
+	assert.Equal(t, []string{"api", "shop", "web"}, alb.ELBs())
+	assert.Equal(t, []listenerCertificates{
+		{
+			port:               443,
+			defaultCertificate: "arn:cert/web",
+			sni:                []string{"arn:cert/api", "arn:cert/shop"},
+			uncovered:          []string{"shop.example.org"},
+			unknown:            []string{},
+			missing:            []string{},
+		},
+	}, alb.Certificates())
+	assert.Equal(t, []string{"arn:cert/web", "arn:cert/api", "arn:cert/shop"}, alb.certificatesFor(443))
+
+	listeners := planLB(alb, "application", []string{"alb"}, []string{"a"}).listeners
+	assert.Equal(t, []string{"arn:cert/web", "arn:cert/api", "arn:cert/shop"}, listeners[0].certificates, "The default certificate is emitted first")
+}
+
+func TestCoverageIsOnlyCheckedWhenEveryCertificateIsKnown(t *testing.T) {
+	inv := sniFixture()
+	delete(inv.certificates, "arn:cert/shop")
+
+	recommendations, _ := analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent})
+	plan := recommendations[0].ALBs()[0].Certificates()[0]
+	assert.Equal(t, "arn:cert/web", plan.defaultCertificate)
+	assert.Equal(t, []string{"arn:cert/shop"}, plan.unknown)
+	assert.Equal(t, []string{}, plan.uncovered)
+
+	// Without any names, such as from an older snapshot, the first ELB's certificate is the default
+	inv.certificates = nil
+	recommendations, _ = analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent})
+	plan = recommendations[0].ALBs()[0].Certificates()[0]
+	assert.Equal(t, "arn:cert/api", plan.defaultCertificate)
+	assert.Equal(t, []string{}, plan.unknown)
+}
+
+func TestTooManySNICertificatesAreFlagged(t *testing.T) {
+	quotas := &albQuotas{Rules: 100, Certificates: 1, TargetGroups: 100, Listeners: 50}
+	recommendations, _ := analyse(sniFixture(), &analysisOptions{sgPolicy: sgPolicyEquivalent, quotas: quotas})
+
+	albs := recommendations[0].ALBs()
+	assert.Equal(t, 1, len(albs), "Certificates don't split an ALB")
+	assert.Equal(t, &quotaSplit{quota: quotaCertificates, limit: 1}, albs[0].Certificates()[0].exceeded)
+
+	albs[0].planCertificates(sniFixture().certificates, 2)
+	assert.Nil(t, albs[0].Certificates()[0].exceeded)
+}
+
+func TestCertificatesWhichNoLongerExistAreMissing(t *testing.T) {
+	inv := sniFixture()
+	inv.certificates["arn:cert/shop"] = nil
+
+	recommendations, _ := analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent})
+	plan := recommendations[0].ALBs()[0].Certificates()[0]
+	assert.Equal(t, []string{"arn:cert/shop"}, plan.missing)
+	assert.Equal(t, []string{}, plan.unknown)
+	assert.Equal(t, []string{"shop.example.org"}, plan.uncovered)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cfnTemplateVersion is the only version of the CloudFormation template format that exists
+const cfnTemplateVersion = "2010-09-09"
+
+// cloudFormationEmitter renders each recommendation as a CloudFormation template, containing the
+// AWS::ElasticLoadBalancingV2 resources for every recommended ALB and NLB in the tier.
+type cloudFormationEmitter struct {
+	format string // "yaml" or "json"
+}
+
+func (e *cloudFormationEmitter) emit(dir string, reports []report) error {
+	for _, tier := range planReports(reports) {
+		content, err := renderCloudFormation(tier, e.format)
+		if err != nil {
+			return err
+		}
+
+		if err := writeFile(dir, identifier("-", tier.name...)+"."+e.format, content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type cfnTemplate struct {
+	AWSTemplateFormatVersion string                   `json:"AWSTemplateFormatVersion" yaml:"AWSTemplateFormatVersion"`
+	Description              string                   `json:"Description" yaml:"Description"`
+	Parameters               map[string]*cfnParameter `json:"Parameters,omitempty" yaml:"Parameters,omitempty"`
+	Resources                map[string]*cfnResource  `json:"Resources" yaml:"Resources"`
+}
+
+type cfnParameter struct {
+	Type        string `json:"Type" yaml:"Type"`
+	Description string `json:"Description" yaml:"Description"`
+}
+
+type cfnResource struct {
+	Type       string         `json:"Type" yaml:"Type"`
+	Metadata   map[string]any `json:"Metadata,omitempty" yaml:"Metadata,omitempty"`
+	Properties map[string]any `json:"Properties" yaml:"Properties"`
+}
+
+// renderCloudFormation renders the tier as a template. Both YAML and JSON write maps in key order,
+// so the output is deterministic.
+func renderCloudFormation(tier *tierPlan, format string) ([]byte, error) {
+	template := newCFNTemplate(tier)
+
+	var buf bytes.Buffer
+
+	if format == "json" {
+		encoder := json.NewEncoder(&buf)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(template); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(template); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func newCFNTemplate(tier *tierPlan) *cfnTemplate {
+	description := fmt.Sprintf("Load balancers for the subnets %s in %s, generated by elb-pruner",
+		strings.Join(tier.subnets, ", "), tier.region)
+	if tier.account != "" {
+		description = fmt.Sprintf("Load balancers for the subnets %s in %s of account %s, generated by elb-pruner",
+			strings.Join(tier.subnets, ", "), tier.region, tier.account)
+	}
+
+	res := &cfnTemplate{
+		AWSTemplateFormatVersion: cfnTemplateVersion,
+		Description:              description,
+		Parameters:               make(map[string]*cfnParameter),
+		Resources:                make(map[string]*cfnResource),
+	}
+
+	// Logical IDs only need to be unique within the template, so we leave out the tier. Parameters and
+	// resources share the IDs.
+	ids := newUniqueIdentifiers("")
+
+	for _, plan := range tier.lbs {
+		lbID := ids.unique(logicalID(plan.name[len(tier.name):]...))
+		// The last part of a target group's name tells it apart from the LB's others
+		targetGroupID := func(tg *targetGroupPlan) string {
+			return ids.of(tg, lbID+logicalID("target group", tg.name[len(tg.name)-1]))
+		}
+
+		scheme := "internet-facing"
+		if plan.internal {
+			scheme = "internal"
+		}
+
+		// The ELBs go in the metadata rather than a tag, since there can be more of them than fit in a
+		// tag's 256 characters
+		lb := &cfnResource{
+			Type:     "AWS::ElasticLoadBalancingV2::LoadBalancer",
+			Metadata: map[string]any{"elb-pruner:replaces": plan.elbs},
+			Properties: map[string]any{
+				"Type":    plan.lbType,
+				"Scheme":  scheme,
+				"Subnets": plan.subnets,
+			},
+		}
+		if plan.lbType == "application" {
+			lb.Properties["SecurityGroups"] = plan.securityGroups
+		}
+		res.Resources[lbID] = lb
+
+		for _, tg := range plan.targetGroups {
+			resource := &cfnResource{
+				Type: "AWS::ElasticLoadBalancingV2::TargetGroup",
+				Properties: map[string]any{
+					"Port":       tg.port,
+					"Protocol":   tg.protocol,
+					"VpcId":      tg.vpc,
+					"TargetType": "instance",
+				},
+			}
+			if tg.review != "" {
+				resource.Metadata = map[string]any{"elb-pruner:review": tg.review}
+			}
+			if tg.healthCheck != nil {
+				cfnHealthCheck(resource, tg)
+			}
+			res.Resources[targetGroupID(tg)] = resource
+		}
+
+		for _, listener := range plan.listeners {
+			listenerID := ids.unique(lbID + logicalID("listener", strconv.Itoa(listener.port)))
+
+			properties := map[string]any{
+				"LoadBalancerArn": ref(lbID),
+				"Port":            listener.port,
+				"Protocol":        listener.protocol,
+			}
+
+			if listener.terminatesTLS() {
+				if len(listener.certificates) > 0 {
+					properties["Certificates"] = []map[string]any{{"CertificateArn": listener.certificates[0]}}
+				} else {
+					parameter := ids.unique(listenerID + "CertificateArn")
+					res.Parameters[parameter] = &cfnParameter{
+						Type:        "String",
+						Description: fmt.Sprintf("The ARN of the certificate for port %d of %s", listener.port, lbID),
+					}
+					properties["Certificates"] = []map[string]any{{"CertificateArn": ref(parameter)}}
+				}
+			}
+
+			if listener.defaultTarget != nil {
+				properties["DefaultActions"] = []map[string]any{forward(targetGroupID(listener.defaultTarget))}
+			} else {
+				properties["DefaultActions"] = []map[string]any{
+					{
+						"Type": "fixed-response",
+						"FixedResponseConfig": map[string]any{
+							"ContentType": "text/plain",
+							"StatusCode":  "404",
+						},
+					},
+				}
+			}
+
+			res.Resources[listenerID] = &cfnResource{
+				Type:       "AWS::ElasticLoadBalancingV2::Listener",
+				Properties: properties,
+			}
+
+			if len(listener.certificates) > 1 {
+				certificates := make([]map[string]any, 0, len(listener.certificates)-1)
+				for _, cert := range listener.certificates[1:] {
+					certificates = append(certificates, map[string]any{"CertificateArn": cert})
+				}
+				res.Resources[ids.unique(listenerID+"Certificates")] = &cfnResource{
+					Type: "AWS::ElasticLoadBalancingV2::ListenerCertificate",
+					Properties: map[string]any{
+						"ListenerArn":  ref(listenerID),
+						"Certificates": certificates,
+					},
+				}
+			}
+
+			for _, rule := range listener.rules {
+				ruleID := ids.unique(listenerID + logicalID("rule", rule.target.elb))
+
+				// The Route 53 names for the ELB, or a parameter if we didn't find any
+				var hostHeaders any = rule.hostnames
+				if len(rule.hostnames) == 0 {
+					parameter := ids.unique(ruleID + "HostHeaders")
+					res.Parameters[parameter] = &cfnParameter{
+						Type:        "CommaDelimitedList",
+						Description: fmt.Sprintf("The hostnames served by the ELB %s on port %d", rule.target.elb, listener.port),
+					}
+					hostHeaders = ref(parameter)
+				}
+
+				res.Resources[ruleID] = &cfnResource{
+					Type: "AWS::ElasticLoadBalancingV2::ListenerRule",
+					Properties: map[string]any{
+						"ListenerArn": ref(listenerID),
+						"Priority":    rule.priority,
+						"Actions":     []map[string]any{forward(targetGroupID(rule.target))},
+						"Conditions": []map[string]any{
+							{
+								"Field":            "host-header",
+								"HostHeaderConfig": map[string]any{"Values": hostHeaders},
+							},
+						},
+					},
+				}
+			}
+		}
+	}
+
+	return res
+}
+
+// cfnHealthCheck adds the target group's health check to its properties. Templates can't have
+// comments, so how it differs from the ELB's goes in the resource's metadata.
+func cfnHealthCheck(resource *cfnResource, tg *targetGroupPlan) {
+	hc := tg.healthCheck
+
+	if hc.protocol != "" {
+		resource.Properties["HealthCheckProtocol"] = hc.protocol
+		resource.Properties["HealthCheckPort"] = tg.healthCheckPort()
+	}
+	if hc.path != "" {
+		resource.Properties["HealthCheckPath"] = hc.path
+	}
+	if hc.matcher != "" {
+		resource.Properties["Matcher"] = map[string]any{"HttpCode": hc.matcher}
+	}
+	resource.Properties["HealthCheckIntervalSeconds"] = hc.interval
+	resource.Properties["HealthCheckTimeoutSeconds"] = hc.timeout
+	resource.Properties["HealthyThresholdCount"] = hc.healthyThreshold
+	resource.Properties["UnhealthyThresholdCount"] = hc.unhealthyThreshold
+
+	if len(hc.differences) > 0 {
+		if resource.Metadata == nil {
+			resource.Metadata = make(map[string]any)
+		}
+		resource.Metadata["elb-pruner:healthCheckDifferences"] = hc.differences
+	}
+}
+
+// logicalID turns the name parts into an alphanumeric CloudFormation logical ID, such as
+// Alb1TargetGroupWeb
+func logicalID(parts ...string) string {
+	var res strings.Builder
+	for _, word := range strings.Split(identifier(" ", parts...), " ") {
+		if word != "" {
+			res.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return res.String()
+}
+
+func ref(logicalID string) map[string]string {
+	return map[string]string{"Ref": logicalID}
+}
+
+func forward(targetGroupID string) map[string]any {
+	return map[string]any{
+		"Type":           "forward",
+		"TargetGroupArn": ref(targetGroupID),
+	}
+}
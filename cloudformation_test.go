@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestCloudFormationContainsTheLoadBalancerResources(t *testing.T) {
+	tiers := planReports(terraformFixture())
+	assert.Equal(t, 1, len(tiers))
+
+	content, err := renderCloudFormation(tiers[0], "yaml")
+	assert.NoError(t, err)
+
+	var template cfnTemplate
+	assert.NoError(t, yaml.Unmarshal(content, &template))
+
+	assert.Equal(t, cfnTemplateVersion, template.AWSTemplateFormatVersion)
+
+	types := make(map[string]string)
+	for id, resource := range template.Resources {
+		types[id] = resource.Type
+	}
+
+	assert.Equal(t, map[string]string{
+		"Alb1":                        "AWS::ElasticLoadBalancingV2::LoadBalancer",
+		"Alb1TargetGroupWeb":          "AWS::ElasticLoadBalancingV2::TargetGroup",
+		"Alb1TargetGroupApi":          "AWS::ElasticLoadBalancingV2::TargetGroup",
+		"Alb1Listener80":              "AWS::ElasticLoadBalancingV2::Listener",
+		"Alb1Listener443":             "AWS::ElasticLoadBalancingV2::Listener",
+		"Alb1Listener443Certificates": "AWS::ElasticLoadBalancingV2::ListenerCertificate",
+		"Alb1Listener443RuleWeb":      "AWS::ElasticLoadBalancingV2::ListenerRule",
+		"Alb1Listener443RuleApi":      "AWS::ElasticLoadBalancingV2::ListenerRule",
+		"Nlb1":                        "AWS::ElasticLoadBalancingV2::LoadBalancer",
+		"Nlb1TargetGroupCache":        "AWS::ElasticLoadBalancingV2::TargetGroup",
+		"Nlb1TargetGroupLdap":         "AWS::ElasticLoadBalancingV2::TargetGroup",
+		"Nlb1Listener636":             "AWS::ElasticLoadBalancingV2::Listener",
+		"Nlb1Listener11211":           "AWS::ElasticLoadBalancingV2::Listener",
+	}, types)
+
+	alb := template.Resources["Alb1"].Properties
+	assert.Equal(t, "application", alb["Type"])
+	assert.Equal(t, []any{"sg-1"}, alb["SecurityGroups"])
+	assert.Equal(t, []any{"subnet-a", "subnet-b"}, alb["Subnets"])
+
+	tg := template.Resources["Alb1TargetGroupApi"].Properties
+	assert.Equal(t, 9000, tg["Port"])
+	assert.Equal(t, "vpc-1", tg["VpcId"])
+
+	rule := template.Resources["Alb1Listener443RuleWeb"].Properties
+	assert.Equal(t, map[string]any{"Ref": "Alb1Listener443"}, rule["ListenerArn"])
+	assert.Equal(t, 2, rule["Priority"])
+
+	tls := template.Resources["Nlb1Listener636"].Properties
+	assert.Equal(t, "TLS", tls["Protocol"])
+	assert.Equal(t, []any{map[string]any{"CertificateArn": "arn:cert/ldap"}}, tls["Certificates"])
+
+	assert.Equal(t, "CommaDelimitedList", template.Parameters["Alb1Listener443RuleApiHostHeaders"].Type)
+	assert.Equal(t, "CommaDelimitedList", template.Parameters["Alb1Listener443RuleWebHostHeaders"].Type)
+}
+
+func TestCloudFormationAsksForMissingCertificates(t *testing.T) {
+	reports := terraformFixture()
+	for _, lb := range reports[0].recommendations[0].ALBs() {
+		lb.certificates = make(map[int][]string)
+		lb.planCertificates(nil, defaultALBQuotas().Certificates)
+	}
+
+	content, err := renderCloudFormation(planReports(reports)[0], "json")
+	assert.NoError(t, err)
+
+	var template cfnTemplate
+	assert.NoError(t, json.Unmarshal(content, &template))
+
+	assert.Equal(t, "String", template.Parameters["Alb1Listener443CertificateArn"].Type)
+	assert.Equal(t,
+		[]any{map[string]any{"CertificateArn": map[string]any{"Ref": "Alb1Listener443CertificateArn"}}},
+		template.Resources["Alb1Listener443"].Properties["Certificates"])
+	assert.NotContains(t, template.Resources, "Alb1Listener443Certificates")
+}
+
+func TestCloudFormationEmitterWritesATemplatePerTier(t *testing.T) {
+	dir := t.TempDir()
+
+	assert.NoError(t, emitters["cloudformation"].emit(dir, terraformFixture()))
+	assert.NoError(t, emitters["cloudformation-json"].emit(dir, terraformFixture()))
+
+	yamlContent, err := os.ReadFile(filepath.Join(dir, "eu-west-1-tier1.yaml"))
+	assert.NoError(t, err)
+
+	jsonContent, err := os.ReadFile(filepath.Join(dir, "eu-west-1-tier1.json"))
+	assert.NoError(t, err)
+
+	var fromYAML, fromJSON map[string]any
+	assert.NoError(t, yaml.Unmarshal(yamlContent, &fromYAML))
+	assert.NoError(t, yaml.Unmarshal(jsonContent, &fromJSON))
+	assert.Equal(t, fromYAML, fromJSON, "Both formats describe the same template")
+}
+
+func TestCloudFormationIsDeterministic(t *testing.T) {
+	first, err := renderCloudFormation(planReports(terraformFixture())[0], "yaml")
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		again, err := renderCloudFormation(planReports(terraformFixture())[0], "yaml")
+		assert.NoError(t, err)
+		assert.Equal(t, first, again)
+	}
+}
+
+func TestCloudFormationHasATargetGroupForEachBackend(t *testing.T) {
+	recommendations, _ := generateRecommendations(backendsFixture(), make(map[string]*ec2.SecurityGroup))
+
+	content, err := renderCloudFormation(planReports([]report{{region: "eu-west-1", recommendations: recommendations}})[0], "yaml")
+	assert.NoError(t, err)
+
+	var template cfnTemplate
+	assert.NoError(t, yaml.Unmarshal(content, &template))
+
+	tg := template.Resources["Alb1TargetGroupWebHttps8443"].Properties
+	assert.Equal(t, "HTTPS", tg["Protocol"])
+	assert.Equal(t, 8443, tg["Port"])
+	assert.Contains(t, template.Resources, "Alb1TargetGroupWebHttp8080")
+	assert.Contains(t, template.Resources, "Alb1TargetGroupApi")
+
+	listener := template.Resources["Alb1Listener8443"].Properties
+	assert.Equal(t, []any{map[string]any{
+		"Type":           "forward",
+		"TargetGroupArn": map[string]any{"Ref": "Alb1TargetGroupWebHttps8443"},
+	}}, listener["DefaultActions"])
+}
+
+func TestCloudFormationTargetGroupsHaveTheELBsHealthChecks(t *testing.T) {
+	recommendations, _ := generateRecommendations(healthChecksFixture(), make(map[string]*ec2.SecurityGroup))
+
+	content, err := renderCloudFormation(planReports([]report{{region: "eu-west-1", recommendations: recommendations}})[0], "yaml")
+	assert.NoError(t, err)
+
+	var template cfnTemplate
+	assert.NoError(t, yaml.Unmarshal(content, &template))
+
+	web := template.Resources["Alb1TargetGroupWeb"]
+	assert.Equal(t, "HTTP", web.Properties["HealthCheckProtocol"])
+	assert.Equal(t, "traffic-port", web.Properties["HealthCheckPort"])
+	assert.Equal(t, "/health", web.Properties["HealthCheckPath"])
+	assert.Equal(t, map[string]any{"HttpCode": "200"}, web.Properties["Matcher"])
+	assert.Equal(t, 30, web.Properties["HealthCheckIntervalSeconds"])
+	assert.Equal(t, 5, web.Properties["HealthCheckTimeoutSeconds"])
+	assert.Equal(t, 2, web.Properties["HealthyThresholdCount"])
+	assert.Equal(t, 10, web.Properties["UnhealthyThresholdCount"])
+	assert.Nil(t, web.Metadata)
+
+	admin := template.Resources["Alb1TargetGroupAdmin"]
+	assert.Equal(t, "9001", admin.Properties["HealthCheckPort"])
+	assert.Equal(t, []any{"ALBs can't check TCP, so it requests / with HTTP on port 9001, which must respond with a 200"},
+		admin.Metadata["elb-pruner:healthCheckDifferences"])
+
+	ldap := template.Resources["Nlb1TargetGroupLdap"].Properties
+	assert.Equal(t, "TCP", ldap["HealthCheckProtocol"])
+	assert.NotContains(t, ldap, "HealthCheckPath")
+	assert.NotContains(t, ldap, "Matcher")
+}
+
+// collidingNamesFixture adds an ELB called web-http-8080 to backendsFixture, whose target group has
+// the same name as the one for the port 8080 backend of web
+func collidingNamesFixture() []report {
+	elbs := append(backendsFixture(), elbInTier("web-http-8080",
+		listenerDescription{port: 443, protocol: "HTTPS", instanceProtocol: "HTTP", instancePort: 9090},
+	).build())
+	recommendations, _ := generateRecommendations(elbs, make(map[string]*ec2.SecurityGroup))
+	return []report{{region: "eu-west-1", recommendations: recommendations}}
+}
+
+func TestCloudFormationLogicalIDsAreUnique(t *testing.T) {
+	content, err := renderCloudFormation(planReports(collidingNamesFixture())[0], "yaml")
+	assert.NoError(t, err)
+
+	var template cfnTemplate
+	assert.NoError(t, yaml.Unmarshal(content, &template))
+
+	assert.Equal(t, 8080, template.Resources["Alb1TargetGroupWebHttp8080"].Properties["Port"])
+	assert.Equal(t, 9090, template.Resources["Alb1TargetGroupWebHttp80802"].Properties["Port"])
+
+	rule := template.Resources["Alb1Listener443RuleWebHttp8080"].Properties
+	assert.Equal(t, []any{map[string]any{
+		"Type":           "forward",
+		"TargetGroupArn": map[string]any{"Ref": "Alb1TargetGroupWebHttp80802"},
+	}}, rule["Actions"])
+}
+
+func TestCloudFormationListsTheReplacedELBsInTheMetadata(t *testing.T) {
+	content, err := renderCloudFormation(planReports(collidingNamesFixture())[0], "yaml")
+	assert.NoError(t, err)
+
+	var template cfnTemplate
+	assert.NoError(t, yaml.Unmarshal(content, &template))
+
+	alb := template.Resources["Alb1"]
+	assert.Equal(t, []any{"api", "web", "web-http-8080"}, alb.Metadata["elb-pruner:replaces"])
+	assert.NotContains(t, alb.Properties, "Tags")
+}
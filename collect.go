@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// allRegions is the value of the -regions flag which asks us to scan every region enabled for the
+// account.
+const allRegions = "all"
+
+// describeTagsLimit is the maximum number of load balancers that DescribeTags accepts in one call
+const describeTagsLimit = 20
+
+// inventory is everything that we read from a single region of an AWS account in order to make
+// recommendations.
+type inventory struct {
+	account        string                         // the account ID, or empty if we're only looking at the profile's account
+	region         string                         // the region that the load balancers live in
+	elbs           []*elb.LoadBalancerDescription // the classic load balancers in the region
+	securityGroups map[string]*ec2.SecurityGroup  // security groups attached to the ELBs, keyed by GroupId
+	prefixLists    map[string][]string            // the CIDRs of prefix lists used by the security groups, keyed by PrefixListId
+	subnets        map[string]*ec2.Subnet         // subnets that the ELBs live in, keyed by SubnetId
+	tags           map[string][]*elb.Tag          // tags of the ELBs, keyed by LoadBalancerName
+	hostnames      map[string][]string            // the Route 53 names which point at the ELBs, keyed by LoadBalancerName, or nil if Route 53 wasn't read
+	usage          map[string]*elbUsage           // the CloudWatch metrics of the ELBs, keyed by LoadBalancerName, or nil without -usage-window
+	certificates   map[string][]string            // the names that the certificates of the ELBs' listeners cover, keyed by ARN, or nil if they weren't read
+	warnings       []string                       // the details that we weren't allowed to read, so the recommendations are missing them
+}
+
+// report is the set of recommendations for a single region of an account. Load balancers can
+// never be consolidated across regions or accounts, so we keep each one separate.
+type report struct {
+	account         string // the account ID, or empty if we're only looking at the profile's account
+	region          string
+	recommendations []recommendation
+	skipped         []*classificationError // the ELBs that we couldn't classify, in order of name
+}
+
+// resolveRegions turns the value of the -regions flag into the list of regions to scan. An empty
+// value means the default region for the profile, and "all" means every region enabled for the
+// account.
+func resolveRegions(value string, defaultRegion string, ec2Svc ec2iface.EC2API) ([]string, error) {
+	if value == "" {
+		return []string{defaultRegion}, nil
+	}
+
+	if value == allRegions {
+		// Without AllRegions set, only the regions enabled for the account are returned
+		result, err := ec2Svc.DescribeRegions(&ec2.DescribeRegionsInput{})
+		if err != nil {
+			return nil, newAWSError("DescribeRegions", err)
+		}
+
+		res := make([]string, 0, len(result.Regions))
+		for _, region := range result.Regions {
+			res = append(res, *region.RegionName)
+		}
+		sort.Strings(res)
+		return res, nil
+	}
+
+	res := make([]string, 0)
+	for _, region := range strings.Split(value, ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			res = append(res, region)
+		}
+	}
+	return res, nil
+}
+
+// collectAccount reads each of the requested regions of an account. The usage of the ELBs is only
+// read from CloudWatch if the usage window is non-zero.
+func collectAccount(sess *session.Session, accountID string, regions string, usageWindow time.Duration) ([]*inventory, error) {
+	config := aws.NewConfig().WithMaxRetries(3)
+	res := make([]*inventory, 0)
+
+	resolved, err := resolveRegions(regions, aws.StringValue(sess.Config.Region), ec2.New(sess, config))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, region := range resolved {
+		inv, err := collectRegion(sess, region, usageWindow)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", region, err)
+		}
+		inv.account = accountID
+		res = append(res, inv)
+	}
+
+	if err := collectHostnames(res, route53.New(sess, config)); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// generateReports generates the recommendations for each inventory, which might have been read from
+// AWS or from a snapshot.
+func generateReports(inventories []*inventory, options *analysisOptions) []report {
+	res := make([]report, 0, len(inventories))
+
+	for _, inv := range inventories {
+		recommendations, skipped := analyse(inv, options)
+		res = append(res, report{
+			account:         inv.account,
+			region:          inv.region,
+			recommendations: recommendations,
+			skipped:         skipped,
+		})
+	}
+
+	return res
+}
+
+// collectRegion reads the ELBs and security groups for the specified region using the session.
+func collectRegion(sess *session.Session, region string, usageWindow time.Duration) (*inventory, error) {
+	// Do retries in case we hit the API too hard and get throttled for exceeding our allowed rate.
+	config := aws.NewConfig().WithRegion(region).WithMaxRetries(3)
+
+	inv, err := collectInventory(region, elb.New(sess, config), ec2.New(sess, config))
+	if err != nil {
+		return nil, err
+	}
+	if inv.certificates, inv.warnings, err = collectCertificateNames(inv.elbs, acm.New(sess, config), iam.New(sess, config)); err != nil {
+		return nil, err
+	}
+	if usageWindow > 0 {
+		if inv.usage, err = collectUsage(inv.elbs, cloudwatch.New(sess, config), usageWindow, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+// collectInventory reads the ELBs in a region, along with the security groups, subnets and tags
+// which they use.
+func collectInventory(region string, elbSvc elbiface.ELBAPI, ec2Svc ec2iface.EC2API) (*inventory, error) {
+	input := &elb.DescribeLoadBalancersInput{}
+	elbs := make([]*elb.LoadBalancerDescription, 0)
+
+	err := elbSvc.DescribeLoadBalancersPages(input, func(page *elb.DescribeLoadBalancersOutput, lastPage bool) bool {
+		elbs = append(elbs, page.LoadBalancerDescriptions...)
+		return !lastPage
+	})
+	if err != nil {
+		return nil, newAWSError("DescribeLoadBalancers", err)
+	}
+
+	sgs := make(map[string]*ec2.SecurityGroup)
+
+	for _, lb := range elbs {
+		if lb.SecurityGroups == nil {
+			continue
+		}
+
+		for _, sg := range lb.SecurityGroups {
+			if _, ok := sgs[*sg]; ok {
+				continue
+			}
+			result, err := ec2Svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+				GroupIds: []*string{
+					aws.String(*sg),
+				},
+			})
+			if err != nil {
+				return nil, newAWSError("DescribeSecurityGroups", err)
+			}
+			if len(result.SecurityGroups) == 0 {
+				return nil, &dataError{fmt.Errorf("the security group %s of %s wasn't found", *sg, *lb.LoadBalancerName)}
+			}
+			sgs[*sg] = result.SecurityGroups[0]
+		}
+	}
+
+	prefixLists, err := collectPrefixLists(sgs, ec2Svc)
+	if err != nil {
+		return nil, err
+	}
+
+	subnets, err := collectSubnets(elbs, ec2Svc)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := collectTags(elbs, elbSvc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &inventory{
+		region:         region,
+		elbs:           elbs,
+		securityGroups: sgs,
+		prefixLists:    prefixLists,
+		subnets:        subnets,
+		tags:           tags,
+	}, nil
+}
+
+// collectPrefixLists reads the entries of the managed prefix lists which the security groups allow
+// traffic from
+func collectPrefixLists(sgs map[string]*ec2.SecurityGroup, ec2Svc ec2iface.EC2API) (map[string][]string, error) {
+	res := make(map[string][]string)
+
+	seen := make(map[string]struct{})
+	for _, sg := range sgs {
+		for _, permission := range sg.IpPermissions {
+			for _, prefixList := range permission.PrefixListIds {
+				seen[*prefixList.PrefixListId] = struct{}{}
+			}
+		}
+	}
+
+	ids := make([]*string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, aws.String(id))
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return *ids[i] < *ids[j]
+	})
+
+	if len(ids) == 0 {
+		return res, nil
+	}
+
+	prefixLists := make([]*ec2.ManagedPrefixList, 0, len(ids))
+	err := ec2Svc.DescribeManagedPrefixListsPages(&ec2.DescribeManagedPrefixListsInput{PrefixListIds: ids}, func(page *ec2.DescribeManagedPrefixListsOutput, lastPage bool) bool {
+		prefixLists = append(prefixLists, page.PrefixLists...)
+		return !lastPage
+	})
+	if err != nil {
+		return nil, newAWSError("DescribeManagedPrefixLists", err)
+	}
+
+	for _, prefixList := range prefixLists {
+		cidrs := make([]string, 0)
+		input := &ec2.GetManagedPrefixListEntriesInput{PrefixListId: prefixList.PrefixListId}
+		err := ec2Svc.GetManagedPrefixListEntriesPages(input, func(page *ec2.GetManagedPrefixListEntriesOutput, lastPage bool) bool {
+			for _, entry := range page.Entries {
+				cidrs = append(cidrs, *entry.Cidr)
+			}
+			return !lastPage
+		})
+		if err != nil {
+			return nil, newAWSError("GetManagedPrefixListEntries", err)
+		}
+
+		res[*prefixList.PrefixListId] = cidrs
+	}
+
+	return res, nil
+}
+
+// collectSubnets reads the subnets that the ELBs live in
+func collectSubnets(elbs []*elb.LoadBalancerDescription, ec2Svc ec2iface.EC2API) (map[string]*ec2.Subnet, error) {
+	res := make(map[string]*ec2.Subnet)
+
+	ids := make([]*string, 0)
+	seen := make(map[string]struct{})
+	for _, lb := range elbs {
+		for _, subnet := range lb.Subnets {
+			if _, ok := seen[*subnet]; !ok {
+				seen[*subnet] = struct{}{}
+				ids = append(ids, subnet)
+			}
+		}
+	}
+
+	if len(ids) == 0 {
+		return res, nil
+	}
+
+	err := ec2Svc.DescribeSubnetsPages(&ec2.DescribeSubnetsInput{SubnetIds: ids}, func(page *ec2.DescribeSubnetsOutput, lastPage bool) bool {
+		for _, subnet := range page.Subnets {
+			res[*subnet.SubnetId] = subnet
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return nil, newAWSError("DescribeSubnets", err)
+	}
+
+	return res, nil
+}
+
+// collectTags reads the tags for the ELBs, in batches of as many as DescribeTags allows
+func collectTags(elbs []*elb.LoadBalancerDescription, elbSvc elbiface.ELBAPI) (map[string][]*elb.Tag, error) {
+	res := make(map[string][]*elb.Tag)
+
+	for i := 0; i < len(elbs); i += describeTagsLimit {
+		names := make([]*string, 0, describeTagsLimit)
+		for j := i; j < len(elbs) && j < i+describeTagsLimit; j++ {
+			names = append(names, elbs[j].LoadBalancerName)
+		}
+
+		result, err := elbSvc.DescribeTags(&elb.DescribeTagsInput{LoadBalancerNames: names})
+		if err != nil {
+			return nil, newAWSError("DescribeTags", err)
+		}
+
+		for _, description := range result.TagDescriptions {
+			res[*description.LoadBalancerName] = description.Tags
+		}
+	}
+
+	return res, nil
+}
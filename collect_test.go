@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeELB is a stand-in for the ELB API which returns a fixed set of load balancers
+type fakeELB struct {
+	elbiface.ELBAPI
+	elbs         []*elb.LoadBalancerDescription
+	tagLookups   int
+	maxTagLookup int
+}
+
+func (f *fakeELB) DescribeLoadBalancersPages(input *elb.DescribeLoadBalancersInput, fn func(*elb.DescribeLoadBalancersOutput, bool) bool) error {
+	fn(&elb.DescribeLoadBalancersOutput{LoadBalancerDescriptions: f.elbs}, true)
+	return nil
+}
+
+func (f *fakeELB) DescribeTags(input *elb.DescribeTagsInput) (*elb.DescribeTagsOutput, error) {
+	f.tagLookups++
+	if len(input.LoadBalancerNames) > f.maxTagLookup {
+		f.maxTagLookup = len(input.LoadBalancerNames)
+	}
+
+	res := &elb.DescribeTagsOutput{}
+	for _, name := range input.LoadBalancerNames {
+		res.TagDescriptions = append(res.TagDescriptions, &elb.TagDescription{
+			LoadBalancerName: name,
+			Tags:             []*elb.Tag{{Key: sPtr("Name"), Value: name}},
+		})
+	}
+	return res, nil
+}
+
+// fakeEC2 is a stand-in for the EC2 API which returns a fixed set of regions and security groups
+type fakeEC2 struct {
+	ec2iface.EC2API
+	regions        []string
+	securityGroups map[string]*ec2.SecurityGroup
+	prefixLists    map[string][]string
+	sgLookups      int
+}
+
+func (f *fakeEC2) DescribeManagedPrefixListsPages(input *ec2.DescribeManagedPrefixListsInput, fn func(*ec2.DescribeManagedPrefixListsOutput, bool) bool) error {
+	res := &ec2.DescribeManagedPrefixListsOutput{}
+	for _, id := range input.PrefixListIds {
+		if _, ok := f.prefixLists[*id]; ok {
+			res.PrefixLists = append(res.PrefixLists, &ec2.ManagedPrefixList{PrefixListId: id})
+		}
+	}
+	fn(res, true)
+	return nil
+}
+
+func (f *fakeEC2) GetManagedPrefixListEntriesPages(input *ec2.GetManagedPrefixListEntriesInput, fn func(*ec2.GetManagedPrefixListEntriesOutput, bool) bool) error {
+	// Return each entry on its own page, to check that we read all of them
+	entries := f.prefixLists[*input.PrefixListId]
+	for i := range entries {
+		page := &ec2.GetManagedPrefixListEntriesOutput{Entries: []*ec2.PrefixListEntry{{Cidr: &entries[i]}}}
+		if !fn(page, i == len(entries)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeEC2) DescribeSubnetsPages(input *ec2.DescribeSubnetsInput, fn func(*ec2.DescribeSubnetsOutput, bool) bool) error {
+	res := &ec2.DescribeSubnetsOutput{}
+	for _, id := range input.SubnetIds {
+		res.Subnets = append(res.Subnets, &ec2.Subnet{SubnetId: id, VpcId: sPtr("vpc-1")})
+	}
+	fn(res, true)
+	return nil
+}
+
+func (f *fakeEC2) DescribeRegions(input *ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
+	res := &ec2.DescribeRegionsOutput{}
+	for i := range f.regions {
+		res.Regions = append(res.Regions, &ec2.Region{RegionName: &f.regions[i]})
+	}
+	return res, nil
+}
+
+func (f *fakeEC2) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	f.sgLookups++
+	res := &ec2.DescribeSecurityGroupsOutput{}
+	for _, id := range input.GroupIds {
+		res.SecurityGroups = append(res.SecurityGroups, f.securityGroups[*id])
+	}
+	return res, nil
+}
+
+func TestResolveRegionsDefaultsToTheProfileRegion(t *testing.T) {
+	regions, err := resolveRegions("", "eu-west-1", &fakeEC2{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eu-west-1"}, regions)
+}
+
+func TestResolveRegionsSplitsTheList(t *testing.T) {
+	regions, err := resolveRegions("eu-west-1, eu-west-2,,us-east-1", "eu-west-1", &fakeEC2{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eu-west-1", "eu-west-2", "us-east-1"}, regions)
+}
+
+func TestResolveAllRegionsUsesTheEnabledRegions(t *testing.T) {
+	ec2Svc := &fakeEC2{regions: []string{"us-east-1", "eu-west-2", "eu-west-1"}}
+	regions, err := resolveRegions(allRegions, "eu-west-1", ec2Svc)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eu-west-1", "eu-west-2", "us-east-1"}, regions)
+}
+
+func TestCollectInventoryLooksUpEachSecurityGroupOnce(t *testing.T) {
+	elbSvc := &fakeELB{
+		elbs: []*elb.LoadBalancerDescription{
+			createELB("first").withSubnets("a").withSecurityGroups("sg-1").build(),
+			createELB("second").withSubnets("a").withSecurityGroups("sg-1", "sg-2").build(),
+		},
+	}
+	ec2Svc := &fakeEC2{
+		securityGroups: map[string]*ec2.SecurityGroup{
+			"sg-1": {GroupId: sPtr("sg-1")},
+			"sg-2": {GroupId: sPtr("sg-2")},
+		},
+	}
+
+	inv, err := collectInventory("eu-west-2", elbSvc, ec2Svc)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "eu-west-2", inv.region)
+	assert.Equal(t, 2, len(inv.elbs))
+	assert.Equal(t, 2, len(inv.securityGroups))
+	assert.Equal(t, 2, ec2Svc.sgLookups)
+	assert.Equal(t, 1, len(inv.subnets))
+	assert.Equal(t, "vpc-1", *inv.subnets["a"].VpcId)
+	assert.Equal(t, "first", *inv.tags["first"][0].Value)
+}
+
+func TestCollectInventoryReadsTagsInBatches(t *testing.T) {
+	elbSvc := &fakeELB{}
+	for i := 0; i < 45; i++ {
+		elbSvc.elbs = append(elbSvc.elbs, createELB(fmt.Sprintf("elb-%d", i)).withSubnets("a").build())
+	}
+
+	inv, err := collectInventory("eu-west-2", elbSvc, &fakeEC2{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 45, len(inv.tags))
+	assert.Equal(t, 3, elbSvc.tagLookups)
+	assert.Equal(t, describeTagsLimit, elbSvc.maxTagLookup)
+}
+
+func TestCollectInventoryResolvesPrefixLists(t *testing.T) {
+	elbSvc := &fakeELB{
+		elbs: []*elb.LoadBalancerDescription{
+			createELB("first").withSubnets("a").withSecurityGroups("sg-1", "sg-2").build(),
+		},
+	}
+	fromPrefixList := func(id string) []*ec2.IpPermission {
+		return []*ec2.IpPermission{
+			{
+				IpProtocol:    sPtr("tcp"),
+				FromPort:      int64Ptr(443),
+				PrefixListIds: []*ec2.PrefixListId{{PrefixListId: sPtr(id)}},
+			},
+		}
+	}
+	ec2Svc := &fakeEC2{
+		securityGroups: map[string]*ec2.SecurityGroup{
+			"sg-1": {GroupId: sPtr("sg-1"), IpPermissions: fromPrefixList("pl-1")},
+			"sg-2": {GroupId: sPtr("sg-2"), IpPermissions: fromPrefixList("pl-missing")},
+		},
+		prefixLists: map[string][]string{
+			"pl-1": {"10.0.0.0/8", "172.16.0.0/12"},
+		},
+	}
+
+	inv, err := collectInventory("eu-west-2", elbSvc, ec2Svc)
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string][]string{"pl-1": {"10.0.0.0/8", "172.16.0.0/12"}}, inv.prefixLists)
+}
+
+func TestRegionsAreTalliedSeparatelyAndCombined(t *testing.T) {
+	total := &tally{}
+	total.add(&tally{currentElbs: 3, albs: 1})
+	total.add(&tally{currentElbs: 2, nlbs: 1, elbs: 1})
+
+	assert.Equal(t, &tally{currentElbs: 5, albs: 1, nlbs: 1, elbs: 1}, total)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+)
+
+// emitter renders recommendations as infrastructure code, which can be reviewed and committed to a
+// repo. Emitters must produce identical output for identical input.
+type emitter interface {
+	emit(dir string, reports []report) error
+}
+
+// emitters are the supported values of the -emit flag
+var emitters = map[string]emitter{
+	"cloudformation":      &cloudFormationEmitter{format: "yaml"},
+	"cloudformation-json": &cloudFormationEmitter{format: "json"},
+	"terraform":           &terraformEmitter{},
+}
+
+// emitterNames returns the supported values of the -emit flag, in alphabetical order
+func emitterNames() []string {
+	res := make([]string, 0, len(emitters))
+	for name := range emitters {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// writeFile writes the content to the named file in dir, creating dir if needed
+func writeFile(dir, name string, content []byte) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), content, 0o644)
+}
+
+var invalidIdentifierChars = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// identifier joins the parts into a name which is safe to use as a Terraform resource name or a
+// CloudFormation logical ID, depending on the separator.
+func identifier(separator string, parts ...string) string {
+	cleaned := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.Trim(invalidIdentifierChars.ReplaceAllString(part, separator), separator); part != "" {
+			cleaned = append(cleaned, part)
+		}
+	}
+	return strings.Join(cleaned, separator)
+}
+
+// uniqueIdentifiers hands out identifiers which haven't been handed out before, by adding a number to
+// the end of any that has. Names such as a-b and a--b clean up to the same identifier, as do an ELB
+// called web-http-8080 and the target group for the port 8080 backend of an ELB called web.
+type uniqueIdentifiers struct {
+	separator string
+	ids       map[any]string // the identifier handed out for each thing that's referred to more than once
+	taken     map[string]struct{}
+}
+
+func newUniqueIdentifiers(separator string) *uniqueIdentifiers {
+	return &uniqueIdentifiers{
+		separator: separator,
+		ids:       make(map[any]string),
+		taken:     make(map[string]struct{}),
+	}
+}
+
+// unique returns the identifier, or the first of identifier2, identifier3 and so on which hasn't
+// been handed out
+func (u *uniqueIdentifiers) unique(id string) string {
+	res := id
+	for n := 2; ; n++ {
+		if _, ok := u.taken[res]; !ok {
+			break
+		}
+		res = id + u.separator + strconv.Itoa(n)
+	}
+	u.taken[res] = struct{}{}
+	return res
+}
+
+// of returns the identifier that the thing was handed out, handing it a unique one if it hasn't been
+func (u *uniqueIdentifiers) of(key any, id string) string {
+	if res, ok := u.ids[key]; ok {
+		return res
+	}
+	res := u.unique(id)
+	u.ids[key] = res
+	return res
+}
+
+// lbPlan is a description of the resources needed to build a recommended ALB or NLB, which is
+// independent of the format that they're emitted in.
+type lbPlan struct {
+	account        string // the account ID, or empty if we're only looking at the profile's account
+	region         string
+	name           []string // the parts of the name, which emitters turn into identifiers
+	lbType         string   // "application" or "network"
+	internal       bool
+	subnets        []string
+	securityGroups []string
+	elbs           []string           // the ELBs that the LB replaces
+	targetGroups   []*targetGroupPlan // one for each backend of each replaced ELB
+	listeners      []*listenerPlan    // one for each port, in ascending order
+}
+
+// targetGroupPlan is a target group which takes the place of one of the backends of a replaced ELB
+type targetGroupPlan struct {
+	name     []string
+	elb      string // the ELB whose instances belong in the target group
+	protocol string
+	port     int64
+	vpc      string
+	review   string // why the protocol needs checking before it's used, or empty
+
+	healthCheck *targetHealthCheck // or nil to keep the defaults
+}
+
+// healthCheckPort is the port that the target group's health check uses, as target groups name it
+func (tg *targetGroupPlan) healthCheckPort() string {
+	if tg.healthCheck.port == int(tg.port) {
+		return "traffic-port"
+	}
+	return strconv.Itoa(tg.healthCheck.port)
+}
+
+// listenerPlan is a listener on one of the LB's ports
+type listenerPlan struct {
+	name          []string
+	port          int
+	protocol      string
+	certificates  []string         // the certificates of the ELBs on this port, where the first is the default and the rest are for SNI
+	defaultTarget *targetGroupPlan // the target group to forward to when no rule matches, or nil for a 404
+	rules         []*rulePlan      // host-based routing rules when more than one ELB shares the port
+}
+
+// terminatesTLS is whether the listener needs a certificate
+func (l *listenerPlan) terminatesTLS() bool {
+	return l.protocol == "HTTPS" || l.protocol == "TLS"
+}
+
+// rulePlan routes requests for one of the replaced ELBs to its target group
+type rulePlan struct {
+	name      []string
+	priority  int
+	target    *targetGroupPlan
+	hostnames []string // the Route 53 names for the ELB, or empty if they need to be given
+}
+
+// extend returns a copy of the name parts with more parts on the end
+func extend(name []string, more ...string) []string {
+	return append(append([]string{}, name...), more...)
+}
+
+// tierPlan is the set of ALBs and NLBs that we recommend for a tier
+type tierPlan struct {
+	account string // the account ID, or empty if we're only looking at the profile's account
+	region  string
+	name    []string // the parts of the name, which emitters turn into identifiers
+	subnets []string
+	lbs     []*lbPlan
+}
+
+// planReports builds the plans for every ALB and NLB that we recommend, in a stable order
+func planReports(reports []report) []*tierPlan {
+	res := make([]*tierPlan, 0)
+
+	for _, r := range reports {
+		// Identifiers can't start with a digit, so account IDs need a prefix
+		scope := []string{r.region}
+		if r.account != "" {
+			scope = []string{"account", r.account, r.region}
+		}
+
+		for i, rec := range r.recommendations {
+			tier := &tierPlan{
+				account: r.account,
+				region:  r.region,
+				name:    extend(scope, "tier"+strconv.Itoa(i+1)),
+				subnets: rec.Subnets(),
+			}
+
+			for j, alb := range rec.ALBs() {
+				tier.lbs = append(tier.lbs, planLB(alb, "application", extend(tier.name, "alb"+strconv.Itoa(j+1)), rec.Subnets()))
+			}
+
+			for j, nlb := range rec.NLBs() {
+				tier.lbs = append(tier.lbs, planLB(nlb, "network", extend(tier.name, "nlb"+strconv.Itoa(j+1)), rec.Subnets()))
+			}
+
+			if len(tier.lbs) > 0 {
+				res = append(res, tier)
+			}
+		}
+	}
+
+	return res
+}
+
+// planLB works out the target groups, listeners and rules needed for an LB to replace its ELBs
+func planLB(lb *LB, lbType string, name []string, subnets []string) *lbPlan {
+	res := &lbPlan{
+		name:           name,
+		lbType:         lbType,
+		internal:       lb.Scheme() == "internal",
+		subnets:        subnets,
+		securityGroups: lb.SecurityGroups(),
+		elbs:           lb.ELBs(),
+	}
+
+	vpcs := make(map[string]string)
+	for _, description := range lb.descriptions {
+		vpcs[*description.LoadBalancerName] = aws.StringValue(description.VPCId)
+	}
+
+	// The target group that each ELB's listener on a port forwards to, keyed by ELB and then by port
+	targetGroups := make(map[string]map[int]*targetGroupPlan)
+
+	for _, group := range lb.TargetGroups() {
+		tg := &targetGroupPlan{
+			name:     extend(name, group.name),
+			elb:      group.elb,
+			protocol: targetProtocol(lbType, group.protocol, group.port),
+			port:     int64(group.port),
+			vpc:      vpcs[group.elb],
+
+			healthCheck: group.healthCheck,
+		}
+		if lbType == "application" {
+			tg.review = reviewBackend(group)
+		}
+
+		if targetGroups[tg.elb] == nil {
+			targetGroups[tg.elb] = make(map[int]*targetGroupPlan)
+		}
+		for _, port := range group.ports {
+			targetGroups[tg.elb][port] = tg
+		}
+		res.targetGroups = append(res.targetGroups, tg)
+	}
+
+	for _, port := range lb.portNumbers() {
+		listener := &listenerPlan{
+			name: extend(name, strconv.Itoa(port)),
+			port: port,
+		}
+
+		listener.certificates = lb.certificatesFor(port)
+		elbsOnPort := make([]string, 0)
+
+		for _, description := range lb.descriptions {
+			for _, ld := range description.ListenerDescriptions {
+				if int(*ld.Listener.LoadBalancerPort) != port {
+					continue
+				}
+
+				elbsOnPort = append(elbsOnPort, *description.LoadBalancerName)
+
+				if protocol := aws.StringValue(ld.Listener.Protocol); listener.protocol == "" || protocol == "HTTPS" || protocol == "SSL" {
+					listener.protocol = listenerProtocol(lbType, protocol, port)
+				}
+			}
+		}
+
+		if len(elbsOnPort) == 1 {
+			listener.defaultTarget = targetGroups[elbsOnPort[0]][port]
+		} else {
+			for i, name := range elbsOnPort {
+				listener.rules = append(listener.rules, &rulePlan{
+					name:      extend(listener.name, name),
+					priority:  i + 1,
+					target:    targetGroups[name][port],
+					hostnames: lb.hostnames[name],
+				})
+			}
+		}
+
+		res.listeners = append(res.listeners, listener)
+	}
+
+	return res
+}
+
+// sortedListeners returns the ELB's listeners in ascending order of port
+func sortedListeners(description *elb.LoadBalancerDescription) []*elb.Listener {
+	res := make([]*elb.Listener, 0, len(description.ListenerDescriptions))
+	for _, ld := range description.ListenerDescriptions {
+		res = append(res, ld.Listener)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return *res[i].LoadBalancerPort < *res[j].LoadBalancerPort
+	})
+	return res
+}
+
+// targetProtocol is the protocol that the target group uses to talk to the instances. An ALB
+// assumes that TCP to port 443 of the instances is TLS.
+func targetProtocol(lbType string, instanceProtocol string, instancePort int) string {
+	if lbType == "network" {
+		if instanceProtocol == "SSL" {
+			return "TLS"
+		}
+		return "TCP"
+	}
+	if instanceProtocol == "HTTPS" || instanceProtocol == "SSL" || (instanceProtocol == "TCP" && instancePort == 443) {
+		return "HTTPS"
+	}
+	return "HTTP"
+}
+
+// reviewBackend explains why an ALB's target group might have the wrong protocol, or returns empty.
+// When the ELB passed TCP through from port 443 to another port, we can't tell whether the
+// instances expect TLS.
+func reviewBackend(tg targetGroup) string {
+	if tg.protocol != "TCP" || tg.port == 443 {
+		return ""
+	}
+	for _, port := range tg.ports {
+		if port == 443 {
+			return fmt.Sprintf("the ELB passed TCP through from port 443 to port %d, so check whether the instances expect HTTP or HTTPS", tg.port)
+		}
+	}
+	return ""
+}
+
+// listenerProtocol is the protocol that the replacement listens with. An ALB terminates TLS for
+// anything on port 443, even if the ELB was passing TCP through. An NLB only terminates TLS where the
+// ELB had an SSL listener.
+func listenerProtocol(lbType string, elbProtocol string, port int) string {
+	if lbType == "network" {
+		if elbProtocol == "SSL" {
+			return "TLS"
+		}
+		return "TCP"
+	}
+	if elbProtocol == "HTTPS" || port == 443 {
+		return "HTTPS"
+	}
+	return "HTTP"
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// The exit codes, so that scripts can tell why a run failed. Problems with some of the accounts or
+// ELBs still give a report for the rest, before exiting with the code for the first problem.
+const (
+	exitFailure   = 1 // bad arguments, or any failure that isn't covered below
+	exitAuth      = 3 // AWS rejected the credentials, or a role couldn't be used
+	exitThrottled = 4 // AWS throttled us, even after retrying
+	exitData      = 5 // something that we read didn't make sense, such as an ELB that we couldn't classify
+)
+
+// authErrorCodes are the AWS error codes for missing, invalid or insufficient credentials
+var authErrorCodes = map[string]struct{}{
+	"AccessDenied":                {},
+	"AccessDeniedException":       {},
+	"AuthFailure":                 {},
+	"ExpiredToken":                {},
+	"ExpiredTokenException":       {},
+	"InvalidAccessKeyId":          {},
+	"InvalidClientTokenId":        {},
+	"NoCredentialProviders":       {},
+	"SignatureDoesNotMatch":       {},
+	"UnauthorizedOperation":       {},
+	"UnrecognizedClientException": {},
+}
+
+// accessDeniedCodes are the AWS error codes for credentials which work, but aren't allowed to make
+// the call. Calls for optional details are skipped when they get one of these.
+var accessDeniedCodes = map[string]struct{}{
+	"AccessDenied":          {},
+	"AccessDeniedException": {},
+	"UnauthorizedOperation": {},
+}
+
+// throttlingErrorCodes are the AWS error codes for calling an API too often
+var throttlingErrorCodes = map[string]struct{}{
+	"RequestLimitExceeded":      {},
+	"RequestThrottled":          {},
+	"RequestThrottledException": {},
+	"Throttling":                {},
+	"ThrottlingException":       {},
+	"TooManyRequestsException":  {},
+}
+
+// awsError is a failed call to an AWS API
+type awsError struct {
+	operation string // the API call, such as "DescribeLoadBalancers"
+	err       error
+}
+
+// newAWSError wraps an error from the AWS API, or returns nil if there wasn't one
+func newAWSError(operation string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &awsError{operation: operation, err: err}
+}
+
+func (e *awsError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.operation, e.err)
+}
+
+func (e *awsError) Unwrap() error {
+	return e.err
+}
+
+// code is the AWS error code, or empty if the error didn't come from AWS
+func (e *awsError) code() string {
+	var aerr awserr.Error
+	if errors.As(e.err, &aerr) {
+		return aerr.Code()
+	}
+	return ""
+}
+
+// isAccessDenied is whether AWS refused a call which the credentials aren't allowed to make
+func isAccessDenied(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	_, ok := accessDeniedCodes[aerr.Code()]
+	return ok
+}
+
+// authError is a problem with the credentials that isn't an AWS error, such as a role which is in
+// the wrong account
+type authError struct {
+	err error
+}
+
+func (e *authError) Error() string {
+	return e.err.Error()
+}
+
+func (e *authError) Unwrap() error {
+	return e.err
+}
+
+// dataError is something that we read which doesn't make sense, such as a malformed snapshot
+type dataError struct {
+	err error
+}
+
+func (e *dataError) Error() string {
+	return e.err.Error()
+}
+
+func (e *dataError) Unwrap() error {
+	return e.err
+}
+
+// classificationError is an ELB which we can't recommend a replacement for, so it's skipped
+type classificationError struct {
+	elb string // the name of the ELB
+	err error  // why we couldn't classify it
+}
+
+func (e *classificationError) Error() string {
+	return fmt.Sprintf("skipped %s: %v", e.elb, e.err)
+}
+
+func (e *classificationError) Unwrap() error {
+	return e.err
+}
+
+// exitCodeFor chooses the exit code for an error
+func exitCodeFor(err error) int {
+	var (
+		aerr *awsError
+		auth *authError
+		data *dataError
+		cerr *classificationError
+	)
+
+	switch {
+	case errors.As(err, &aerr):
+		if _, ok := authErrorCodes[aerr.code()]; ok {
+			return exitAuth
+		}
+		if _, ok := throttlingErrorCodes[aerr.code()]; ok {
+			return exitThrottled
+		}
+		return exitFailure
+	case errors.As(err, &auth):
+		return exitAuth
+	case errors.As(err, &data), errors.As(err, &cerr):
+		return exitData
+	default:
+		return exitFailure
+	}
+}
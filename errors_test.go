@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEachKindOfErrorHasItsOwnExitCode(t *testing.T) {
+	assert.Equal(t, exitAuth, exitCodeFor(newAWSError("DescribeLoadBalancers", awserr.New("AccessDenied", "denied", nil))))
+	assert.Equal(t, exitAuth, exitCodeFor(checkAccess(&fakeSTS{err: awserr.New("ExpiredToken", "expired", nil)}, "111111111111")))
+	assert.Equal(t, exitAuth, exitCodeFor(checkAccess(&fakeSTS{account: "222222222222"}, "111111111111")))
+	assert.Equal(t, exitThrottled, exitCodeFor(newAWSError("DescribeTags", awserr.New("Throttling", "Rate exceeded", nil))))
+	assert.Equal(t, exitFailure, exitCodeFor(newAWSError("DescribeTags", awserr.New("InternalFailure", "oops", nil))))
+	assert.Equal(t, exitFailure, exitCodeFor(errors.New("oops")))
+
+	_, err := readSnapshot(strings.NewReader("{"))
+	assert.Equal(t, exitData, exitCodeFor(err))
+
+	// Errors keep their kind when they're wrapped with more context
+	wrapped := fmt.Errorf("account 111111111111: %w", newAWSError("ListAccounts", awserr.New("ThrottlingException", "slow down", nil)))
+	assert.Equal(t, exitThrottled, exitCodeFor(wrapped))
+	assert.Nil(t, newAWSError("DescribeTags", nil))
+}
+
+// skippedFixture has an ELB with only listeners that we don't know, and one without any listeners,
+// alongside an ELB that we can classify
+func skippedFixture() *inventory {
+	return tierInventory(
+		elbInTier("udp", listenerDescription{port: 53, protocol: "UDP"}).build(),
+		elbInTier("web", listenerDescription{port: 80, protocol: "HTTP"}).withSubnets("b").build(),
+		elbInTier("bare").withSubnets("a", "b").build(),
+	)
+}
+
+func TestUnclassifiableELBsAreSkipped(t *testing.T) {
+	recommendations, skipped := analyse(skippedFixture(), &analysisOptions{sgPolicy: sgPolicyEquivalent})
+
+	assert.Equal(t, 2, len(skipped))
+	assert.Equal(t, "bare", skipped[0].elb)
+	assert.EqualError(t, skipped[0], "skipped bare: it has no listeners")
+	assert.Equal(t, "udp", skipped[1].elb)
+	assert.EqualError(t, skipped[1], "skipped udp: none of its listeners use HTTP, HTTPS, SSL or TCP (it has UDP on port 53)")
+	assert.Equal(t, exitData, exitCodeFor(skipped[0]))
+
+	// The skipped ELBs don't merge the tiers of their subnets
+	assert.Equal(t, 1, len(recommendations))
+	assert.Equal(t, []string{"b"}, recommendations[0].Subnets())
+	assert.Equal(t, []string{"web"}, recommendations[0].ALBs()[0].ELBs())
+
+	_, err := generateRecommendations(skippedFixture().elbs, make(map[string]*ec2.SecurityGroup))
+	assert.ErrorContains(t, err, "skipped udp")
+
+	var cerr *classificationError
+	assert.True(t, errors.As(err, &cerr))
+}
+
+func TestELBsWithoutSubnetsAreSkipped(t *testing.T) {
+	classic := elbInTier("classic", listenerDescription{port: 80, protocol: "HTTP"}).build()
+	classic.Subnets = nil
+
+	inv := skippedFixture()
+	inv.elbs = append(inv.elbs, classic)
+	inv.usage = map[string]*elbUsage{"classic": {}}
+
+	recommendations, skipped := analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent})
+
+	assert.Equal(t, 3, len(skipped))
+	assert.EqualError(t, skipped[1], "skipped classic: it has no subnets")
+	assert.Equal(t, 1, len(recommendations))
+	assert.Equal(t, []string{"web"}, recommendations[0].ALBs()[0].ELBs())
+}
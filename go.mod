@@ -5,11 +5,11 @@ go 1.20
 require (
 	github.com/aws/aws-sdk-go v1.45.25
 	github.com/stretchr/testify v1.7.0
+	gopkg.in/yaml.v3 v3.0.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.0 // indirect
 )
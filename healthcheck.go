@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+)
+
+// targetHealthCheck is the health check of a target group, translated from the health check of the
+// ELB whose instances it contains
+type targetHealthCheck struct {
+	protocol           string   // "HTTP", "HTTPS" or "TCP", or empty if the ELB's target couldn't be read
+	port               int      // the port that the ELB checked, which needn't be its backend's
+	path               string   // the path that HTTP and HTTPS checks request
+	matcher            string   // the HTTP codes of a healthy response, for HTTP and HTTPS checks
+	interval           int      // seconds between checks
+	timeout            int      // seconds without a response before a check fails
+	healthyThreshold   int      // successful checks in a row before an instance is healthy
+	unhealthyThreshold int      // failed checks in a row before an instance is unhealthy
+	differences        []string // how the check differs from the ELB's, where an ALB or NLB can't express it exactly
+}
+
+// The limits on the timings of a target group's health check, which are the same for ALBs and NLBs.
+// The timeout also has to be shorter than the interval.
+const (
+	minHealthCheckInterval  = 5
+	maxHealthCheckInterval  = 300
+	minHealthCheckTimeout   = 2
+	maxHealthCheckTimeout   = 120
+	minHealthCheckThreshold = 2
+	maxHealthCheckThreshold = 10
+)
+
+// parseHealthCheckTarget splits an ELB's health check target, such as HTTP:8080/health or TCP:3306
+func parseHealthCheckTarget(target string) (protocol string, port int, path string, ok bool) {
+	protocol, rest, found := strings.Cut(target, ":")
+	if !found {
+		return "", 0, "", false
+	}
+
+	portText := rest
+	if i := strings.Index(rest, "/"); i >= 0 {
+		portText, path = rest[:i], rest[i:]
+	}
+
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return "", 0, "", false
+	}
+	return strings.ToUpper(protocol), port, path, true
+}
+
+// translateHealthCheck returns the health check for the target groups of an ELB on an ALB, or on an
+// NLB if network is set. It returns nil if the ELB doesn't have a health check.
+func translateHealthCheck(check *elb.HealthCheck, network bool) *targetHealthCheck {
+	if check == nil {
+		return nil
+	}
+
+	res := &targetHealthCheck{
+		interval:           int(aws.Int64Value(check.Interval)),
+		timeout:            int(aws.Int64Value(check.Timeout)),
+		healthyThreshold:   int(aws.Int64Value(check.HealthyThreshold)),
+		unhealthyThreshold: int(aws.Int64Value(check.UnhealthyThreshold)),
+		differences:        []string{},
+	}
+	res.limitTimings()
+
+	target := aws.StringValue(check.Target)
+	protocol, port, path, ok := parseHealthCheckTarget(target)
+	if !ok {
+		res.differences = append(res.differences,
+			fmt.Sprintf("the ELB's target %q couldn't be read, so it checks the target group's own protocol and port", target))
+		return res
+	}
+	res.port = port
+
+	switch {
+	case protocol == "HTTP" || protocol == "HTTPS":
+		res.protocol, res.path = protocol, path
+	case network:
+		res.protocol = "TCP"
+		if protocol != "TCP" {
+			res.differences = append(res.differences,
+				fmt.Sprintf("NLBs can't check %s, so it only checks that port %d accepts TCP connections", protocol, port))
+		}
+	default:
+		res.protocol, res.path = "HTTP", "/"
+		if protocol == "SSL" {
+			res.protocol = "HTTPS"
+		}
+		res.differences = append(res.differences,
+			fmt.Sprintf("ALBs can't check %s, so it requests / with %s on port %d, which must respond with a 200", protocol, res.protocol, port))
+	}
+
+	// ELBs only count a 200 as healthy, where NLBs would also accept a redirect
+	if res.protocol != "TCP" {
+		res.matcher = "200"
+	}
+
+	return res
+}
+
+// limitTimings brings the interval, timeout and thresholds within the limits of a target group,
+// noting each one that changes
+func (hc *targetHealthCheck) limitTimings() {
+	limit := func(name string, value *int, min, max int) {
+		if *value < min || *value > max {
+			limited := min
+			if *value > max {
+				limited = max
+			}
+			hc.differences = append(hc.differences,
+				fmt.Sprintf("target groups can't have %s of %d, so it's %d", name, *value, limited))
+			*value = limited
+		}
+	}
+
+	limit("a healthy threshold", &hc.healthyThreshold, minHealthCheckThreshold, maxHealthCheckThreshold)
+	limit("an unhealthy threshold", &hc.unhealthyThreshold, minHealthCheckThreshold, maxHealthCheckThreshold)
+	limit("an interval", &hc.interval, minHealthCheckInterval, maxHealthCheckInterval)
+	limit("a timeout", &hc.timeout, minHealthCheckTimeout, maxHealthCheckTimeout)
+
+	if hc.timeout >= hc.interval {
+		hc.differences = append(hc.differences,
+			fmt.Sprintf("a target group's timeout has to be shorter than its interval of %d seconds, so it's %d rather than %d", hc.interval, hc.interval-1, hc.timeout))
+		hc.timeout = hc.interval - 1
+	}
+}
+
+// target is the check in the same form as an ELB's target, such as HTTP:8080/health
+func (hc *targetHealthCheck) target() string {
+	if hc.protocol == "" {
+		return "the target group's protocol and port"
+	}
+	return fmt.Sprintf("%s:%d%s", hc.protocol, hc.port, hc.path)
+}
+
+// planHealthChecks translates the health check of each of the LB's ELBs for its target groups, which
+// are on an NLB if network is set
+func (lb *LB) planHealthChecks(network bool) {
+	lb.healthChecks = make(map[string]*targetHealthCheck)
+	for _, description := range lb.descriptions {
+		lb.healthChecks[*description.LoadBalancerName] = translateHealthCheck(description.HealthCheck, network)
+	}
+}
+
+// planHealthChecks translates the health checks for the target groups of every ALB and NLB in the
+// tier
+func (r *recommendation) planHealthChecks() {
+	for _, lb := range r.albs {
+		lb.planHealthChecks(false)
+	}
+	for _, lb := range r.nlbs {
+		lb.planHealthChecks(true)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/stretchr/testify/assert"
+)
+
+// healthChecksFixture is two ELBs which become an ALB and two which become an NLB, in the order of
+// their target groups, where each of them checks its instances differently
+func healthChecksFixture() []*elb.LoadBalancerDescription {
+	return []*elb.LoadBalancerDescription{
+		elbInTier("admin", listenerDescription{port: 8000, protocol: "HTTP", instanceProtocol: "HTTP", instancePort: 9000}).
+			withHealthCheck("TCP:9001").
+			build(),
+		elbInTier("web", listenerDescription{port: 80, protocol: "HTTP", instanceProtocol: "HTTP", instancePort: 8080}).
+			withHealthCheck("HTTP:8080/health").
+			build(),
+		elbInTier("cache", listenerDescription{port: 11211, protocol: "TCP", instanceProtocol: "TCP", instancePort: 11211}).
+			withHealthCheck("HTTP:8080/ping").
+			build(),
+		elbInTier("ldap", listenerDescription{port: 636, protocol: "SSL", instanceProtocol: "TCP", instancePort: 389, certificate: "arn:cert/ldap"}).
+			withHealthCheck("SSL:389").
+			build(),
+	}
+}
+
+func TestHealthCheckTargetsAreParsed(t *testing.T) {
+	protocol, port, path, ok := parseHealthCheckTarget("HTTP:8080/health?full=1")
+	assert.True(t, ok)
+	assert.Equal(t, "HTTP", protocol)
+	assert.Equal(t, 8080, port)
+	assert.Equal(t, "/health?full=1", path)
+
+	protocol, port, path, ok = parseHealthCheckTarget("tcp:3306")
+	assert.True(t, ok)
+	assert.Equal(t, "TCP", protocol)
+	assert.Equal(t, 3306, port)
+	assert.Equal(t, "", path)
+
+	_, _, _, ok = parseHealthCheckTarget("HTTP")
+	assert.False(t, ok)
+	_, _, _, ok = parseHealthCheckTarget("HTTP:port/health")
+	assert.False(t, ok)
+}
+
+func TestHealthChecksAreTranslatedExactlyWhereTheyCanBe(t *testing.T) {
+	check := &elb.HealthCheck{
+		Target:             aws.String("HTTPS:8443/status"),
+		Interval:           aws.Int64(10),
+		Timeout:            aws.Int64(4),
+		HealthyThreshold:   aws.Int64(3),
+		UnhealthyThreshold: aws.Int64(5),
+	}
+
+	expected := &targetHealthCheck{
+		protocol:           "HTTPS",
+		port:               8443,
+		path:               "/status",
+		matcher:            "200",
+		interval:           10,
+		timeout:            4,
+		healthyThreshold:   3,
+		unhealthyThreshold: 5,
+		differences:        []string{},
+	}
+	assert.Equal(t, expected, translateHealthCheck(check, false))
+	assert.Equal(t, expected, translateHealthCheck(check, true))
+
+	check.Target = aws.String("TCP:3306")
+	nlb := translateHealthCheck(check, true)
+	assert.Equal(t, "TCP", nlb.protocol)
+	assert.Equal(t, "", nlb.matcher)
+	assert.Equal(t, []string{}, nlb.differences)
+
+	assert.Nil(t, translateHealthCheck(nil, false))
+}
+
+func TestHealthChecksWhichCantBeExpressedAreFlagged(t *testing.T) {
+	check := &elb.HealthCheck{
+		Target:             aws.String("TCP:3306"),
+		Interval:           aws.Int64(30),
+		Timeout:            aws.Int64(5),
+		HealthyThreshold:   aws.Int64(2),
+		UnhealthyThreshold: aws.Int64(10),
+	}
+	alb := translateHealthCheck(check, false)
+	assert.Equal(t, "HTTP:3306/", alb.target())
+	assert.Equal(t, "200", alb.matcher)
+	assert.Equal(t, []string{"ALBs can't check TCP, so it requests / with HTTP on port 3306, which must respond with a 200"}, alb.differences)
+
+	check.Target = aws.String("SSL:636")
+	assert.Equal(t, "HTTPS:636/", translateHealthCheck(check, false).target())
+	nlb := translateHealthCheck(check, true)
+	assert.Equal(t, "TCP:636", nlb.target())
+	assert.Equal(t, []string{"NLBs can't check SSL, so it only checks that port 636 accepts TCP connections"}, nlb.differences)
+
+	check.Target = aws.String("HTTP")
+	unread := translateHealthCheck(check, false)
+	assert.Equal(t, "", unread.protocol)
+	assert.Equal(t, "the target group's protocol and port", unread.target())
+	assert.Equal(t, 1, len(unread.differences))
+}
+
+func TestHealthCheckTimingsAreKeptWithinTheTargetGroupLimits(t *testing.T) {
+	check := &elb.HealthCheck{
+		Target:             aws.String("HTTP:8080/health"),
+		Interval:           aws.Int64(400),
+		Timeout:            aws.Int64(1),
+		HealthyThreshold:   aws.Int64(1),
+		UnhealthyThreshold: aws.Int64(12),
+	}
+
+	for _, network := range []bool{false, true} {
+		hc := translateHealthCheck(check, network)
+		assert.Equal(t, 300, hc.interval)
+		assert.Equal(t, 2, hc.timeout)
+		assert.Equal(t, 2, hc.healthyThreshold)
+		assert.Equal(t, 10, hc.unhealthyThreshold)
+		assert.Equal(t, []string{
+			"target groups can't have a healthy threshold of 1, so it's 2",
+			"target groups can't have an unhealthy threshold of 12, so it's 10",
+			"target groups can't have an interval of 400, so it's 300",
+			"target groups can't have a timeout of 1, so it's 2",
+		}, hc.differences)
+	}
+
+	check.Interval, check.Timeout, check.HealthyThreshold, check.UnhealthyThreshold = aws.Int64(5), aws.Int64(5), aws.Int64(2), aws.Int64(2)
+	hc := translateHealthCheck(check, false)
+	assert.Equal(t, 5, hc.interval)
+	assert.Equal(t, 4, hc.timeout)
+	assert.Equal(t, []string{"a target group's timeout has to be shorter than its interval of 5 seconds, so it's 4 rather than 5"}, hc.differences)
+}
+
+func TestTargetGroupsHaveTheHealthCheckOfTheirELB(t *testing.T) {
+	recommendations, err := generateRecommendations(healthChecksFixture(), make(map[string]*ec2.SecurityGroup))
+	assert.NoError(t, err)
+
+	albGroups := recommendations[0].ALBs()[0].TargetGroups()
+	assert.Equal(t, 2, len(albGroups))
+	assert.Equal(t, "HTTP:9001/", albGroups[0].healthCheck.target())
+	assert.Equal(t, 1, len(albGroups[0].healthCheck.differences))
+	assert.Equal(t, "HTTP:8080/health", albGroups[1].healthCheck.target())
+
+	nlbGroups := recommendations[0].NLBs()[0].TargetGroups()
+	assert.Equal(t, 2, len(nlbGroups))
+	assert.Equal(t, "HTTP:8080/ping", nlbGroups[0].healthCheck.target())
+	assert.Equal(t, "TCP:389", nlbGroups[1].healthCheck.target())
+
+	// A target group only names the port when it checks a different one from its traffic
+	plan := planLB(recommendations[0].ALBs()[0], "application", []string{"alb"}, []string{"a"})
+	assert.Equal(t, "9001", plan.targetGroups[0].healthCheckPort())
+	assert.Equal(t, "traffic-port", plan.targetGroups[1].healthCheckPort())
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// allProtocols is the IpProtocol of a permission which allows all traffic, on every port
+const allProtocols = "-1"
+
+// portRange is the protocol and ports of a security group rule
+type portRange struct {
+	protocol string // "tcp", "udp", "icmp" etc, or "-1" for everything
+	fromPort int64
+	toPort   int64
+}
+
+// hasPorts is whether the protocol has ports, so that its rules can cover part of each other's ranges.
+// Other protocols, such as ICMP, only cover the same types and codes.
+func (p portRange) hasPorts() bool {
+	return p.protocol == "tcp" || p.protocol == "udp"
+}
+
+// covers is whether the rule allows everything that the other does, where all traffic covers every
+// protocol and port
+func (p portRange) covers(other portRange) bool {
+	switch {
+	case p.protocol == allProtocols:
+		return true
+	case p.protocol != other.protocol:
+		return false
+	case p.hasPorts():
+		return p.fromPort <= other.fromPort && other.toPort <= p.toPort
+	default:
+		return p == other
+	}
+}
+
+func (p portRange) less(other portRange) bool {
+	if p.protocol != other.protocol {
+		return p.protocol < other.protocol
+	}
+	if p.fromPort != other.fromPort {
+		return p.fromPort < other.fromPort
+	}
+	return p.toPort < other.toPort
+}
+
+// ingressRule is a single (protocol, port range, source) tuple that a security group allows in
+type ingressRule struct {
+	portRange
+	source string // an IPv4 or IPv6 CIDR, a security group ID, or the ID of a prefix list we couldn't resolve
+}
+
+func (r ingressRule) String() string {
+	switch {
+	case r.protocol == allProtocols:
+		return "all traffic from " + r.source
+	case r.fromPort == r.toPort:
+		return fmt.Sprintf("%s/%d from %s", r.protocol, r.fromPort, r.source)
+	default:
+		return fmt.Sprintf("%s/%d-%d from %s", r.protocol, r.fromPort, r.toPort, r.source)
+	}
+}
+
+// sources is everything that is allowed to connect on some ports
+type sources struct {
+	addresses ipSet               // the address space allowed by CIDRs and resolved prefix lists
+	others    map[string]struct{} // security group IDs, and anything else that isn't an address
+}
+
+func newSources() *sources {
+	return &sources{others: make(map[string]struct{})}
+}
+
+// rules lists the sources as rules, with the addresses written as the fewest possible CIDRs
+func (s *sources) rules(p portRange) []ingressRule {
+	res := make([]ingressRule, 0)
+	for _, prefix := range s.addresses.prefixes() {
+		res = append(res, ingressRule{portRange: p, source: prefix.String()})
+	}
+	others := make([]string, 0, len(s.others))
+	for other := range s.others {
+		others = append(others, other)
+	}
+	sort.Strings(others)
+	for _, other := range others {
+		res = append(res, ingressRule{portRange: p, source: other})
+	}
+	return res
+}
+
+// without returns the sources which aren't in the other sources
+func (s *sources) without(other *sources) *sources {
+	res := newSources()
+	res.addresses = s.addresses.minus(other.addresses)
+	for source := range s.others {
+		if _, ok := other.others[source]; !ok {
+			res.others[source] = struct{}{}
+		}
+	}
+	return res
+}
+
+// empty is whether the sources allow nothing
+func (s *sources) empty() bool {
+	return len(s.addresses) == 0 && len(s.others) == 0
+}
+
+// equal is whether both sources allow exactly the same
+func (s *sources) equal(other *sources) bool {
+	if !s.addresses.equal(other.addresses) || len(s.others) != len(other.others) {
+		return false
+	}
+	for source := range s.others {
+		if _, ok := other.others[source]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// union returns everything that either of the sources allow
+func (s *sources) union(other *sources) *sources {
+	res := newSources()
+	res.addresses = newIPSet(append(s.addresses.prefixes(), other.addresses.prefixes()...)...)
+	for source := range s.others {
+		res.others[source] = struct{}{}
+	}
+	for source := range other.others {
+		res.others[source] = struct{}{}
+	}
+	return res
+}
+
+// ingress is what a security group allows in, keyed by the protocol and ports. Addresses are held as
+// canonical sets, so two groups which allow the same address space are equal however their CIDRs were
+// written.
+type ingress map[portRange]*sources
+
+// newIngress reads the ingress rules from a security group. A missing security group allows nothing.
+//
+// Prefix lists are replaced by the CIDRs that they contain, so that a rule which allows a prefix list
+// is equivalent to rules which allow each of its CIDRs directly. Rules which reference another
+// security group can't be resolved like that, since membership of the group changes over time, so the
+// group ID itself is the source.
+func newIngress(sg *ec2.SecurityGroup, prefixLists map[string][]string) ingress {
+	res := make(ingress)
+
+	if sg == nil {
+		return res
+	}
+
+	cidrs := make(map[portRange][]netip.Prefix)
+
+	for _, permission := range sg.IpPermissions {
+		p := portRange{
+			protocol: strings.ToLower(aws.StringValue(permission.IpProtocol)),
+			fromPort: aws.Int64Value(permission.FromPort),
+		}
+		p.toPort = p.fromPort
+		if permission.ToPort != nil {
+			p.toPort = *permission.ToPort
+		}
+
+		// Ports are meaningless when every protocol is allowed
+		if p.protocol == allProtocols {
+			p.fromPort, p.toPort = 0, 0
+		}
+
+		if _, ok := res[p]; !ok {
+			res[p] = newSources()
+		}
+
+		add := func(source string) {
+			if prefix, err := netip.ParsePrefix(source); err == nil {
+				cidrs[p] = append(cidrs[p], prefix)
+			} else {
+				res[p].others[source] = struct{}{}
+			}
+		}
+
+		for _, cidr := range permission.IpRanges {
+			add(aws.StringValue(cidr.CidrIp))
+		}
+
+		for _, cidr := range permission.Ipv6Ranges {
+			add(aws.StringValue(cidr.CidrIpv6))
+		}
+
+		for _, prefixList := range permission.PrefixListIds {
+			id := aws.StringValue(prefixList.PrefixListId)
+			entries, ok := prefixLists[id]
+			if !ok {
+				// We weren't able to read the entries, so the best we can do is compare the IDs
+				add(id)
+				continue
+			}
+			for _, cidr := range entries {
+				add(cidr)
+			}
+		}
+
+		for _, pair := range permission.UserIdGroupPairs {
+			add(aws.StringValue(pair.GroupId))
+		}
+	}
+
+	for p, prefixes := range cidrs {
+		res[p].addresses = newIPSet(prefixes...)
+	}
+
+	return res
+}
+
+// keys returns the protocols and port ranges in a stable order
+func (in ingress) keys() []portRange {
+	res := make([]portRange, 0, len(in))
+	for p := range in {
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].less(res[j])
+	})
+	return res
+}
+
+// sorted returns the rules in a stable order
+func (in ingress) sorted() []ingressRule {
+	res := make([]ingressRule, 0)
+	for _, p := range in.keys() {
+		res = append(res, in[p].rules(p)...)
+	}
+	return res
+}
+
+// union returns the ingress of a load balancer which has both sets of rules
+func (in ingress) union(other ingress) ingress {
+	res := make(ingress)
+	for p, s := range in {
+		res[p] = s
+	}
+	for p, s := range other {
+		if existing, ok := res[p]; ok {
+			res[p] = existing.union(s)
+		} else {
+			res[p] = s
+		}
+	}
+	return res
+}
+
+// sourcesFor returns everything that is allowed on all of the ports, by the rules for the same
+// protocol, and also by the rules for all traffic if withAll is set
+func (in ingress) sourcesFor(p portRange, withAll bool) *sources {
+	var res *sources
+	for q, s := range in {
+		if !q.covers(p) || (!withAll && q.protocol != p.protocol) {
+			continue
+		}
+		if res == nil {
+			res = s
+		} else {
+			res = res.union(s)
+		}
+	}
+	if res == nil {
+		return newSources()
+	}
+	return res
+}
+
+// segments splits the port ranges of the ingress wherever it or the other ingress starts or ends a
+// range, so that each segment is either wholly allowed by a rule or not at all. They're returned in
+// the same order as keys.
+func (in ingress) segments(other ingress) []portRange {
+	res := make([]portRange, 0)
+
+	boundaries := make(map[string][]int64)
+	for _, rules := range []ingress{in, other} {
+		for p := range rules {
+			if p.hasPorts() {
+				boundaries[p.protocol] = append(boundaries[p.protocol], p.fromPort, p.toPort+1)
+			}
+		}
+	}
+
+	done := make(map[string]struct{})
+	for _, p := range in.keys() {
+		if !p.hasPorts() {
+			res = append(res, p)
+			continue
+		}
+		if _, ok := done[p.protocol]; ok {
+			continue
+		}
+		done[p.protocol] = struct{}{}
+
+		points := boundaries[p.protocol]
+		sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+		for i := 0; i+1 < len(points); i++ {
+			if points[i] == points[i+1] {
+				continue
+			}
+			segment := portRange{protocol: p.protocol, fromPort: points[i], toPort: points[i+1] - 1}
+			for q := range in {
+				if q.protocol == p.protocol && q.covers(segment) {
+					res = append(res, segment)
+					break
+				}
+			}
+		}
+	}
+
+	return res
+}
+
+// without returns the rules which aren't allowed by the other ingress. A port range is allowed when
+// the other ingress covers it with one rule or several, and all traffic covers every port range.
+// Adjacent ports which lack the same sources are written as one range.
+func (in ingress) without(other ingress) []ingressRule {
+	type missing struct {
+		portRange
+		sources *sources
+	}
+	merged := make([]missing, 0)
+
+	for _, segment := range in.segments(other) {
+		sources := in.sourcesFor(segment, false).without(other.sourcesFor(segment, true))
+		if sources.empty() {
+			continue
+		}
+
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if segment.hasPorts() && last.protocol == segment.protocol && last.toPort+1 == segment.fromPort && last.sources.equal(sources) {
+				last.toPort = segment.toPort
+				continue
+			}
+		}
+		merged = append(merged, missing{portRange: segment, sources: sources})
+	}
+
+	res := make([]ingressRule, 0)
+	for _, m := range merged {
+		res = append(res, m.sources.rules(m.portRange)...)
+	}
+	return res
+}
+
+// ingressComparison records whether two security groups were judged to have equivalent ingress,
+// and why.
+type ingressComparison struct {
+	sg1, sg2         string
+	rules            int           // the number of rules that the first security group allows, once normalised
+	onlyIn1, onlyIn2 []ingressRule // the rules which only one of the security groups allows
+}
+
+func compareIngress(sg1 string, ingress1 ingress, sg2 string, ingress2 ingress) *ingressComparison {
+	res := &ingressComparison{
+		sg1:     sg1,
+		sg2:     sg2,
+		onlyIn1: ingress1.without(ingress2),
+		onlyIn2: ingress2.without(ingress1),
+	}
+	res.rules = len(ingress1.sorted())
+	return res
+}
+
+func (c *ingressComparison) equivalent() bool {
+	return len(c.onlyIn1) == 0 && len(c.onlyIn2) == 0
+}
+
+// reason explains the comparison for a human reviewer
+func (c *ingressComparison) reason() string {
+	if c.equivalent() {
+		return fmt.Sprintf("%s has the same ingress as %s (%d rule(s))", c.sg2, c.sg1, c.rules)
+	}
+
+	differences := make([]string, 0, 2)
+	if len(c.onlyIn1) > 0 {
+		differences = append(differences, fmt.Sprintf("only %s allows %s", c.sg1, joinRules(c.onlyIn1)))
+	}
+	if len(c.onlyIn2) > 0 {
+		differences = append(differences, fmt.Sprintf("only %s allows %s", c.sg2, joinRules(c.onlyIn2)))
+	}
+
+	return fmt.Sprintf("%s has different ingress to %s: %s", c.sg2, c.sg1, strings.Join(differences, "; "))
+}
+
+func joinRules(rules []ingressRule) string {
+	res := make([]string, len(rules))
+	for i := range rules {
+		res[i] = rules[i].String()
+	}
+	return strings.Join(res, ", ")
+}
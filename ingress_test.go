@@ -0,0 +1,257 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func securityGroup(id string, permissions ...*ec2.IpPermission) *ec2.SecurityGroup {
+	return &ec2.SecurityGroup{GroupId: sPtr(id), IpPermissions: permissions}
+}
+
+func tcpFrom(fromPort, toPort int64, cidrs ...string) *ec2.IpPermission {
+	res := &ec2.IpPermission{
+		IpProtocol: sPtr("tcp"),
+		FromPort:   int64Ptr(fromPort),
+		ToPort:     int64Ptr(toPort),
+	}
+	for _, cidr := range cidrs {
+		res.IpRanges = append(res.IpRanges, &ec2.IpRange{CidrIp: sPtr(cidr)})
+	}
+	return res
+}
+
+func TestTheSameSourceOnDifferentPortsIsNotEquivalent(t *testing.T) {
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": securityGroup("sg-1", tcpFrom(443, 443, "10.0.0.0/8")),
+		"sg-2": securityGroup("sg-2", tcpFrom(22, 22, "10.0.0.0/8")),
+	}
+
+	comparison := newTiers(sgs, nil).compareIngress("sg-1", "sg-2")
+
+	assert.False(t, comparison.equivalent())
+	assert.Equal(t, "sg-2 has different ingress to sg-1: only sg-1 allows tcp/443 from 10.0.0.0/8; only sg-2 allows tcp/22 from 10.0.0.0/8", comparison.reason())
+}
+
+func TestTheSameSourceAndPortOnDifferentProtocolsIsNotEquivalent(t *testing.T) {
+	udp := tcpFrom(53, 53, "10.0.0.0/8")
+	udp.IpProtocol = sPtr("udp")
+
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": securityGroup("sg-1", tcpFrom(53, 53, "10.0.0.0/8")),
+		"sg-2": securityGroup("sg-2", udp),
+	}
+
+	assert.False(t, newTiers(sgs, nil).hasSameIngress("sg-1", "sg-2"))
+}
+
+func TestPortRangesAreCompared(t *testing.T) {
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": securityGroup("sg-1", tcpFrom(8000, 8080, "10.0.0.0/8")),
+		"sg-2": securityGroup("sg-2", tcpFrom(8000, 8080, "10.0.0.0/8")),
+		"sg-3": securityGroup("sg-3", tcpFrom(8000, 8443, "10.0.0.0/8")),
+	}
+
+	tiers := newTiers(sgs, nil)
+	assert.True(t, tiers.hasSameIngress("sg-1", "sg-2"))
+	assert.False(t, tiers.hasSameIngress("sg-1", "sg-3"))
+	assert.Equal(t, "sg-3 has different ingress to sg-1: only sg-3 allows tcp/8081-8443 from 10.0.0.0/8",
+		tiers.compareIngress("sg-1", "sg-3").reason())
+}
+
+func TestPortRangesWhichOverlapAreComparedByThePortsTheyShare(t *testing.T) {
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": securityGroup("sg-1", tcpFrom(8000, 8080, "10.0.0.0/8"), tcpFrom(8081, 8443, "10.0.0.0/8")),
+		"sg-2": securityGroup("sg-2", tcpFrom(8000, 8443, "10.0.0.0/8")),
+		"sg-3": securityGroup("sg-3", tcpFrom(8050, 8443, "10.0.0.0/8"), tcpFrom(8000, 8100, "10.0.0.0/9")),
+	}
+
+	tiers := newTiers(sgs, nil)
+	assert.True(t, tiers.hasSameIngress("sg-1", "sg-2"), "The same ports split into different rules are equivalent")
+	assert.Equal(t, "sg-3 has different ingress to sg-2: only sg-2 allows tcp/8000-8049 from 10.128.0.0/9",
+		tiers.compareIngress("sg-2", "sg-3").reason())
+}
+
+func TestWideGroupsCoverNarrowerPorts(t *testing.T) {
+	allTraffic := &ec2.IpPermission{
+		IpProtocol: sPtr("-1"),
+		IpRanges:   []*ec2.IpRange{{CidrIp: sPtr("10.0.0.0/8")}},
+	}
+
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-all":   securityGroup("sg-all", allTraffic),
+		"sg-tcp":   securityGroup("sg-tcp", tcpFrom(0, 65535, "10.0.0.0/8")),
+		"sg-https": securityGroup("sg-https", tcpFrom(443, 443, "10.0.0.0/8")),
+	}
+
+	tiers := newTiers(sgs, nil)
+
+	comparison := tiers.compareIngress("sg-all", "sg-https")
+	assert.Equal(t, []ingressRule{}, comparison.onlyIn2)
+	assert.Equal(t, "all traffic from 10.0.0.0/8", joinRules(comparison.onlyIn1))
+
+	comparison = tiers.compareIngress("sg-tcp", "sg-https")
+	assert.Equal(t, []ingressRule{}, comparison.onlyIn2)
+	assert.Equal(t, "tcp/0-442 from 10.0.0.0/8, tcp/444-65535 from 10.0.0.0/8", joinRules(comparison.onlyIn1))
+
+	comparison = tiers.compareIngress("sg-all", "sg-tcp")
+	assert.Equal(t, []ingressRule{}, comparison.onlyIn2, "All traffic covers every TCP port")
+}
+
+func TestAMissingToPortMeansASinglePort(t *testing.T) {
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": securityGroup("sg-1", tcpFrom(443, 443, "10.0.0.0/8")),
+		"sg-2": securityGroup("sg-2", &ec2.IpPermission{
+			IpProtocol: sPtr("tcp"),
+			FromPort:   int64Ptr(443),
+			IpRanges:   []*ec2.IpRange{{CidrIp: sPtr("10.0.0.0/8")}},
+		}),
+	}
+
+	comparison := newTiers(sgs, nil).compareIngress("sg-1", "sg-2")
+	assert.True(t, comparison.equivalent())
+	assert.Equal(t, "sg-2 has the same ingress as sg-1 (1 rule(s))", comparison.reason())
+}
+
+func TestAllTrafficIgnoresPorts(t *testing.T) {
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": securityGroup("sg-1", &ec2.IpPermission{
+			IpProtocol: sPtr("-1"),
+			IpRanges:   []*ec2.IpRange{{CidrIp: sPtr("10.0.0.0/8")}},
+		}),
+		"sg-2": securityGroup("sg-2", &ec2.IpPermission{
+			IpProtocol: sPtr("-1"),
+			FromPort:   int64Ptr(-1),
+			ToPort:     int64Ptr(-1),
+			IpRanges:   []*ec2.IpRange{{CidrIp: sPtr("10.0.0.0/8")}},
+		}),
+	}
+
+	comparison := newTiers(sgs, nil).compareIngress("sg-1", "sg-2")
+	assert.True(t, comparison.equivalent())
+	assert.Equal(t, "all traffic from 10.0.0.0/8", newIngress(sgs["sg-1"], nil).sorted()[0].String())
+}
+
+func TestUnknownSecurityGroupsHaveNoIngress(t *testing.T) {
+	tiers := newTiers(map[string]*ec2.SecurityGroup{}, nil)
+	assert.True(t, tiers.hasSameIngress("sg-1", "sg-2"))
+}
+
+func TestIPv6RangesAreCompared(t *testing.T) {
+	ipv6 := func(id string, cidr string) *ec2.SecurityGroup {
+		return securityGroup(id, &ec2.IpPermission{
+			IpProtocol: sPtr("tcp"),
+			FromPort:   int64Ptr(443),
+			Ipv6Ranges: []*ec2.Ipv6Range{{CidrIpv6: sPtr(cidr)}},
+		})
+	}
+
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": ipv6("sg-1", "::/0"),
+		"sg-2": ipv6("sg-2", "2001:db8::/32"),
+		"sg-3": ipv6("sg-3", "::/0"),
+	}
+
+	tiers := newTiers(sgs, nil)
+	assert.False(t, tiers.hasSameIngress("sg-1", "sg-2"))
+	assert.True(t, tiers.hasSameIngress("sg-1", "sg-3"))
+}
+
+func TestSecurityGroupReferencesAreCompared(t *testing.T) {
+	fromGroup := func(id string, source string) *ec2.SecurityGroup {
+		return securityGroup(id, &ec2.IpPermission{
+			IpProtocol:       sPtr("tcp"),
+			FromPort:         int64Ptr(443),
+			UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: sPtr(source), UserId: sPtr("111111111111")}},
+		})
+	}
+
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": fromGroup("sg-1", "sg-web"),
+		"sg-2": fromGroup("sg-2", "sg-batch"),
+		"sg-3": fromGroup("sg-3", "sg-web"),
+	}
+
+	tiers := newTiers(sgs, nil)
+	assert.False(t, tiers.hasSameIngress("sg-1", "sg-2"), "Groups which only allow other groups don't have empty ingress")
+	assert.Equal(t, "sg-2 has different ingress to sg-1: only sg-1 allows tcp/443 from sg-web; only sg-2 allows tcp/443 from sg-batch",
+		tiers.compareIngress("sg-1", "sg-2").reason())
+	assert.True(t, tiers.hasSameIngress("sg-1", "sg-3"))
+}
+
+func TestPrefixListsAreComparedByTheirCIDRs(t *testing.T) {
+	prefixLists := map[string][]string{
+		"pl-office": {"10.0.0.0/8", "192.168.0.0/16"},
+	}
+
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": securityGroup("sg-1", &ec2.IpPermission{
+			IpProtocol:    sPtr("tcp"),
+			FromPort:      int64Ptr(443),
+			PrefixListIds: []*ec2.PrefixListId{{PrefixListId: sPtr("pl-office")}},
+		}),
+		"sg-2": securityGroup("sg-2", tcpFrom(443, 443, "10.0.0.0/8", "192.168.0.0/16")),
+		"sg-3": securityGroup("sg-3", tcpFrom(443, 443, "10.0.0.0/8")),
+	}
+
+	tiers := newTiers(sgs, prefixLists)
+	assert.True(t, tiers.hasSameIngress("sg-1", "sg-2"))
+	assert.False(t, tiers.hasSameIngress("sg-1", "sg-3"))
+}
+
+func TestUnresolvedPrefixListsAreComparedByID(t *testing.T) {
+	fromPrefixList := func(id string, prefixList string) *ec2.SecurityGroup {
+		return securityGroup(id, &ec2.IpPermission{
+			IpProtocol:    sPtr("tcp"),
+			FromPort:      int64Ptr(443),
+			PrefixListIds: []*ec2.PrefixListId{{PrefixListId: sPtr(prefixList)}},
+		})
+	}
+
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": fromPrefixList("sg-1", "pl-1"),
+		"sg-2": fromPrefixList("sg-2", "pl-2"),
+		"sg-3": fromPrefixList("sg-3", "pl-1"),
+	}
+
+	tiers := newTiers(sgs, nil)
+	assert.False(t, tiers.hasSameIngress("sg-1", "sg-2"))
+	assert.True(t, tiers.hasSameIngress("sg-1", "sg-3"))
+}
+
+func TestTheSameAddressSpaceIsEquivalent(t *testing.T) {
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": securityGroup("sg-1", tcpFrom(443, 443, "10.0.0.0/8")),
+		"sg-2": securityGroup("sg-2", tcpFrom(443, 443, "10.0.0.0/9", "10.128.0.0/9")),
+		"sg-3": securityGroup("sg-3", tcpFrom(443, 443, "10.0.0.0/9"), tcpFrom(443, 443, "10.128.0.0/9", "10.1.0.0/16")),
+	}
+
+	tiers := newTiers(sgs, nil)
+	assert.True(t, tiers.hasSameIngress("sg-1", "sg-2"))
+	assert.True(t, tiers.hasSameIngress("sg-1", "sg-3"))
+	assert.Equal(t, "sg-2 has the same ingress as sg-1 (1 rule(s))", tiers.compareIngress("sg-1", "sg-2").reason())
+}
+
+func TestNonCanonicalCIDRsAreMasked(t *testing.T) {
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": securityGroup("sg-1", tcpFrom(443, 443, "10.0.0.1/24")),
+		"sg-2": securityGroup("sg-2", tcpFrom(443, 443, "10.0.0.0/24")),
+		"sg-3": securityGroup("sg-3", tcpFrom(443, 443, "10.0.0.1/32")),
+	}
+
+	tiers := newTiers(sgs, nil)
+	assert.True(t, tiers.hasSameIngress("sg-1", "sg-2"))
+	assert.False(t, tiers.hasSameIngress("sg-1", "sg-3"))
+}
+
+func TestDifferencesAreTheAddressesWhichOnlyOneGroupAllows(t *testing.T) {
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": securityGroup("sg-1", tcpFrom(443, 443, "10.0.0.0/8")),
+		"sg-2": securityGroup("sg-2", tcpFrom(443, 443, "10.0.0.0/9")),
+	}
+
+	assert.Equal(t, "sg-2 has different ingress to sg-1: only sg-1 allows tcp/443 from 10.128.0.0/9",
+		newTiers(sgs, nil).compareIngress("sg-1", "sg-2").reason())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// ipRange is an inclusive range of addresses from the same family
+type ipRange struct {
+	from, to netip.Addr
+}
+
+// ipSet is a canonical set of IPv4 and IPv6 addresses: a sorted list of ranges which neither overlap
+// nor touch. Two sets containing the same addresses always have the same ranges, however the CIDRs
+// that they were built from were written.
+type ipSet []ipRange
+
+// newIPSet builds the set of addresses covered by the prefixes
+func newIPSet(prefixes ...netip.Prefix) ipSet {
+	ranges := make([]ipRange, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		prefix = prefix.Masked()
+		ranges = append(ranges, ipRange{from: prefix.Addr(), to: lastAddr(prefix)})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].from.Less(ranges[j].from)
+	})
+
+	res := make(ipSet, 0, len(ranges))
+	for _, r := range ranges {
+		if n := len(res); n > 0 {
+			last := &res[n-1]
+			next := last.to.Next()
+			// Merge ranges which overlap or are adjacent. next is invalid at the end of the address
+			// space, which also stops IPv4 merging into IPv6.
+			if r.from.Compare(last.to) <= 0 || (next.IsValid() && next == r.from) {
+				if last.to.Less(r.to) {
+					last.to = r.to
+				}
+				continue
+			}
+		}
+		res = append(res, r)
+	}
+
+	return res
+}
+
+// equal is true if both sets contain exactly the same addresses
+func (s ipSet) equal(other ipSet) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for i := range s {
+		if s[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// minus returns the addresses which are in s but not in other
+func (s ipSet) minus(other ipSet) ipSet {
+	res := make(ipSet, 0)
+
+	for _, r := range s {
+		remaining := true
+		for _, o := range other {
+			if o.to.Less(r.from) {
+				continue
+			}
+			if r.to.Less(o.from) {
+				break
+			}
+			if r.from.Less(o.from) {
+				res = append(res, ipRange{from: r.from, to: o.from.Prev()})
+			}
+			if !o.to.Less(r.to) {
+				remaining = false
+				break
+			}
+			r.from = o.to.Next()
+		}
+		if remaining {
+			res = append(res, r)
+		}
+	}
+
+	return res
+}
+
+// prefixes returns the smallest list of CIDRs which covers the set, in address order
+func (s ipSet) prefixes() []netip.Prefix {
+	res := make([]netip.Prefix, 0, len(s))
+
+	for _, r := range s {
+		from := r.from
+		for {
+			// Find the largest block which starts at from and doesn't go past the end of the range
+			prefix := netip.PrefixFrom(from, from.BitLen())
+			for bits := 0; bits <= from.BitLen(); bits++ {
+				candidate := netip.PrefixFrom(from, bits)
+				if candidate.Masked().Addr() == from && !r.to.Less(lastAddr(candidate)) {
+					prefix = candidate
+					break
+				}
+			}
+			res = append(res, prefix)
+
+			last := lastAddr(prefix)
+			if last == r.to {
+				break
+			}
+			from = last.Next()
+		}
+	}
+
+	return res
+}
+
+// lastAddr returns the highest address in the prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Masked().Addr().AsSlice()
+	for i := range bytes {
+		// The number of host bits in this byte
+		hostBits := (i+1)*8 - prefix.Bits()
+		if hostBits >= 8 {
+			bytes[i] = 0xff
+		} else if hostBits > 0 {
+			bytes[i] |= byte(1<<hostBits - 1)
+		}
+	}
+	res, _ := netip.AddrFromSlice(bytes)
+	return res
+}
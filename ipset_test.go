@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func prefixes(cidrs ...string) []netip.Prefix {
+	res := make([]netip.Prefix, len(cidrs))
+	for i, cidr := range cidrs {
+		res[i] = netip.MustParsePrefix(cidr)
+	}
+	return res
+}
+
+func TestAdjacentPrefixesAreAggregated(t *testing.T) {
+	set := newIPSet(prefixes("10.128.0.0/9", "10.0.0.0/9")...)
+
+	assert.True(t, set.equal(newIPSet(prefixes("10.0.0.0/8")...)))
+	assert.Equal(t, prefixes("10.0.0.0/8"), set.prefixes())
+}
+
+func TestOverlappingPrefixesAreAggregated(t *testing.T) {
+	set := newIPSet(prefixes("10.0.0.0/8", "10.1.2.0/24", "10.255.255.255/32")...)
+
+	assert.Equal(t, prefixes("10.0.0.0/8"), set.prefixes())
+}
+
+func TestHostBitsAreIgnored(t *testing.T) {
+	assert.True(t, newIPSet(prefixes("10.0.0.1/24")...).equal(newIPSet(prefixes("10.0.0.0/24")...)))
+	assert.False(t, newIPSet(prefixes("10.0.0.1/24")...).equal(newIPSet(prefixes("10.0.0.1/32")...)))
+}
+
+func TestPrefixesAreTheFewestThatCoverTheSet(t *testing.T) {
+	set := newIPSet(prefixes("10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/30")...)
+
+	assert.Equal(t, prefixes("10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/30"), set.prefixes())
+}
+
+func TestIPv4AndIPv6AreKeptApart(t *testing.T) {
+	set := newIPSet(prefixes("::/0", "255.255.255.255/32", "0.0.0.0/0")...)
+
+	assert.Equal(t, prefixes("0.0.0.0/0", "::/0"), set.prefixes())
+}
+
+func TestMinusRemovesAddresses(t *testing.T) {
+	set := newIPSet(prefixes("10.0.0.0/8", "192.168.0.0/16")...)
+
+	assert.Equal(t, prefixes("10.128.0.0/9"), set.minus(newIPSet(prefixes("10.0.0.0/9", "192.168.0.0/16")...)).prefixes())
+	assert.Equal(t, prefixes("10.0.0.0/9", "10.128.0.0/10", "10.224.0.0/11"),
+		newIPSet(prefixes("10.0.0.0/8")...).minus(newIPSet(prefixes("10.192.0.0/11")...)).prefixes())
+	assert.Equal(t, 0, len(set.minus(set)))
+	assert.True(t, set.minus(nil).equal(set))
+}
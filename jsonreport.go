@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+)
+
+// jsonSchemaVersion is the version of the JSON output. Adding fields doesn't change the version;
+// removing, renaming or changing the meaning of a field does. See the README for the schema.
+const jsonSchemaVersion = 1
+
+type jsonOutput struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	Reports       []*jsonReport `json:"reports"`
+	Summary       *jsonSummary  `json:"summary"`
+}
+
+type jsonReport struct {
+	Account string         `json:"account,omitempty"`
+	Region  string         `json:"region"`
+	Tiers   []*jsonTier    `json:"tiers"`
+	Skipped []*jsonSkipped `json:"skipped"`
+	Summary *jsonSummary   `json:"summary"`
+}
+
+// jsonSkipped is an ELB which we couldn't classify, so there's no recommendation for it
+type jsonSkipped struct {
+	ELB    string `json:"elb"`
+	Reason string `json:"reason"`
+}
+
+type jsonTier struct {
+	VPC       string          `json:"vpc,omitempty"`
+	Scheme    string          `json:"scheme,omitempty"`
+	Subnets   []string        `json:"subnets"`
+	Cost      *jsonCost       `json:"cost,omitempty"`
+	Deletions []*jsonDeletion `json:"deletions"`
+	ALBs      []*jsonLB       `json:"albs"`
+	NLBs      []*jsonLB       `json:"nlbs"`
+	ELBs      []*jsonLB       `json:"elbs"`
+}
+
+// jsonDeletion is an unused ELB which can be deleted rather than replaced
+type jsonDeletion struct {
+	Action  action   `json:"action"`
+	ELB     string   `json:"elb"`
+	Reasons []string `json:"reasons"`
+}
+
+type jsonLB struct {
+	Action         action              `json:"action"`
+	Scheme         string              `json:"scheme,omitempty"`
+	ELBs           []string            `json:"elbs"`
+	Ports          []int               `json:"ports"`
+	SecurityGroups []string            `json:"securityGroups"`
+	Rationale      []string            `json:"rationale"`
+	Widenings      []*jsonWidening     `json:"widenings"`
+	Hostnames      map[string][]string `json:"hostnames"`
+	ProbablyUnused []string            `json:"probablyUnused"`
+	Rules          []*jsonRule         `json:"rules"`
+	TargetGroups   []*jsonTargetGroup  `json:"targetGroups"`
+	TLSListeners   []*jsonTLSListener  `json:"tlsListeners"`
+	Certificates   []*jsonCertificates `json:"certificates"`
+	Split          *jsonSplit          `json:"split,omitempty"`
+	Cost           *jsonCost           `json:"cost,omitempty"`
+}
+
+// jsonCost is the estimated monthly cost in USD of ELBs today, and of the load balancers that we
+// recommend instead
+type jsonCost struct {
+	CurrentMonthly   float64 `json:"currentMonthly"`
+	ProjectedMonthly float64 `json:"projectedMonthly"`
+	IncludesTraffic  bool    `json:"includesTraffic"`
+}
+
+// jsonRule is a host-based routing rule on an ALB
+type jsonRule struct {
+	ELB         string   `json:"elb"`
+	DNSName     string   `json:"dnsName,omitempty"`
+	HostHeaders []string `json:"hostHeaders"`
+	Ports       []int    `json:"ports"`
+}
+
+// jsonTargetGroup is where an ALB or NLB sends the traffic of some of an ELB's listeners
+type jsonTargetGroup struct {
+	Name            string           `json:"name"`
+	ELB             string           `json:"elb"`
+	BackendProtocol string           `json:"backendProtocol,omitempty"`
+	BackendPort     int              `json:"backendPort"`
+	ListenerPorts   []int            `json:"listenerPorts"`
+	HealthCheck     *jsonHealthCheck `json:"healthCheck,omitempty"`
+}
+
+// jsonHealthCheck is a target group's health check, translated from the ELB's, with how it differs
+type jsonHealthCheck struct {
+	Protocol           string   `json:"protocol,omitempty"`
+	Port               int      `json:"port,omitempty"`
+	Path               string   `json:"path,omitempty"`
+	Matcher            string   `json:"matcher,omitempty"`
+	IntervalSeconds    int      `json:"intervalSeconds"`
+	TimeoutSeconds     int      `json:"timeoutSeconds"`
+	HealthyThreshold   int      `json:"healthyThreshold"`
+	UnhealthyThreshold int      `json:"unhealthyThreshold"`
+	Differences        []string `json:"differences"`
+}
+
+// jsonTLSListener is a port where the load balancer terminates TLS, in place of an SSL listener
+type jsonTLSListener struct {
+	Port        int    `json:"port"`
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// jsonCertificates are the certificates that a listener needs to serve every ELB on its port
+type jsonCertificates struct {
+	Port         int        `json:"port"`
+	Default      string     `json:"default"`
+	SNI          []string   `json:"sni"`
+	Uncovered    []string   `json:"uncovered"`
+	UnknownNames []string   `json:"unknownNames"`
+	Missing      []string   `json:"missing"`
+	Exceeded     *jsonSplit `json:"exceeded,omitempty"`
+}
+
+type jsonWidening struct {
+	ELB     string   `json:"elb"`
+	Clients []string `json:"clients"`
+}
+
+// jsonSplit is the quota which kept a load balancer separate from another
+type jsonSplit struct {
+	Quota string `json:"quota"`
+	Limit int    `json:"limit"`
+}
+
+type jsonSummary struct {
+	CurrentELBs   int          `json:"currentElbs"`
+	ALBs          int          `json:"albs"`
+	NLBs          int          `json:"nlbs"`
+	ELBs          int          `json:"elbs"`
+	Deleted       int          `json:"deleted"`
+	Skipped       int          `json:"skipped"`
+	SavingPercent float64      `json:"savingPercent"`
+	Solvers       *jsonSolvers `json:"solvers,omitempty"`
+	Cost          *jsonCost    `json:"cost,omitempty"`
+
+	// CostSavingPercent is the saving worked out from the cost, where SavingPercent always assumes
+	// that an ALB or NLB costs 10% less than an ELB
+	CostSavingPercent *float64 `json:"costSavingPercent,omitempty"`
+}
+
+// jsonSolvers is the number of load balancers recommended by each solver, with -solver=optimal
+type jsonSolvers struct {
+	Greedy  int `json:"greedy"`
+	Optimal int `json:"optimal"`
+}
+
+// writeJSONReports writes the reports in the versioned JSON schema
+func writeJSONReports(w io.Writer, reports []report) error {
+	res := &jsonOutput{
+		SchemaVersion: jsonSchemaVersion,
+		Reports:       make([]*jsonReport, 0, len(reports)),
+	}
+
+	total := &tally{}
+
+	for _, r := range reports {
+		t := tallyRecommendations(r.recommendations)
+		t.skipped = len(r.skipped)
+		total.add(t)
+
+		res.Reports = append(res.Reports, &jsonReport{
+			Account: r.account,
+			Region:  r.region,
+			Tiers:   newJSONTiers(r.recommendations),
+			Skipped: newJSONSkipped(r.skipped),
+			Summary: newJSONSummary(t),
+		})
+	}
+
+	res.Summary = newJSONSummary(total)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(res)
+}
+
+func newJSONTiers(recommendations []recommendation) []*jsonTier {
+	res := make([]*jsonTier, 0, len(recommendations))
+
+	for _, r := range recommendations {
+		res = append(res, &jsonTier{
+			VPC:       r.VPC(),
+			Scheme:    r.Scheme(),
+			Subnets:   r.Subnets(),
+			Cost:      newJSONCost(r.Cost()),
+			Deletions: newJSONDeletions(r.Deletions()),
+			ALBs:      newJSONLBs(r.ALBs(), "ALB"),
+			NLBs:      newJSONLBs(r.NLBs(), "NLB"),
+			ELBs:      newJSONLBs(r.ELBs(), "ELB"),
+		})
+	}
+
+	return res
+}
+
+func newJSONSkipped(skipped []*classificationError) []*jsonSkipped {
+	res := make([]*jsonSkipped, 0, len(skipped))
+
+	for _, err := range skipped {
+		res = append(res, &jsonSkipped{ELB: err.elb, Reason: err.err.Error()})
+	}
+
+	return res
+}
+
+func newJSONDeletions(deletions []deletion) []*jsonDeletion {
+	res := make([]*jsonDeletion, 0, len(deletions))
+
+	for _, d := range deletions {
+		res = append(res, &jsonDeletion{Action: deleteELB, ELB: d.elb, Reasons: d.reasons})
+	}
+
+	return res
+}
+
+func newJSONLBs(lbs []*LB, lbType string) []*jsonLB {
+	res := make([]*jsonLB, 0, len(lbs))
+
+	for _, lb := range lbs {
+		res = append(res, &jsonLB{
+			Action:         actionFor(lb, lbType),
+			Scheme:         lb.Scheme(),
+			ELBs:           lb.ELBs(),
+			Ports:          lb.portNumbers(),
+			SecurityGroups: lb.SecurityGroups(),
+			Rationale:      lb.Rationale(),
+			Widenings:      newJSONWidenings(lb.Widenings()),
+			Hostnames:      newJSONHostnames(lb),
+			ProbablyUnused: lb.ProbablyUnused(),
+			Rules:          newJSONRules(lb, lbType),
+			TargetGroups:   newJSONTargetGroups(lb, lbType),
+			TLSListeners:   newJSONTLSListeners(lb.TLSListeners()),
+			Certificates:   newJSONCertificates(lb.Certificates()),
+			Split:          newJSONSplit(lb.Split()),
+			Cost:           newJSONCost(lb.Cost()),
+		})
+	}
+
+	return res
+}
+
+func newJSONWidenings(widenings []widening) []*jsonWidening {
+	res := make([]*jsonWidening, 0, len(widenings))
+
+	for _, w := range widenings {
+		clients := make([]string, 0, len(w.clients))
+		for _, rule := range w.clients {
+			clients = append(clients, rule.String())
+		}
+		res = append(res, &jsonWidening{ELB: w.elb, Clients: clients})
+	}
+
+	return res
+}
+
+// newJSONHostnames returns the Route 53 names for each of the ELBs that have any
+func newJSONHostnames(lb *LB) map[string][]string {
+	res := make(map[string][]string)
+	for _, name := range lb.ELBs() {
+		if hostnames := lb.Hostnames(name); len(hostnames) > 0 {
+			res[name] = hostnames
+		}
+	}
+	return res
+}
+
+// newJSONRules returns the routing rules of an ALB, or an empty list for other types of load balancer
+func newJSONRules(lb *LB, lbType string) []*jsonRule {
+	res := make([]*jsonRule, 0)
+	if lbType != "ALB" {
+		return res
+	}
+
+	for _, rule := range lb.RoutingRules() {
+		res = append(res, &jsonRule{ELB: rule.elb, DNSName: rule.dnsName, HostHeaders: rule.hostnames, Ports: rule.ports})
+	}
+
+	return res
+}
+
+// newJSONTargetGroups returns the target groups of an ALB or NLB, or an empty list for a classic ELB
+func newJSONTargetGroups(lb *LB, lbType string) []*jsonTargetGroup {
+	res := make([]*jsonTargetGroup, 0)
+	if lbType == "ELB" {
+		return res
+	}
+
+	for _, tg := range lb.TargetGroups() {
+		res = append(res, &jsonTargetGroup{
+			Name:            tg.name,
+			ELB:             tg.elb,
+			BackendProtocol: tg.protocol,
+			BackendPort:     tg.port,
+			ListenerPorts:   tg.ports,
+			HealthCheck:     newJSONHealthCheck(tg.healthCheck),
+		})
+	}
+
+	return res
+}
+
+func newJSONHealthCheck(hc *targetHealthCheck) *jsonHealthCheck {
+	if hc == nil {
+		return nil
+	}
+	return &jsonHealthCheck{
+		Protocol:           hc.protocol,
+		Port:               hc.port,
+		Path:               hc.path,
+		Matcher:            hc.matcher,
+		IntervalSeconds:    hc.interval,
+		TimeoutSeconds:     hc.timeout,
+		HealthyThreshold:   hc.healthyThreshold,
+		UnhealthyThreshold: hc.unhealthyThreshold,
+		Differences:        hc.differences,
+	}
+}
+
+func newJSONTLSListeners(listeners []tlsListener) []*jsonTLSListener {
+	res := make([]*jsonTLSListener, 0, len(listeners))
+
+	for _, l := range listeners {
+		res = append(res, &jsonTLSListener{Port: l.port, Certificate: l.certificate})
+	}
+
+	return res
+}
+
+func newJSONCertificates(plans []listenerCertificates) []*jsonCertificates {
+	res := make([]*jsonCertificates, 0, len(plans))
+
+	for _, plan := range plans {
+		res = append(res, &jsonCertificates{
+			Port:         plan.port,
+			Default:      plan.defaultCertificate,
+			SNI:          plan.sni,
+			Uncovered:    plan.uncovered,
+			UnknownNames: plan.unknown,
+			Missing:      plan.missing,
+			Exceeded:     newJSONSplit(plan.exceeded),
+		})
+	}
+
+	return res
+}
+
+func newJSONSplit(split *quotaSplit) *jsonSplit {
+	if split == nil {
+		return nil
+	}
+	return &jsonSplit{Quota: split.quota, Limit: split.limit}
+}
+
+// newJSONCost rounds the costs to the nearest cent
+func newJSONCost(cost *costEstimate) *jsonCost {
+	if cost == nil {
+		return nil
+	}
+	return &jsonCost{
+		CurrentMonthly:   math.Round(cost.current*100) / 100,
+		ProjectedMonthly: math.Round(cost.projected*100) / 100,
+		IncludesTraffic:  cost.traffic,
+	}
+}
+
+func newJSONSummary(t *tally) *jsonSummary {
+	res := &jsonSummary{
+		CurrentELBs:   t.currentElbs,
+		ALBs:          t.albs,
+		NLBs:          t.nlbs,
+		ELBs:          t.elbs,
+		Deleted:       t.deleted,
+		Skipped:       t.skipped,
+		SavingPercent: saving(t.currentElbs, t.albs, t.nlbs, t.elbs),
+	}
+	if t.solved {
+		res.Solvers = &jsonSolvers{Greedy: t.greedy, Optimal: t.optimal}
+	}
+	res.Cost = newJSONCost(t.costs())
+	if cost := t.costs(); cost != nil && cost.current > 0 {
+		percent := math.Round(t.saving()*100) / 100
+		res.CostSavingPercent = &percent
+	}
+	return res
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONOutputFollowsTheSchema(t *testing.T) {
+	elbs := []*elb.LoadBalancerDescription{
+		createELB("first").
+			withSubnets("a").
+			withListenerDescriptions(
+				listenerDescription{port: 80, protocol: "HTTP"},
+				listenerDescription{port: 443, protocol: "HTTPS"},
+			).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("second").
+			withSubnets("a").
+			withListenerDescriptions(
+				listenerDescription{port: 443, protocol: "HTTPS"},
+			).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("third").
+			withSubnets("a").
+			withListenerDescriptions(
+				listenerDescription{port: 10201, protocol: "TCP"},
+				listenerDescription{port: 443, protocol: "HTTPS"},
+			).
+			withSecurityGroups("sg-1").
+			build(),
+	}
+
+	recommendations, err := generateRecommendations(elbs, make(map[string]*ec2.SecurityGroup))
+	assert.NoError(t, err)
+
+	reports := []report{
+		{
+			account:         "111111111111",
+			region:          "eu-west-1",
+			recommendations: recommendations,
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeJSONReports(&buf, reports))
+
+	var output jsonOutput
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &output))
+
+	assert.Equal(t, jsonSchemaVersion, output.SchemaVersion)
+	assert.Equal(t, 1, len(output.Reports))
+
+	r := output.Reports[0]
+	assert.Equal(t, "111111111111", r.Account)
+	assert.Equal(t, "eu-west-1", r.Region)
+	assert.Equal(t, 1, len(r.Tiers))
+
+	tier := r.Tiers[0]
+	assert.Equal(t, []string{"a"}, tier.Subnets)
+	assert.Equal(t, []*jsonLB{
+		{
+			Action: replace, ELBs: []string{"first", "second"}, Ports: []int{80, 443}, SecurityGroups: []string{"sg-1"}, Rationale: []string{}, Widenings: []*jsonWidening{},
+			Hostnames: map[string][]string{}, ProbablyUnused: []string{},
+			Rules: []*jsonRule{
+				{ELB: "first", HostHeaders: []string{}, Ports: []int{80, 443}},
+				{ELB: "second", HostHeaders: []string{}, Ports: []int{443}},
+			},
+			TargetGroups: []*jsonTargetGroup{
+				{Name: "first", ELB: "first", ListenerPorts: []int{80, 443}},
+				{Name: "second", ELB: "second", ListenerPorts: []int{443}},
+			},
+			TLSListeners: []*jsonTLSListener{},
+			Certificates: []*jsonCertificates{},
+		},
+	}, tier.ALBs)
+	assert.Equal(t, []*jsonLB{}, tier.NLBs)
+	assert.Equal(t, []*jsonLB{
+		{Action: retain, ELBs: []string{"third"}, Ports: []int{443, 10201}, SecurityGroups: []string{"sg-1"}, Rationale: []string{}, Widenings: []*jsonWidening{}, Hostnames: map[string][]string{}, ProbablyUnused: []string{}, Rules: []*jsonRule{}, TargetGroups: []*jsonTargetGroup{}, TLSListeners: []*jsonTLSListener{},
+			Certificates: []*jsonCertificates{}},
+	}, tier.ELBs)
+
+	expected := &jsonSummary{CurrentELBs: 3, ALBs: 1, NLBs: 0, ELBs: 1, SavingPercent: saving(3, 1, 0, 1)}
+	assert.Equal(t, expected, r.Summary)
+	assert.Equal(t, expected, output.Summary)
+}
+
+func TestJSONOutputWithNoLoadBalancersIsValid(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, writeJSONReports(&buf, []report{{region: "eu-west-1", recommendations: []recommendation{}}}))
+
+	var output jsonOutput
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &output))
+	assert.Equal(t, &jsonSummary{}, output.Summary)
+	assert.Equal(t, []*jsonTier{}, output.Reports[0].Tiers)
+}
+
+// jsonOutputFor analyses the inventory and parses the JSON report of it
+func jsonOutputFor(t *testing.T, inv *inventory, options *analysisOptions) *jsonOutput {
+	var buf bytes.Buffer
+	assert.NoError(t, writeJSONReports(&buf, generateReports([]*inventory{inv}, options)))
+
+	var output jsonOutput
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &output))
+	return &output
+}
+
+func TestEachSectionIsInTheJSONOutput(t *testing.T) {
+	equivalent := &analysisOptions{sgPolicy: sgPolicyEquivalent}
+	ldap := &inventory{
+		region: "eu-west-1",
+		elbs: []*elb.LoadBalancerDescription{
+			elbInTier("ldap", listenerDescription{port: 636, protocol: "SSL", certificate: "arn:cert/ldap"}).build(),
+		},
+		securityGroups: make(map[string]*ec2.SecurityGroup),
+	}
+
+	tests := []struct {
+		name    string
+		inv     *inventory
+		options *analysisOptions
+		check   func(t *testing.T, output *jsonOutput)
+	}{
+		{
+			name:    "TLS listeners",
+			inv:     ldap,
+			options: equivalent,
+			check: func(t *testing.T, output *jsonOutput) {
+				nlbs := output.Reports[0].Tiers[0].NLBs
+				assert.Equal(t, 1, len(nlbs))
+				assert.Equal(t, []*jsonTLSListener{{Port: 636, Certificate: "arn:cert/ldap"}}, nlbs[0].TLSListeners)
+			},
+		},
+		{
+			name:    "target groups",
+			inv:     tierInventory(backendsFixture()...),
+			options: equivalent,
+			check: func(t *testing.T, output *jsonOutput) {
+				assert.Equal(t, []*jsonTargetGroup{
+					{Name: "api", ELB: "api", BackendProtocol: "HTTP", BackendPort: 9000, ListenerPorts: []int{443}},
+					{Name: "web-http-8080", ELB: "web", BackendProtocol: "HTTP", BackendPort: 8080, ListenerPorts: []int{80, 443}},
+					{Name: "web-https-8443", ELB: "web", BackendProtocol: "HTTPS", BackendPort: 8443, ListenerPorts: []int{8443}},
+				}, output.Reports[0].Tiers[0].ALBs[0].TargetGroups)
+			},
+		},
+		{
+			name:    "health checks",
+			inv:     tierInventory(healthChecksFixture()...),
+			options: equivalent,
+			check: func(t *testing.T, output *jsonOutput) {
+				tier := output.Reports[0].Tiers[0]
+				assert.Equal(t, &jsonHealthCheck{
+					Protocol:           "HTTP",
+					Port:               8080,
+					Path:               "/health",
+					Matcher:            "200",
+					IntervalSeconds:    30,
+					TimeoutSeconds:     5,
+					HealthyThreshold:   2,
+					UnhealthyThreshold: 10,
+					Differences:        []string{},
+				}, tier.ALBs[0].TargetGroups[1].HealthCheck)
+				assert.Equal(t, []string{"NLBs can't check SSL, so it only checks that port 389 accepts TCP connections"},
+					tier.NLBs[0].TargetGroups[1].HealthCheck.Differences)
+			},
+		},
+		{
+			name:    "deletions",
+			inv:     usageFixture(),
+			options: equivalent,
+			check: func(t *testing.T, output *jsonOutput) {
+				assert.Equal(t, []*jsonDeletion{
+					{Action: deleteELB, ELB: "empty", Reasons: []string{"it has no registered instances"}},
+					{Action: deleteELB, ELB: "idle", Reasons: []string{"it had no traffic in the last 14d"}},
+				}, output.Reports[0].Tiers[0].Deletions)
+				assert.Equal(t, 2, output.Summary.Deleted)
+			},
+		},
+		{
+			name:    "costs",
+			inv:     pricedFixture(),
+			options: &analysisOptions{sgPolicy: sgPolicyEquivalent, pricing: mustDefaultPricing(t)},
+			check: func(t *testing.T, output *jsonOutput) {
+				expected := &jsonCost{CurrentMonthly: 40.88, ProjectedMonthly: 18.4}
+				assert.Equal(t, expected, output.Reports[0].Tiers[0].Cost)
+				assert.Equal(t, expected, output.Reports[0].Tiers[0].ALBs[0].Cost)
+				assert.Equal(t, expected, output.Summary.Cost)
+
+				// The cost-based saving is separate, so that savingPercent keeps its meaning
+				assert.Equal(t, saving(2, 1, 0, 0), output.Summary.SavingPercent)
+				assert.InDelta(t, 55, *output.Summary.CostSavingPercent, 0.1)
+			},
+		},
+		{
+			name:    "skipped ELBs",
+			inv:     skippedFixture(),
+			options: equivalent,
+			check: func(t *testing.T, output *jsonOutput) {
+				assert.Equal(t, []*jsonSkipped{
+					{ELB: "bare", Reason: "it has no listeners"},
+					{ELB: "udp", Reason: "none of its listeners use HTTP, HTTPS, SSL or TCP (it has UDP on port 53)"},
+				}, output.Reports[0].Skipped)
+				assert.Equal(t, 2, output.Reports[0].Summary.Skipped)
+				assert.Equal(t, 2, output.Summary.Skipped)
+				assert.Equal(t, 1, output.Summary.CurrentELBs)
+			},
+		},
+		{
+			name:    "certificates",
+			inv:     sniFixture(),
+			options: &analysisOptions{sgPolicy: sgPolicyEquivalent, quotas: &albQuotas{Rules: 100, Certificates: 1, TargetGroups: 100, Listeners: 50}},
+			check: func(t *testing.T, output *jsonOutput) {
+				assert.Equal(t, []*jsonCertificates{
+					{
+						Port:         443,
+						Default:      "arn:cert/web",
+						SNI:          []string{"arn:cert/api", "arn:cert/shop"},
+						Uncovered:    []string{"shop.example.org"},
+						UnknownNames: []string{},
+						Missing:      []string{},
+						Exceeded:     &jsonSplit{Quota: quotaCertificates, Limit: 1},
+					},
+				}, output.Reports[0].Tiers[0].ALBs[0].Certificates)
+			},
+		},
+		{
+			name:    "quota splits",
+			inv:     tierInventory(manyALBs(3)...),
+			options: &analysisOptions{sgPolicy: sgPolicyEquivalent, quotas: &albQuotas{Rules: 2, Certificates: 25, TargetGroups: 100, Listeners: 50}},
+			check: func(t *testing.T, output *jsonOutput) {
+				albs := output.Reports[0].Tiers[0].ALBs
+				assert.Equal(t, 2, len(albs))
+				assert.Nil(t, albs[0].Split)
+				assert.Equal(t, &jsonSplit{Quota: quotaRules, Limit: 2}, albs[1].Split)
+			},
+		},
+		{
+			name:    "routing rules and hostnames",
+			inv:     routedFixture(),
+			options: equivalent,
+			check: func(t *testing.T, output *jsonOutput) {
+				alb := output.Reports[0].Tiers[0].ALBs[0]
+				assert.Equal(t, map[string][]string{"web": {"example.com", "www.example.com"}}, alb.Hostnames)
+				assert.Equal(t, []string{"api"}, alb.ProbablyUnused)
+				assert.Equal(t, []*jsonRule{
+					{ELB: "api", DNSName: "api-456.eu-west-1.elb.amazonaws.com", HostHeaders: []string{}, Ports: []int{443}},
+					{ELB: "web", DNSName: "web-123.eu-west-1.elb.amazonaws.com", HostHeaders: []string{"example.com", "www.example.com"}, Ports: []int{443}},
+				}, alb.Rules)
+			},
+		},
+		{
+			name:    "solver counts",
+			inv:     tierInventory(collidingNLBs()...),
+			options: &analysisOptions{sgPolicy: sgPolicyEquivalent, solver: solverOptimal},
+			check: func(t *testing.T, output *jsonOutput) {
+				assert.Equal(t, &jsonSolvers{Greedy: 3, Optimal: 2}, output.Summary.Solvers)
+				assert.Equal(t, &jsonSolvers{Greedy: 3, Optimal: 2}, output.Reports[0].Summary.Solvers)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.check(t, jsonOutputFor(t, test.inv, test.options))
+		})
+	}
+}
@@ -1,21 +1,23 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
-	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/sts"
 )
 
 type lbType int
@@ -23,14 +25,58 @@ type lbType int
 const (
 	// ALB is an Application Load Balancer that only speaks HTTP(S)
 	ALB lbType = iota
-	// NLB is a Network Load Balancer that only speaks TCP (and UDP?)
+	// NLB is a Network Load Balancer that only speaks TCP (and UDP?), and can terminate TLS
 	NLB
 	// ELB is a classic LoadBalancer
 	ELB
 )
 
+// snapshotCommand is the subcommand which saves the AWS account(s) to a file rather than making
+// recommendations
+const snapshotCommand = "snapshot"
+
+// The formats that recommendations can be written in
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// The policies for deciding whether ELBs with different security groups can share a load balancer
+const (
+	// sgPolicyEquivalent only lets ELBs share a load balancer if their security groups allow exactly
+	// the same ingress, so that nothing can reach a backend which couldn't reach it before.
+	sgPolicyEquivalent = "equivalent"
+	// sgPolicySubset also lets an ELB join a load balancer which allows everything that the ELB's
+	// security groups allow, and more. The extra clients are reported as widenings to be reviewed.
+	sgPolicySubset = "subset"
+)
+
+// analysisOptions are the choices which affect the recommendations that we make
+type analysisOptions struct {
+	sgPolicy string
+	solver   string
+	quotas   *albQuotas // the limits on each ALB, or nil for the AWS defaults
+	pricing  *lbPricing // the rates for estimating costs, or nil not to
+}
+
 type arguments struct {
-	profile string
+	command  string // the subcommand, or empty to make recommendations
+	input    string // a snapshot file to read instead of AWS
+	out      string // the file that the snapshot subcommand writes to
+	output   string // the format of the recommendations
+	emit     string // the kind of infrastructure code to generate, if any
+	emitDir  string // the directory that generated infrastructure code is written to
+	profile  string
+	regions  string
+	accounts string
+	org      bool
+	roleName string
+	sgPolicy string
+	solver   string
+	quotas   *albQuotas
+	usage    time.Duration // how far back to read the usage of the ELBs from CloudWatch, or zero not to
+	pricing  *lbPricing    // the rates from the bundled or -pricing file, and the fallback for the Pricing API
+	prices   string        // the value of -pricing
 }
 
 // tier is a set of one or more subnets. In an AWS account, we might have a:
@@ -40,9 +86,38 @@ type arguments struct {
 // - private subnet
 // - database subnet
 // - etc
+//
+// A tier never spans VPCs, since a load balancer can only route to targets in its own VPC. Nor does it
+// mix schemes: an internal load balancer and an internet-facing one can never be the same load balancer,
+// so they're in separate tiers even when they share subnets.
+//
+// Tiers form a union-find forest. An ELB which spans subnets from two tiers merges them, and only the
+// root of each tree holds the subnets and ELBs of the merged tier.
 type tier struct {
-	subnets        map[string]struct{}
-	recommendation *recommendation
+	vpc       string // the VPC that the subnets belong to, or empty if the ELB didn't say
+	scheme    string // "internet-facing" or "internal", or empty if the ELB didn't say
+	subnets   map[string]struct{}
+	elbs      []*elb.LoadBalancerDescription // the ELBs in the tier, waiting for the tiers to be settled
+	deletions []deletion                     // the unused ELBs in the tier, which don't need replacing
+	parent    *tier                          // the tier that this one was merged into, or nil for a root
+	index     int                            // when the tier was created, so that merges keep the oldest as the root
+}
+
+// root finds the tier that this one has been merged into, if any
+func (t *tier) root() *tier {
+	res := t
+	for res.parent != nil {
+		res = res.parent
+	}
+
+	// Compress the path, so that the next lookup is quicker
+	for t != res {
+		next := t.parent
+		t.parent = res
+		t = next
+	}
+
+	return res
 }
 
 func (t *tier) add(subnet *string) {
@@ -63,97 +138,248 @@ func (t *tier) keys() []string {
 	return keys
 }
 
+// subnetKey identifies a subnet within a VPC, for the load balancers of one scheme
+type subnetKey struct {
+	vpc    string
+	scheme string
+	subnet string
+}
+
 // tiers is a holder for all of the tiers we've discovered. It also contains caches for comparisons.
 type tiers struct {
-	tiersBySubnet  map[string]*tier              // tiers keyed by subnet name
-	tiers          []*tier                       // the list of tiers
+	tiersBySubnet  map[subnetKey]*tier           // tiers keyed by VPC, scheme and subnet name
+	tiers          []*tier                       // the list of tiers, including those which have been merged
 	securityGroups map[string]*ec2.SecurityGroup // security groups keyed by GroupId
-	ingressesBySg  map[string]map[string]bool    // set of ingress CIDRs keyed by Security Group GroupId
+	prefixLists    map[string][]string           // the CIDRs in each managed prefix list, keyed by PrefixListId
+	ingressesBySg  map[string]ingress            // set of ingress rules keyed by Security Group GroupId
+	sgPolicy       string                        // how ELBs with different security groups may share a load balancer
+	solver         string                        // how ELBs are grouped into load balancers
+	quotas         *albQuotas                    // the limits on each ALB
+	hostnames      map[string][]string           // the Route 53 names which point at each ELB, keyed by LoadBalancerName, or nil if unknown
+	skipped        []*classificationError        // the ELBs which couldn't be classified
 }
 
 // newTiers creates a new tiers struct ready for use
-func newTiers(sgs map[string]*ec2.SecurityGroup) *tiers {
+func newTiers(sgs map[string]*ec2.SecurityGroup, prefixLists map[string][]string) *tiers {
 	return &tiers{
-		tiersBySubnet:  make(map[string]*tier),
+		tiersBySubnet:  make(map[subnetKey]*tier),
 		tiers:          make([]*tier, 0),
 		securityGroups: sgs,
-		ingressesBySg:  make(map[string]map[string]bool),
+		prefixLists:    prefixLists,
+		ingressesBySg:  make(map[string]ingress),
+		sgPolicy:       sgPolicyEquivalent,
+		solver:         solverGreedy,
+		quotas:         defaultALBQuotas(),
+		skipped:        make([]*classificationError, 0),
 	}
 }
 
-func (t *tiers) addTierFor(subnet *string) *tier {
+func (t *tiers) addTierFor(vpc string, scheme string, subnet *string) *tier {
 	res := &tier{
-		subnets:        make(map[string]struct{}),
-		recommendation: newRecommendation(),
+		vpc:     vpc,
+		scheme:  scheme,
+		subnets: make(map[string]struct{}),
+		elbs:    make([]*elb.LoadBalancerDescription, 0),
+		index:   len(t.tiers),
 	}
-	t.associate(res, subnet)
+	res.add(subnet)
+	t.tiersBySubnet[subnetKey{vpc: vpc, scheme: scheme, subnet: *subnet}] = res
 	t.tiers = append(t.tiers, res)
 
 	return res
 }
 
-func (t *tiers) associate(tier *tier, subnet *string) {
-	tier.add(subnet)
-	t.tiersBySubnet[*subnet] = tier
+// find returns the root of the tier which contains the subnet, creating a new tier if there isn't one
+func (t *tiers) find(vpc string, scheme string, subnet *string) *tier {
+	if res, ok := t.tiersBySubnet[subnetKey{vpc: vpc, scheme: scheme, subnet: *subnet}]; ok {
+		return res.root()
+	}
+
+	return t.addTierFor(vpc, scheme, subnet)
 }
 
-func (t *tiers) find(subnet *string) *tier {
-	if res, ok := t.tiersBySubnet[*subnet]; ok {
-		return res
+// union merges two tiers, along with their subnets and ELBs, and returns the root of the merged tier
+func (t *tiers) union(a, b *tier) *tier {
+	a, b = a.root(), b.root()
+	if a == b {
+		return a
 	}
 
-	return t.addTierFor(subnet)
+	if b.index < a.index {
+		a, b = b, a
+	}
+
+	for subnet := range b.subnets {
+		a.subnets[subnet] = struct{}{}
+	}
+	a.elbs = append(a.elbs, b.elbs...)
+	a.deletions = append(a.deletions, b.deletions...)
+
+	b.parent = a
+	b.subnets = nil
+	b.elbs = nil
+
+	return a
 }
 
-func (t *tiers) findOrGetIngress(sg string) map[string]bool {
+func (t *tiers) findOrGetIngress(sg string) ingress {
 	if res, ok := t.ingressesBySg[sg]; ok {
 		return res
 	}
 
-	res := make(map[string]bool)
-
-	for _, permission := range t.securityGroups[sg].IpPermissions {
-		for _, cidr := range permission.IpRanges {
-			res[*cidr.CidrIp] = true
-		}
-	}
+	res := newIngress(t.securityGroups[sg], t.prefixLists)
 
 	t.ingressesBySg[sg] = res
 
 	return res
 }
 
-// hasSameIngress is an equality test between 2 security groups. Ingress CIDRs need to be
-// identical. We don't consider set operations in terms of one ingress is a proper subset of
-// another. Equality only at this time.
+// ingressOf is the combined ingress of a set of security groups, which is what a load balancer with
+// all of them attached would allow
+func (t *tiers) ingressOf(sgs []string) ingress {
+	res := make(ingress)
+	for _, sg := range sgs {
+		res = res.union(t.findOrGetIngress(sg))
+	}
+	return res
+}
+
+// hasSameIngress is an equality test between 2 security groups. Ingress rules need to be identical,
+// comparing the protocol, port range and source of each. Subsets are only considered by addToSuperset,
+// when the subset policy is in use.
 func (t *tiers) hasSameIngress(sg1, sg2 string) bool {
-	ingress1 := t.findOrGetIngress(sg1)
-	ingress2 := t.findOrGetIngress(sg2)
+	return t.compareIngress(sg1, sg2).equivalent()
+}
 
-	return reflect.DeepEqual(ingress1, ingress2)
+// compareIngress compares the ingress of 2 security groups, and explains the outcome
+func (t *tiers) compareIngress(sg1, sg2 string) *ingressComparison {
+	return compareIngress(sg1, t.findOrGetIngress(sg1), sg2, t.findOrGetIngress(sg2))
 }
 
+// recommendations makes a recommendation for each tier, once every ELB has been assigned to one.
+//
+// The same ELBs always give the same recommendations, whichever order AWS lists them in: the ELBs in a
+// tier are considered in order of name (see subsetOrder for the exception), and the tiers are sorted by
+// VPC, scheme and subnets.
 func (t *tiers) recommendations() []recommendation {
 	result := make([]recommendation, 0)
 
-	// TODO(jabley): this is little messy – fix data structures!
 	for _, tier := range t.tiers {
-		tier.recommendation.subnets = tier.keys()
-		result = append(result, *tier.recommendation)
+		if tier.parent != nil {
+			continue
+		}
+
+		sort.Slice(tier.elbs, func(i, j int) bool {
+			return *tier.elbs[i].LoadBalancerName < *tier.elbs[j].LoadBalancerName
+		})
+		if t.sgPolicy == sgPolicySubset {
+			tier.elbs = t.subsetOrder(tier.elbs)
+		}
+
+		recommendation := newRecommendation()
+		for _, lb := range tier.elbs {
+			if err := elbDrop(t, recommendation, lb); err != nil {
+				t.skipped = append(t.skipped, &classificationError{elb: *lb.LoadBalancerName, err: err})
+			}
+		}
+
+		if t.solver == solverOptimal {
+			optimal := t.solveOptimally(tier.elbs, recommendation)
+			optimal.solvers = &solverComparison{greedy: recommendation.loadBalancerCount(), optimal: optimal.loadBalancerCount()}
+			recommendation = optimal
+		}
+
+		sort.Slice(tier.deletions, func(i, j int) bool {
+			return tier.deletions[i].elb < tier.deletions[j].elb
+		})
+		recommendation.deletions = tier.deletions
+
+		recommendation.attachHostnames(t.hostnames)
+		recommendation.vpc = tier.vpc
+		recommendation.scheme = tier.scheme
+		recommendation.subnets = tier.keys()
+		result = append(result, *recommendation)
 	}
 
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.vpc != b.vpc {
+			return a.vpc < b.vpc
+		}
+		if a.scheme != b.scheme {
+			return a.scheme < b.scheme
+		}
+		return strings.Join(a.subnets, ",") < strings.Join(b.subnets, ",")
+	})
+
 	return result
 }
 
+// subsetOrder reorders ELBs which are sorted by name, so that each ELB comes after every ELB whose
+// ingress is strictly wider than its own. Otherwise a narrower ELB could be considered first, and the
+// wider one would be unable to join it.
+func (t *tiers) subsetOrder(elbs []*elb.LoadBalancerDescription) []*elb.LoadBalancerDescription {
+	ingresses := make([]ingress, len(elbs))
+	for i, lb := range elbs {
+		ingresses[i] = t.ingressOf(securityGroupIDs(lb))
+	}
+
+	// narrower[i] lists the ELBs whose ingress is strictly narrower than ELB i's
+	narrower := make([][]int, len(elbs))
+	wider := make([]int, len(elbs)) // the number of ELBs which must come before each ELB
+	for i := range elbs {
+		for j := range elbs {
+			if i == j {
+				continue
+			}
+			comparison := compareIngress("", ingresses[i], "", ingresses[j])
+			if len(comparison.onlyIn2) == 0 && len(comparison.onlyIn1) > 0 {
+				narrower[i] = append(narrower[i], j)
+				wider[j]++
+			}
+		}
+	}
+
+	// Kahn's algorithm, always taking the first ready ELB by name so that the order is stable
+	res := make([]*elb.LoadBalancerDescription, 0, len(elbs))
+	done := make([]bool, len(elbs))
+	for len(res) < len(elbs) {
+		for i := range elbs {
+			if done[i] || wider[i] > 0 {
+				continue
+			}
+			done[i] = true
+			res = append(res, elbs[i])
+			for _, j := range narrower[i] {
+				wider[j]--
+			}
+			break
+		}
+	}
+
+	return res
+}
+
 // recommendation is a summary of how we might restructure the ELBs in the account for a given tier.
 type recommendation struct {
-	subnets  []string       // the set of subnets that this recommendation covers
-	albs     []*LB          // the non-nil ALBs that should live in the subnets
-	albsBySg map[string]*LB // the ALBs keyed by Security Group GroupId
-	nlbs     []*LB          // the non-nil NLBs that should live in the subnets
-	nlbsBySg map[string]*LB // the NLBs keyed by Security Group GroupId
-	elbs     []*LB          // the non-nil ELBs that should live in the subnets
-	elbsBySg map[string]*LB // the ELBs keyed by Security Group GroupId
+	vpc       string            // the VPC that the subnets belong to
+	scheme    string            // whether the load balancers are "internet-facing" or "internal"
+	subnets   []string          // the set of subnets that this recommendation covers
+	albs      []*LB             // the non-nil ALBs that should live in the subnets
+	albsBySg  map[string]*LB    // the ALBs keyed by Security Group GroupId
+	nlbs      []*LB             // the non-nil NLBs that should live in the subnets
+	nlbsBySg  map[string]*LB    // the NLBs keyed by Security Group GroupId
+	elbs      []*LB             // the non-nil ELBs that should live in the subnets
+	elbsBySg  map[string]*LB    // the ELBs keyed by Security Group GroupId
+	solvers   *solverComparison // the load balancers recommended by each solver, or nil with -solver=greedy
+	cost      *costEstimate     // the monthly cost of the ELBs and of the recommended load balancers, or nil if we don't know the prices
+	deletions []deletion        // the ELBs in the subnets which are unused, and can be deleted
+}
+
+// deletion is an ELB which can be deleted instead of being replaced, since nothing uses it
+type deletion struct {
+	elb     string
+	reasons []string // why we think that it's unused
 }
 
 // newRecommendation creates a new recommendation instance ready for use
@@ -208,11 +434,140 @@ func (r *recommendation) Subnets() []string {
 	return r.subnets
 }
 
+// VPC returns the ID of the VPC that the recommended load balancers live in, or empty if it isn't known
+func (r *recommendation) VPC() string {
+	return r.vpc
+}
+
+// Scheme returns "internet-facing" or "internal" for the recommended load balancers, or empty if it
+// isn't known
+func (r *recommendation) Scheme() string {
+	return r.scheme
+}
+
+// Deletions returns the non-nil array of ELBs which can be deleted, in order of name
+func (r *recommendation) Deletions() []deletion {
+	if r.deletions == nil {
+		return []deletion{}
+	}
+	return r.deletions
+}
+
+// Cost returns the estimated monthly cost of the ELBs in the subnets and of the recommended load
+// balancers, or nil if we don't have prices for the region
+func (r *recommendation) Cost() *costEstimate {
+	return r.cost
+}
+
+// attachHostnames records the Route 53 names of each ELB against the load balancer which replaces it.
+// If Route 53 was read, ELBs without any names are flagged as probably unused, since nothing we know
+// of sends traffic to them.
+func (r *recommendation) attachHostnames(hostnames map[string][]string) {
+	for _, lbs := range [][]*LB{r.albs, r.nlbs, r.elbs} {
+		for _, lb := range lbs {
+			for _, name := range lb.elbs {
+				if names, ok := hostnames[name]; ok {
+					lb.hostnames[name] = names
+				} else if hostnames != nil {
+					lb.unused = append(lb.unused, name)
+				}
+			}
+		}
+	}
+}
+
+// loadBalancerCount returns the number of load balancers recommended for the tier
+func (r *recommendation) loadBalancerCount() int {
+	return len(r.albs) + len(r.nlbs) + len(r.elbs)
+}
+
 // LB is an ALB or NLB that can replace one or more ELBs
 type LB struct {
-	elbs           []string            // the names of the ELBs that this LB can replace
-	ports          map[int]struct{}    // the set of ports that this LB will listen on
-	securityGroups map[string]struct{} // the set of Security Groups that this LB will allow
+	elbs           []string                       // the names of the ELBs that this LB can replace
+	descriptions   []*elb.LoadBalancerDescription // the ELBs that this LB can replace, in the same order as elbs
+	ports          map[int]struct{}               // the set of ports that this LB will listen on
+	tls            map[int]struct{}               // the ports where the ELBs had SSL listeners, which an NLB terminates TLS on
+	certificates   map[int][]string               // the distinct certificates of the ELBs' HTTPS and SSL listeners, keyed by port, in the order that the ELBs joined
+	securityGroups map[string]struct{}            // the set of Security Groups that this LB will allow
+	rationale      []string                       // why ELBs were or weren't judged to have equivalent ingress
+	widenings      []widening                     // access which sharing this LB gives to clients that don't have it today
+	scheme         string                         // "internet-facing" or "internal", the same as every ELB that it replaces
+	split          *quotaSplit                    // the quota which stopped the first ELB joining another ALB, if any
+	hostnames      map[string][]string            // the Route 53 names which point at each of the ELBs, keyed by name
+	unused         []string                       // the ELBs without any Route 53 records, which are probably unused
+	cost           *costEstimate                  // the monthly cost of the ELBs that this LB replaces and of this LB, or nil if unknown
+
+	listenerCertificates []listenerCertificates        // the default and SNI certificates for each port which has any, once they're planned
+	healthChecks         map[string]*targetHealthCheck // the health check for the target groups of each ELB, keyed by name, once they're planned
+}
+
+// routingRule sends the requests for one of the ELBs that an ALB replaces to the target group which
+// takes its place, by matching on the Host header
+type routingRule struct {
+	elb       string   // the ELB whose instances the target group contains
+	dnsName   string   // the ELB's own DNS name, which the hostnames point at today
+	hostnames []string // the Route 53 names for the ELB, which the rule matches. Empty if we didn't find any.
+	ports     []int    // the ports that the ELB listens on, in ascending order
+}
+
+// targetGroup is where the LB sends some of the traffic of an ELB that it replaces: the ELB's
+// instances, reached with the protocol and port that the ELB's listeners used
+type targetGroup struct {
+	name     string // the ELB's name, with the backend when the ELB needs more than one target group
+	elb      string
+	protocol string // the ELB's InstanceProtocol, such as "HTTP"
+	port     int    // the ELB's InstancePort
+	ports    []int  // the LB's ports which forward to the target group, in ascending order
+
+	healthCheck *targetHealthCheck // translated from the ELB's, or nil if it doesn't have one or it isn't planned
+}
+
+// backendTargetGroups returns a target group for each distinct backend of the ELB's listeners, in
+// ascending order of the first port which uses it
+func backendTargetGroups(description *elb.LoadBalancerDescription) []targetGroup {
+	res := make([]targetGroup, 0)
+
+	for _, listener := range sortedListeners(description) {
+		tg := targetGroup{
+			name:     *description.LoadBalancerName,
+			elb:      *description.LoadBalancerName,
+			protocol: aws.StringValue(listener.InstanceProtocol),
+			port:     int(aws.Int64Value(listener.InstancePort)),
+		}
+
+		found := false
+		for i := range res {
+			if res[i].protocol == tg.protocol && res[i].port == tg.port {
+				res[i].ports = append(res[i].ports, int(*listener.LoadBalancerPort))
+				found = true
+			}
+		}
+		if !found {
+			tg.ports = []int{int(*listener.LoadBalancerPort)}
+			res = append(res, tg)
+		}
+	}
+
+	if len(res) > 1 {
+		for i := range res {
+			res[i].name = fmt.Sprintf("%s-%s-%d", res[i].elb, strings.ToLower(res[i].protocol), res[i].port)
+		}
+	}
+
+	return res
+}
+
+// tlsListener is a port where the LB terminates TLS, in place of an ELB's SSL listener
+type tlsListener struct {
+	port        int
+	certificate string // the ARN of the ELB's certificate
+}
+
+// widening is access that a security reviewer needs to approve: clients which will be able to reach
+// an ELB's backends once it shares a load balancer, but which can't reach them today.
+type widening struct {
+	elb     string        // the ELB whose backends become reachable
+	clients []ingressRule // what the shared load balancer allows that the ELB's own security groups don't
 }
 
 // newLB creates a new LB ready for use. It will expose the listener ports of the provided non-nil
@@ -221,7 +576,11 @@ func newLB(elb *elb.LoadBalancerDescription) *LB {
 	res := &LB{
 		elbs:           []string{},
 		ports:          make(map[int]struct{}),
+		tls:            make(map[int]struct{}),
+		certificates:   make(map[int][]string),
 		securityGroups: make(map[string]struct{}),
+		scheme:         aws.StringValue(elb.Scheme),
+		hostnames:      make(map[string][]string),
 	}
 
 	res.replaceELB(elb)
@@ -230,10 +589,12 @@ func newLB(elb *elb.LoadBalancerDescription) *LB {
 }
 
 // replaceELB adds the specified ELB to the set of ELBs that this LB can replace. It will expose
-// the same listener ports and use the same Security Groups.
+// the same listener ports, with the certificates of its SSL listeners, and use the same Security Groups.
 func (lb *LB) replaceELB(elb *elb.LoadBalancerDescription) {
 	lb.elbs = append(lb.elbs, *elb.LoadBalancerName)
+	lb.descriptions = append(lb.descriptions, elb)
 	lb.addPorts(listenerPorts(elb.ListenerDescriptions))
+	lb.addCertificates(elb.ListenerDescriptions)
 	lb.addSecurityGroups(elb.SecurityGroups)
 }
 
@@ -245,6 +606,28 @@ func (lb *LB) addPorts(ports []int) {
 	}
 }
 
+func (lb *LB) addCertificates(listeners []*elb.ListenerDescription) {
+	for _, ld := range listeners {
+		port := int(*ld.Listener.LoadBalancerPort)
+		if aws.StringValue(ld.Listener.Protocol) == "SSL" {
+			lb.tls[port] = struct{}{}
+		}
+
+		if cert := aws.StringValue(ld.Listener.SSLCertificateId); cert != "" && !hasCertificate(lb.certificates[port], cert) {
+			lb.certificates[port] = append(lb.certificates[port], cert)
+		}
+	}
+}
+
+func hasCertificate(certificates []string, cert string) bool {
+	for _, c := range certificates {
+		if c == cert {
+			return true
+		}
+	}
+	return false
+}
+
 // hasPortCollision returns true if the specified ELB has any listening ports matching ports
 // already assigned by this LB
 func (lb *LB) hasPortCollision(elb *elb.LoadBalancerDescription) bool {
@@ -264,6 +647,103 @@ func (lb *LB) addSecurityGroups(securityGroups []*string) {
 	}
 }
 
+// Rationale returns the non-nil array of explanations of why security groups were or weren't judged
+// to have equivalent ingress
+func (lb *LB) Rationale() []string {
+	if lb.rationale == nil {
+		return []string{}
+	}
+	return lb.rationale
+}
+
+// Scheme returns "internet-facing" or "internal", or empty if the ELBs didn't say
+func (lb *LB) Scheme() string {
+	return lb.scheme
+}
+
+// Hostnames returns the non-nil array of Route 53 names which point at one of the ELBs that the LB
+// replaces
+func (lb *LB) Hostnames(elb string) []string {
+	if res, ok := lb.hostnames[elb]; ok {
+		return res
+	}
+	return []string{}
+}
+
+// ProbablyUnused returns the non-nil array of ELBs that no Route 53 records point at. It's always
+// empty if Route 53 wasn't read.
+func (lb *LB) ProbablyUnused() []string {
+	if lb.unused == nil {
+		return []string{}
+	}
+	return lb.unused
+}
+
+// RoutingRules returns a rule for each of the ELBs that the LB replaces, in the same order as ELBs
+func (lb *LB) RoutingRules() []routingRule {
+	res := make([]routingRule, 0, len(lb.descriptions))
+	for _, description := range lb.descriptions {
+		ports := listenerPorts(description.ListenerDescriptions)
+		sort.Ints(ports)
+
+		res = append(res, routingRule{
+			elb:       *description.LoadBalancerName,
+			dnsName:   aws.StringValue(description.DNSName),
+			hostnames: lb.Hostnames(*description.LoadBalancerName),
+			ports:     ports,
+		})
+	}
+	return res
+}
+
+// TargetGroups returns the non-nil array of target groups that the LB needs, for each of the ELBs in
+// the same order as ELBs
+func (lb *LB) TargetGroups() []targetGroup {
+	res := make([]targetGroup, 0, len(lb.descriptions))
+	for _, description := range lb.descriptions {
+		for _, tg := range backendTargetGroups(description) {
+			tg.healthCheck = lb.healthChecks[tg.elb]
+			res = append(res, tg)
+		}
+	}
+	return res
+}
+
+// Split returns the quota which meant that this LB had to be separate from another, or nil
+func (lb *LB) Split() *quotaSplit {
+	return lb.split
+}
+
+// Cost returns the estimated monthly cost of the ELBs that this LB replaces and of the LB itself, or
+// nil if we don't have prices for the region
+func (lb *LB) Cost() *costEstimate {
+	return lb.cost
+}
+
+// Widenings returns the non-nil array of access which sharing this LB would add
+func (lb *LB) Widenings() []widening {
+	if lb.widenings == nil {
+		return []widening{}
+	}
+	return lb.widenings
+}
+
+// TLSListeners returns the non-nil array of ports which terminate TLS with the certificates of the
+// ELBs' SSL listeners, in ascending order of port
+func (lb *LB) TLSListeners() []tlsListener {
+	res := make([]tlsListener, 0, len(lb.tls))
+	for _, port := range lb.portNumbers() {
+		if _, ok := lb.tls[port]; ok {
+			l := tlsListener{port: port}
+			if certificates := lb.certificatesFor(port); len(certificates) > 0 {
+				l.certificate = certificates[0]
+			}
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
 // ELBs returns the non-nil array of ELB names that can be replaced by this LB
 func (lb *LB) ELBs() []string {
 	return lb.elbs
@@ -271,6 +751,17 @@ func (lb *LB) ELBs() []string {
 
 // Ports returns the non-nil array of ports that the ALB should listen on
 func (lb *LB) Ports() []string {
+	res := lb.portNumbers()
+
+	buf := make([]string, len(res))
+	for i := range res {
+		buf[i] = strconv.Itoa(res[i])
+	}
+	return buf
+}
+
+// portNumbers returns the non-nil array of ports that the ALB should listen on, in ascending order
+func (lb *LB) portNumbers() []int {
 	res := make([]int, 0)
 	for k := range lb.ports {
 		res = append(res, k)
@@ -279,11 +770,7 @@ func (lb *LB) Ports() []string {
 	// We sort the ports in ascending order, because that seems like a reasonable expectation
 	sort.Ints(res)
 
-	buf := make([]string, len(res))
-	for i := range res {
-		buf[i] = strconv.Itoa(res[i])
-	}
-	return buf
+	return res
 }
 
 // SecurityGroups returns the non-nil array of security groups names that the ALB should have attached
@@ -310,7 +797,22 @@ func listenerPorts(listeners []*elb.ListenerDescription) []int {
 	return result
 }
 
-func generateRecommendations(elbs []*elb.LoadBalancerDescription, sgs map[string]*ec2.SecurityGroup) []recommendation {
+// generateRecommendations recommends replacements for the ELBs, using only their security groups to
+// decide which of them can share a load balancer. The error has each ELB which was skipped because it
+// couldn't be classified.
+func generateRecommendations(elbs []*elb.LoadBalancerDescription, sgs map[string]*ec2.SecurityGroup) ([]recommendation, error) {
+	res, skipped := analyse(&inventory{elbs: elbs, securityGroups: sgs}, &analysisOptions{sgPolicy: sgPolicyEquivalent})
+
+	errs := make([]error, 0, len(skipped))
+	for _, err := range skipped {
+		errs = append(errs, err)
+	}
+	return res, errors.Join(errs...)
+}
+
+// analyse recommends replacements for the ELBs in the inventory. ELBs which can't be classified are
+// skipped, and returned in order of name.
+func analyse(inv *inventory, options *analysisOptions) ([]recommendation, []*classificationError) {
 	// for lb in elbs
 	//   assign the tier
 	//   assign the candidate type
@@ -322,13 +824,60 @@ func generateRecommendations(elbs []*elb.LoadBalancerDescription, sgs map[string
 	//       does it speak both TCP and HTTP(S)
 	//    find the type with the equivalent security group
 
-	tiers := newTiers(sgs)
+	tiers := newTiers(inv.securityGroups, inv.prefixLists)
+	tiers.sgPolicy = options.sgPolicy
+	tiers.hostnames = inv.hostnames
+	if options.solver != "" {
+		tiers.solver = options.solver
+	}
+	if options.quotas != nil {
+		tiers.quotas = options.quotas
+	}
+
+	unused := make([]*elb.LoadBalancerDescription, 0)
+	for _, lb := range inv.elbs {
+		// Without subnets, such as in EC2-Classic, an ELB doesn't belong to any tier
+		if len(lb.Subnets) == 0 {
+			tiers.skipped = append(tiers.skipped, &classificationError{elb: *lb.LoadBalancerName, err: errors.New("it has no subnets")})
+			continue
+		}
+		// Whether an ELB is unused doesn't depend on its listeners, so it's deleted even if we can't
+		// classify them
+		if usage, ok := inv.usage[*lb.LoadBalancerName]; ok && len(unusedReasons(lb, usage)) > 0 {
+			unused = append(unused, lb)
+			continue
+		}
+		// An ELB that we can't classify mustn't merge tiers either
+		if _, err := inspectListeners(lb); err != nil {
+			tiers.skipped = append(tiers.skipped, &classificationError{elb: *lb.LoadBalancerName, err: err})
+			continue
+		}
+		assignTier(tiers, lb)
+	}
+
+	// Unused ELBs are assigned once the tiers are settled, so that they can't merge any
+	for _, lb := range unused {
+		assignDeletion(tiers, lb, unusedReasons(lb, inv.usage[*lb.LoadBalancerName]))
+	}
+
+	res := tiers.recommendations()
+
+	for i := range res {
+		res[i].planCertificates(inv.certificates, tiers.quotas.Certificates)
+		res[i].planHealthChecks()
+	}
 
-	for _, lb := range elbs {
-		elbDrop(tiers, lb)
+	if rates := options.pricing.ratesFor(inv.region); rates != nil {
+		for i := range res {
+			res[i].estimateCost(rates, inv.usage)
+		}
 	}
 
-	return tiers.recommendations()
+	sort.Slice(tiers.skipped, func(i, j int) bool {
+		return tiers.skipped[i].elb < tiers.skipped[j].elb
+	})
+
+	return res, tiers.skipped
 }
 
 // elbReplacementStrategy captures the distinctions between replacing with an ALB, replacing with an NLB, or trying to
@@ -339,10 +888,12 @@ type elbReplacementStrategy interface {
 	isFirstOfThisType() bool
 	loadBalancersBySecurityGroup() map[string]*LB
 	supportsPortCollisions() bool
+	exceededQuota(lb *LB, candidate *elb.LoadBalancerDescription) *quotaSplit
 }
 
 type replaceWithALB struct {
 	recommendation *recommendation
+	quotas         *albQuotas
 }
 
 func (r *replaceWithALB) add(alb *LB) {
@@ -366,6 +917,10 @@ func (r *replaceWithALB) supportsPortCollisions() bool {
 	return true
 }
 
+func (r *replaceWithALB) exceededQuota(alb *LB, candidate *elb.LoadBalancerDescription) *quotaSplit {
+	return r.quotas.exceededBy(alb, candidate)
+}
+
 type replaceWithNLB struct {
 	recommendation *recommendation
 }
@@ -391,6 +946,11 @@ func (r *replaceWithNLB) supportsPortCollisions() bool {
 	return false
 }
 
+func (r *replaceWithNLB) exceededQuota(nlb *LB, candidate *elb.LoadBalancerDescription) *quotaSplit {
+	// Without port collisions, the ports are what limit an NLB
+	return nil
+}
+
 type consolidateELBs struct {
 	recommendation *recommendation
 }
@@ -416,24 +976,44 @@ func (r *consolidateELBs) supportsPortCollisions() bool {
 	return false
 }
 
+func (r *consolidateELBs) exceededQuota(elb *LB, candidate *elb.LoadBalancerDescription) *quotaSplit {
+	// Without port collisions, the ports are what limit a classic ELB
+	return nil
+}
+
 // elbDrop is modelled after a penny fall machine that you might see at an arcade.
 //
-// 1. The first level assesses which subnets the ELB is in.
+// 1. The first level assesses which subnets the ELB is in. assignTier does this for every ELB before
+//    any of them drop further, since a later ELB can bridge two tiers and merge them.
 // 2. The second level decides which type of LB might replace the ELB
 // 3. The third level looks at the security groups and see if an existing replacement has the same
 //    security groups
-func elbDrop(tiers *tiers, lb *elb.LoadBalancerDescription) {
-	recommendation := assignTier(tiers, lb)
-	targetLB := inspectListeners(lb)
+func elbDrop(tiers *tiers, recommendation *recommendation, lb *elb.LoadBalancerDescription) error {
+	targetLB, err := inspectListeners(lb)
+	if err != nil {
+		return err
+	}
+
+	strategy, err := replacementStrategyFor(recommendation, targetLB, tiers.quotas)
+	if err != nil {
+		return err
+	}
+
+	addELBv2(lb, tiers, strategy)
+	return nil
+}
+
+// replacementStrategyFor returns the strategy for adding ELBs to the recommendation as the given type
+func replacementStrategyFor(recommendation *recommendation, targetLB lbType, quotas *albQuotas) (elbReplacementStrategy, error) {
 	switch targetLB {
 	case ALB:
-		addELBv2(lb, tiers, &replaceWithALB{recommendation})
+		return &replaceWithALB{recommendation, quotas}, nil
 	case NLB:
-		addELBv2(lb, tiers, &replaceWithNLB{recommendation})
+		return &replaceWithNLB{recommendation}, nil
 	case ELB:
-		addELBv2(lb, tiers, &consolidateELBs{recommendation})
+		return &consolidateELBs{recommendation}, nil
 	default:
-		panic("Uknown type of LB")
+		return nil, fmt.Errorf("unknown type of load balancer %d", targetLB)
 	}
 }
 
@@ -448,161 +1028,583 @@ func addELBv2(lb *elb.LoadBalancerDescription, tiers *tiers, replacementStrategy
 		return
 	}
 
-	for _, lbSecurityGroup := range lb.SecurityGroups {
+	if tiers.sgPolicy == sgPolicySubset {
+		added, rationale, split := addToSuperset(lb, tiers, replacementStrategy)
+		if !added {
+			res := newLB(lb)
+			res.rationale = rationale
+			res.split = split
+			replacementStrategy.add(res)
+			replacementStrategy.associate(res, lb.SecurityGroups)
+		}
+		return
+	}
+
+	// Why the ELB couldn't share an existing load balancer, if that's how it turns out
+	rationale := make([]string, 0)
+	var split *quotaSplit
+
+	// Everything is visited in sorted order, so that the outcome doesn't depend on map iteration or on
+	// the order that AWS listed the security groups in
+	for _, lbSecurityGroup := range securityGroupIDs(lb) {
 		// do we have an existing one with this security group?
-		elbv2, ok := replacementStrategy.loadBalancersBySecurityGroup()[*lbSecurityGroup]
+		elbv2, ok := replacementStrategy.loadBalancersBySecurityGroup()[lbSecurityGroup]
 		if ok && (replacementStrategy.supportsPortCollisions() || !elbv2.hasPortCollision(lb)) {
-			replacementStrategy.associate(elbv2, lb.SecurityGroups)
-			elbv2.replaceELB(lb)
-			return
+			if exceeded := replacementStrategy.exceededQuota(elbv2, lb); exceeded != nil {
+				split = exceeded
+			} else {
+				replacementStrategy.associate(elbv2, lb.SecurityGroups)
+				elbv2.replaceELB(lb)
+				return
+			}
 		}
 
 		// Have we already processed an SG which has the same ingress?
-		for seenSg := range replacementStrategy.loadBalancersBySecurityGroup() {
-			if tiers.hasSameIngress(seenSg, *lbSecurityGroup) {
-				existing := replacementStrategy.loadBalancersBySecurityGroup()[seenSg]
-				if replacementStrategy.supportsPortCollisions() || !existing.hasPortCollision(lb) {
-					replacementStrategy.associate(existing, lb.SecurityGroups)
-					existing.replaceELB(lb)
-					return
+		for _, seenSg := range sortedSecurityGroupIDs(replacementStrategy.loadBalancersBySecurityGroup()) {
+			if seenSg == lbSecurityGroup {
+				continue
+			}
+
+			comparison := tiers.compareIngress(seenSg, lbSecurityGroup)
+			if !comparison.equivalent() {
+				rationale = append(rationale, fmt.Sprintf("%s: %s", *lb.LoadBalancerName, comparison.reason()))
+				continue
+			}
+
+			existing := replacementStrategy.loadBalancersBySecurityGroup()[seenSg]
+			if replacementStrategy.supportsPortCollisions() || !existing.hasPortCollision(lb) {
+				if exceeded := replacementStrategy.exceededQuota(existing, lb); exceeded != nil {
+					split = exceeded
+					continue
 				}
+				replacementStrategy.associate(existing, lb.SecurityGroups)
+				existing.replaceELB(lb)
+				existing.rationale = append(existing.rationale, fmt.Sprintf("%s: %s", *lb.LoadBalancerName, comparison.reason()))
+				return
 			}
 		}
 	}
 
 	// Distinctly new SecurityGroup – a new ELBv2 then
 	res := newLB(lb)
+	res.rationale = rationale
+	res.split = split
 	replacementStrategy.add(res)
 	replacementStrategy.associate(res, lb.SecurityGroups)
 }
 
-func inspectListeners(lb *elb.LoadBalancerDescription) lbType {
+// securityGroupIDs returns the IDs of the ELB's security groups in sorted order
+func securityGroupIDs(lb *elb.LoadBalancerDescription) []string {
+	res := aws.StringValueSlice(lb.SecurityGroups)
+	sort.Strings(res)
+	return res
+}
+
+// sortedSecurityGroupIDs returns the keys of a map of load balancers by security group, in sorted order
+func sortedSecurityGroupIDs(lbsBySg map[string]*LB) []string {
+	res := make([]string, 0, len(lbsBySg))
+	for sg := range lbsBySg {
+		res = append(res, sg)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// addToSuperset adds the ELB to the first existing LB whose security groups allow everything that the
+// ELB's security groups do. Anything extra that the LB allows is recorded as a widening, since those
+// clients will be able to reach the ELB's backends. It returns false, the reasons why, and any quota
+// which kept it off an LB, if there's no such LB.
+func addToSuperset(lb *elb.LoadBalancerDescription, tiers *tiers, replacementStrategy elbReplacementStrategy) (bool, []string, *quotaSplit) {
+	rationale := make([]string, 0)
+	var split *quotaSplit
+
+	elbSecurityGroups := securityGroupIDs(lb)
+	elbIngress := tiers.ingressOf(elbSecurityGroups)
+
+	bySg := replacementStrategy.loadBalancersBySecurityGroup()
+
+	considered := make(map[*LB]struct{})
+	for _, sg := range sortedSecurityGroupIDs(bySg) {
+		existing := bySg[sg]
+		if _, ok := considered[existing]; ok {
+			continue
+		}
+		considered[existing] = struct{}{}
+
+		if !replacementStrategy.supportsPortCollisions() && existing.hasPortCollision(lb) {
+			continue
+		}
+
+		existingSecurityGroups := existing.SecurityGroups()
+		comparison := compareIngress(
+			strings.Join(existingSecurityGroups, "+"), tiers.ingressOf(existingSecurityGroups),
+			strings.Join(elbSecurityGroups, "+"), elbIngress)
+
+		if len(comparison.onlyIn2) > 0 {
+			rationale = append(rationale, fmt.Sprintf("%s: %s", *lb.LoadBalancerName, comparison.reason()))
+			continue
+		}
+
+		if exceeded := replacementStrategy.exceededQuota(existing, lb); exceeded != nil {
+			split = exceeded
+			continue
+		}
+
+		replacementStrategy.associate(existing, lb.SecurityGroups)
+		existing.replaceELB(lb)
+
+		if comparison.equivalent() {
+			existing.rationale = append(existing.rationale, fmt.Sprintf("%s: %s", *lb.LoadBalancerName, comparison.reason()))
+		} else {
+			existing.rationale = append(existing.rationale, fmt.Sprintf("%s: %s allows everything that %s does, and more",
+				*lb.LoadBalancerName, comparison.sg1, comparison.sg2))
+			existing.widenings = append(existing.widenings, widening{elb: *lb.LoadBalancerName, clients: comparison.onlyIn1})
+		}
+
+		return true, nil, nil
+	}
+
+	return false, rationale, split
+}
+
+// inspectListeners decides which type of load balancer could replace the ELB, from the protocols of
+// its listeners
+func inspectListeners(lb *elb.LoadBalancerDescription) (lbType, error) {
 	protocols := make(map[string]struct{})
+	unknown := make([]string, 0)
 
 	for _, ld := range lb.ListenerDescriptions {
-		switch *ld.Listener.Protocol {
+		switch protocol := aws.StringValue(ld.Listener.Protocol); protocol {
 		case "HTTP", "HTTPS":
 			protocols["HTTP"] = struct{}{}
+		case "SSL":
+			// An NLB can terminate TLS with the same certificate
+			protocols["TCP"] = struct{}{}
 		case "TCP":
 			if *ld.Listener.LoadBalancerPort == 80 || *ld.Listener.LoadBalancerPort == 443 {
 				protocols["HTTP"] = struct{}{}
 			} else {
 				protocols["TCP"] = struct{}{}
 			}
+		default:
+			unknown = append(unknown, fmt.Sprintf("%s on port %d", protocol, aws.Int64Value(ld.Listener.LoadBalancerPort)))
 		}
 	}
 
 	switch len(protocols) {
 	case 0:
-		panic("No known protocols for this listener")
+		if len(unknown) == 0 {
+			return 0, errors.New("it has no listeners")
+		}
+		return 0, fmt.Errorf("none of its listeners use HTTP, HTTPS, SSL or TCP (it has %s)", strings.Join(unknown, ", "))
 	case 1:
 		if _, ok := protocols["HTTP"]; ok {
-			return ALB
+			return ALB, nil
 		}
-		return NLB
+		return NLB, nil
 	default:
-		return ELB
+		return ELB, nil
 	}
 }
 
-func assignTier(tiers *tiers, lb *elb.LoadBalancerDescription) *recommendation {
+// assignTier adds the ELB to the tier containing its subnets, merging tiers if the ELB spans more than
+// one of them.
+func assignTier(tiers *tiers, lb *elb.LoadBalancerDescription) *tier {
 	var t *tier
 
+	vpc := aws.StringValue(lb.VPCId)
+	scheme := aws.StringValue(lb.Scheme)
+
 	for _, s := range lb.Subnets {
 		if t != nil {
-			tiers.associate(t, s)
+			t = tiers.union(t, tiers.find(vpc, scheme, s))
 		} else {
-			t = tiers.find(s)
+			t = tiers.find(vpc, scheme, s)
 		}
 	}
 
-	return t.recommendation
+	t.elbs = append(t.elbs, lb)
+
+	return t
+}
+
+// assignDeletion adds an unused ELB to the tier of its first subnet, without merging any tiers
+func assignDeletion(tiers *tiers, lb *elb.LoadBalancerDescription, reasons []string) *tier {
+	subnets := aws.StringValueSlice(lb.Subnets)
+	sort.Strings(subnets)
+
+	t := tiers.find(aws.StringValue(lb.VPCId), aws.StringValue(lb.Scheme), &subnets[0])
+	t.deletions = append(t.deletions, deletion{elb: *lb.LoadBalancerName, reasons: reasons})
+
+	return t
 }
 
 func main() {
 	args := parseAndVerifyArgs()
 
-	options := session.Options{
-		SharedConfigState: session.SharedConfigEnable,
+	start := time.Now()
+
+	var (
+		inventories []*inventory
+		problems    []error // what we skipped while reporting on everything else
+		err         error
+	)
+
+	if args.input != "" {
+		if inventories, err = loadSnapshot(args.input); err != nil {
+			exitWithError(err)
+		}
+	} else {
+		if inventories, problems, err = collect(args); err != nil {
+			exitWithError(err)
+		}
 	}
 
-	if args.profile != "" {
-		options.Profile = args.profile
+	if args.command == snapshotCommand {
+		if err := saveSnapshot(args.out, inventories); err != nil {
+			exitWithError(err)
+		}
+		fmt.Printf("Wrote a snapshot of %d region(s) to %s in %v\n", len(inventories), args.out, time.Since(start))
+		exitWithProblems(problems)
+		return
 	}
 
-	start := time.Now()
+	fmt.Fprintf(os.Stderr, "Read AWS account(s) in %v, generating recommendations...\n\n", time.Since(start))
 
-	sess := session.Must(session.NewSessionWithOptions(options))
+	if args.prices == pricingAPI {
+		// The Pricing API is only served from a few regions, which have the prices for all of them
+		config := aws.NewConfig().WithRegion("us-east-1").WithMaxRetries(3)
+		sess, err := newSession(args)
+		if err != nil {
+			exitWithError(err)
+		}
+		if args.pricing, err = fetchPricing(pricing.New(sess, config), inventoryRegions(inventories), args.pricing); err != nil {
+			exitWithError(err)
+		}
+	}
 
-	// Do retries in case we hit the API too hard and get throttled for exceeding our allowed rate.
-	elbSvc := elb.New(sess, aws.NewConfig().WithMaxRetries(3))
-	ec2Svc := ec2.New(sess, aws.NewConfig().WithMaxRetries(3))
+	reports := generateReports(inventories, &analysisOptions{sgPolicy: args.sgPolicy, solver: args.solver, quotas: args.quotas, pricing: args.pricing})
+	for _, r := range reports {
+		for _, skipped := range r.skipped {
+			problems = append(problems, skipped)
+		}
+	}
 
-	input := &elb.DescribeLoadBalancersInput{}
-	elbs := make([]*elb.LoadBalancerDescription, 0)
+	switch args.output {
+	case outputJSON:
+		if err := writeJSONReports(os.Stdout, reports); err != nil {
+			exitWithError(err)
+		}
+	default:
+		printReports(reports)
+	}
 
-	err := elbSvc.DescribeLoadBalancersPages(input, func(page *elb.DescribeLoadBalancersOutput, lastPage bool) bool {
-		elbs = append(elbs, page.LoadBalancerDescriptions...)
-		return !lastPage
-	})
+	if args.emit != "" {
+		if err := emitters[args.emit].emit(args.emitDir, reports); err != nil {
+			exitWithError(err)
+		}
+	}
 
-	panicOnAwsError(err)
+	exitWithProblems(problems)
+}
 
-	sgs := make(map[string]*ec2.SecurityGroup)
+// exitWithError reports an error which stopped us from reporting at all, and exits with its code
+func exitWithError(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(exitCodeFor(err))
+}
 
-	for _, lb := range elbs {
-		if lb.SecurityGroups == nil {
-			continue
+// exitWithProblems exits with the code for the first problem, if there were any, once everything
+// else has been reported
+func exitWithProblems(problems []error) {
+	if len(problems) > 0 {
+		os.Exit(exitCodeFor(problems[0]))
+	}
+}
+
+// collect reads each region of each account described by the command line arguments. When scanning
+// several accounts, an account which can't be read is skipped, and returned with the others that were.
+func collect(args *arguments) ([]*inventory, []error, error) {
+	sess, err := newSession(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inventories := make([]*inventory, 0)
+	skipped := make([]error, 0)
+
+	accountIDs, err := resolveAccounts(args.accounts, args.org, organizations.New(sess))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(accountIDs) == 0 {
+		res, err := collectAccount(sess, "", args.regions, args.usage)
+		if err != nil {
+			return nil, nil, err
 		}
+		inventories = append(inventories, res...)
+	}
+
+	for _, id := range accountIDs {
+		accountSess := assumeRole(sess, id, args.roleName)
 
-		for _, sg := range lb.SecurityGroups {
-			if _, ok := sgs[*sg]; ok {
+		err := checkAccess(sts.New(accountSess), id)
+		if err == nil {
+			var res []*inventory
+			if res, err = collectAccount(accountSess, id, args.regions, args.usage); err == nil {
+				inventories = append(inventories, res...)
 				continue
 			}
-			result, err := ec2Svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
-				GroupIds: []*string{
-					aws.String(*sg),
-				},
-			})
-			panicOnAwsError(err)
-			sgs[*sg] = result.SecurityGroups[0]
+		}
+
+		fmt.Fprintf(os.Stderr, "Skipping account %s: %v\n", id, err)
+		skipped = append(skipped, fmt.Errorf("account %s: %w", id, err))
+	}
+
+	for _, inv := range inventories {
+		where := inv.region
+		if inv.account != "" {
+			where = fmt.Sprintf("account %s in %s", inv.account, inv.region)
+		}
+		for _, warning := range inv.warnings {
+			fmt.Fprintf(os.Stderr, "Warning for %s: %s\n", where, warning)
 		}
 	}
 
-	fmt.Printf("Read AWS account in %v, generating recommendations...\n\n", time.Since(start))
+	return inventories, skipped, nil
+}
+
+// newSession creates a session for the profile in the command line arguments
+func newSession(args *arguments) (*session.Session, error) {
+	options := session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}
 
-	recommendations := generateRecommendations(elbs, sgs)
+	if args.profile != "" {
+		options.Profile = args.profile
+	}
 
-	printRecommendations(recommendations)
+	sess, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		return nil, &authError{fmt.Errorf("unable to create a session for the profile %q: %w", args.profile, err)}
+	}
+	return sess, nil
 }
 
-func printRecommendations(recommendations []recommendation) {
-	currentElbCount, albCount, nlbCount, elbCount := 0, 0, 0, 0
+// inventoryRegions returns each region that the inventories were read from, in order
+func inventoryRegions(inventories []*inventory) []string {
+	seen := make(map[string]struct{})
+	res := make([]string, 0)
+	for _, inv := range inventories {
+		if _, ok := seen[inv.region]; !ok {
+			seen[inv.region] = struct{}{}
+			res = append(res, inv.region)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// printReports prints the recommendations for each region of each account. Each account is followed
+// by a summary if more than one region was scanned, and an organisation-wide summary is printed if
+// more than one account was scanned.
+func printReports(reports []report) {
+	total := &tally{}
+	accounts := 0
+
+	for i := 0; i < len(reports); {
+		account := reports[i].account
+		accountTotal := &tally{}
+		regions := 0
+
+		if account != "" {
+			fmt.Printf("Account %s\n\n", account)
+		}
+
+		for ; i < len(reports) && reports[i].account == account; i++ {
+			fmt.Printf("Region %s\n\n", reports[i].region)
+			accountTotal.add(printReport(reports[i]))
+			println()
+			regions++
+		}
+
+		if regions > 1 {
+			fmt.Printf("Across %d regions:\n", regions)
+			accountTotal.print()
+			println()
+		}
+
+		total.add(accountTotal)
+		accounts++
+	}
+
+	if accounts > 1 {
+		fmt.Printf("Across %d accounts:\n", accounts)
+		total.print()
+	}
+}
+
+// printReport prints the recommendations for a region, followed by the ELBs that were skipped and
+// the tally
+func printReport(r report) *tally {
+	res := printRecommendations(r.recommendations)
+
+	if len(r.skipped) > 0 {
+		fmt.Printf("\nSkipped the following load balancers, which couldn't be classified:\n")
+		for _, skipped := range r.skipped {
+			fmt.Printf("- %s, because %v\n", skipped.elb, skipped.err)
+		}
+		res.skipped = len(r.skipped)
+	}
+
+	res.print()
+	return res
+}
+
+func printRecommendations(recommendations []recommendation) *tally {
 	for _, r := range recommendations {
-		fmt.Printf("The subnets \"%s\" could contain the following load balancer(s):\n", strings.Join(r.Subnets(), ", "))
+		location := fmt.Sprintf("%q", strings.Join(r.Subnets(), ", "))
+		if r.VPC() != "" {
+			location += " in " + r.VPC()
+		}
+		kind := "load balancer(s)"
+		if r.Scheme() != "" {
+			kind = r.Scheme() + " " + kind
+		}
+		fmt.Printf("The subnets %s could contain the following %s:\n", location, kind)
+
+		printDeletions(r.Deletions())
 
-		sum := count(r.ALBs())
-		currentElbCount += sum.elbs
-		albCount += sum.lbs
 		printRecommendationFor(r.ALBs(), "ALB")
 		println()
 
-		sum = count(r.NLBs())
-		currentElbCount += sum.elbs
-		nlbCount += sum.lbs
 		printRecommendationFor(r.NLBs(), "NLB")
 		println()
 
-		sum = count(r.ELBs())
-		currentElbCount += sum.elbs
-		elbCount += sum.lbs
 		printRecommendationFor(r.ELBs(), "ELB")
 		println()
+
+		if cost := r.Cost(); cost != nil {
+			fmt.Printf("The ELBs in these subnets cost an estimated %s a month, and the load balancers above would cost %s%s\n\n",
+				dollars(cost.current), dollars(cost.projected), trafficCaveat(cost))
+		}
 	}
 
-	fmt.Printf("So %d ELBs would become %d ALBs, %d NLBs and %d ELBs\n"+
-		"with a potential saving of %0.0f%%\n", currentElbCount, albCount, nlbCount, elbCount,
-		saving(currentElbCount, albCount, nlbCount, elbCount))
+	return tallyRecommendations(recommendations)
+}
+
+// tallyRecommendations counts the ELBs covered by the recommendations, and the load balancers that
+// they recommend.
+func tallyRecommendations(recommendations []recommendation) *tally {
+	res := &tally{}
+	for _, r := range recommendations {
+		res.currentElbs += len(r.deletions)
+		res.deleted += len(r.deletions)
+
+		sum := count(r.ALBs())
+		res.currentElbs += sum.elbs
+		res.albs += sum.lbs
+
+		sum = count(r.NLBs())
+		res.currentElbs += sum.elbs
+		res.nlbs += sum.lbs
+
+		sum = count(r.ELBs())
+		res.currentElbs += sum.elbs
+		res.elbs += sum.lbs
+
+		if r.cost != nil {
+			res.addCost(r.cost)
+		} else {
+			res.uncosted++
+		}
+
+		if r.solvers != nil {
+			res.solved = true
+			res.greedy += r.solvers.greedy
+			res.optimal += r.solvers.optimal
+		}
+	}
+	return res
+}
+
+// tally is the number of load balancers that exist today, and the number of each type of load
+// balancer that we recommend instead.
+type tally struct {
+	currentElbs, albs, nlbs, elbs int
+
+	// The unused ELBs which can be deleted, and are included in currentElbs
+	deleted int
+
+	// The ELBs which couldn't be classified, and aren't included in currentElbs
+	skipped int
+
+	// With -solver=optimal, the number of load balancers that each solver recommended
+	solved          bool
+	greedy, optimal int
+
+	// The monthly costs of the recommendations which we have prices for, and how many we don't
+	cost     *costEstimate
+	uncosted int
+}
+
+func (t *tally) add(other *tally) {
+	t.currentElbs += other.currentElbs
+	t.albs += other.albs
+	t.nlbs += other.nlbs
+	t.elbs += other.elbs
+	t.deleted += other.deleted
+	t.skipped += other.skipped
+	t.solved = t.solved || other.solved
+	t.greedy += other.greedy
+	t.optimal += other.optimal
+	t.addCost(other.cost)
+	t.uncosted += other.uncosted
+}
+
+func (t *tally) addCost(cost *costEstimate) {
+	if cost == nil {
+		return
+	}
+	if t.cost == nil {
+		t.cost = &costEstimate{traffic: true}
+	}
+	t.cost.add(cost)
+}
+
+// costs returns the total monthly costs, or nil unless we have prices for every recommendation
+func (t *tally) costs() *costEstimate {
+	if t.uncosted > 0 {
+		return nil
+	}
+	return t.cost
+}
+
+// saving is the percentage of the monthly cost that the recommendations would save. Without prices,
+// it assumes that an ALB or NLB costs 10% less than an ELB.
+func (t *tally) saving() float64 {
+	if cost := t.costs(); cost != nil && cost.current > 0 {
+		return (cost.current - cost.projected) / cost.current * 100
+	}
+	return saving(t.currentElbs, t.albs, t.nlbs, t.elbs)
+}
+
+func (t *tally) print() {
+	fmt.Printf("So %d ELBs would become %d ALBs, %d NLBs and %d ELBs\n", t.currentElbs, t.albs, t.nlbs, t.elbs)
+	if t.deleted > 0 {
+		fmt.Printf("after deleting %d unused ELBs\n", t.deleted)
+	}
+	fmt.Printf("with a potential saving of %0.0f%%\n", t.saving())
+	if t.skipped > 0 {
+		fmt.Printf("not counting %d ELBs which were skipped\n", t.skipped)
+	}
+	if cost := t.costs(); cost != nil {
+		fmt.Printf("from an estimated %s a month to %s%s\n", dollars(cost.current), dollars(cost.projected), trafficCaveat(cost))
+	}
+	if t.solved {
+		fmt.Printf("The greedy solver found %d load balancers, and the optimal solver %d\n", t.greedy, t.optimal)
+	}
 }
 
 type sum struct {
@@ -620,63 +1622,333 @@ func count(lbs []*LB) *sum {
 }
 
 func saving(current, alb, nlb, elb int) float64 {
+	if current == 0 {
+		return 0
+	}
 	return (float64(current) - ((float64(alb)+float64(nlb))*0.9 + float64(elb))) / float64(current) * 100
 }
 
+// action is what we recommend doing with the ELBs that an LB covers
+type action string
+
+const (
+	replace   action = "replace"
+	retain    action = "retain"
+	deleteELB action = "delete"
+)
+
+// actionFor decides whether the LB replaces its ELBs, or whether it's a single ELB that we keep as-is
+func actionFor(lb *LB, lbType string) action {
+	if len(lb.ELBs()) == 1 && lbType == "ELB" {
+		return retain
+	}
+	return replace
+}
+
+// printDeletions lists the unused ELBs, which come before anything that we replace or retain
+func printDeletions(deletions []deletion) {
+	if len(deletions) == 0 {
+		return
+	}
+
+	fmt.Printf("\nDeleting the following unused load balancers:\n")
+	for _, d := range deletions {
+		fmt.Printf("- %s, because %s\n", d.elb, strings.Join(d.reasons, " and "))
+	}
+}
+
 func printRecommendationFor(lbs []*LB, lbType string) {
 	for _, lb := range lbs {
-		var action string
-		if len(lb.ELBs()) == 1 && lbType == "ELB" {
+		action := "Replacing"
+		if actionFor(lb, lbType) == retain {
 			action = "Retaining"
-		} else {
-			action = "Replacing"
 		}
 		fmt.Printf("\n%s the following load balancers:\n- %s\n\n -> an %s with security groups:\n\t- %s\nexposing the ports:\n\t- %s\n",
 			action,
-			strings.Join(lb.ELBs(), "\n- "),
+			strings.Join(describeELBs(lb), "\n- "),
 			lbType,
 			strings.Join(lb.SecurityGroups(), "\n\t- "),
 			strings.Join(lb.Ports(), "\n\t- "))
+		if len(lb.Rationale()) > 0 {
+			fmt.Printf("because:\n\t- %s\n", strings.Join(lb.Rationale(), "\n\t- "))
+		}
+		for _, w := range lb.Widenings() {
+			fmt.Printf("which widens access, so that the backends of %s can also be reached by:\n\t- %s\n",
+				w.elb, joinRules(w.clients))
+		}
+		if lbType == "ALB" {
+			fmt.Printf("routing on the Host header:\n")
+			for _, rule := range lb.RoutingRules() {
+				hosts := strings.Join(rule.hostnames, ", ")
+				if hosts == "" {
+					hosts = "(no Route 53 records found, so the hostnames are needed)"
+				}
+				fmt.Printf("\t- %s -> the target group for %s, on ports %s\n", hosts, rule.elb, joinPorts(rule.ports))
+			}
+		}
+		if lbType != "ELB" {
+			fmt.Printf("with a target group for each backend:\n")
+			for _, tg := range lb.TargetGroups() {
+				fmt.Printf("\t- %s, reaching the instances of %s with %s, from ports %s\n", tg.name, tg.elb, describeBackend(tg), joinPorts(tg.ports))
+				if lbType == "ALB" && reviewBackend(tg) != "" {
+					fmt.Printf("\t  but %s\n", reviewBackend(tg))
+				}
+				printHealthCheck(tg.healthCheck)
+			}
+		}
+		if lbType == "NLB" && len(lb.TLSListeners()) > 0 {
+			ports := make([]int, 0)
+			for _, l := range lb.TLSListeners() {
+				ports = append(ports, l.port)
+			}
+			fmt.Printf("terminating TLS on ports %s\n", joinPorts(ports))
+		}
+		printCertificates(lb.Certificates())
+		if split := lb.Split(); split != nil {
+			fmt.Printf("and is separate from another %s, which would otherwise have more than the quota of %s\n", lbType, split)
+		}
+		if cost := lb.Cost(); cost != nil {
+			fmt.Printf("costing an estimated %s a month, instead of %s for the ELBs today\n", dollars(cost.projected), dollars(cost.current))
+		}
+	}
+}
+
+// describeBackend is the protocol and port that the target group reaches the instances with
+func describeBackend(tg targetGroup) string {
+	if tg.protocol == "" {
+		return fmt.Sprintf("port %d", tg.port)
+	}
+	return fmt.Sprintf("%s on port %d", tg.protocol, tg.port)
+}
+
+// printHealthCheck describes a target group's health check, with how it differs from the ELB's
+func printHealthCheck(hc *targetHealthCheck) {
+	if hc == nil {
+		return
+	}
+	fmt.Printf("\t  checking %s every %ds, timing out after %ds, healthy after %d checks and unhealthy after %d\n",
+		hc.target(), hc.interval, hc.timeout, hc.healthyThreshold, hc.unhealthyThreshold)
+	for _, difference := range hc.differences {
+		fmt.Printf("\t  but %s\n", difference)
+	}
+}
+
+// printCertificates lists the default and SNI certificates for each port, with any hostnames that
+// they don't cover and any certificates which are over the quota
+func printCertificates(plans []listenerCertificates) {
+	for _, plan := range plans {
+		fmt.Printf("with the certificates for port %d:\n\t- %s, by default\n", plan.port, plan.defaultCertificate)
+		for _, arn := range plan.sni {
+			fmt.Printf("\t- %s, chosen by SNI\n", arn)
+		}
+		if len(plan.uncovered) > 0 {
+			fmt.Printf("which don't cover %s\n", strings.Join(plan.uncovered, ", "))
+		}
+		if len(plan.unknown) > 0 {
+			fmt.Printf("but the names of %s couldn't be read, so they might not cover every hostname\n", strings.Join(plan.unknown, ", "))
+		}
+		if len(plan.missing) > 0 {
+			fmt.Printf("but %s no longer exist, so they need replacing\n", strings.Join(plan.missing, ", "))
+		}
+		if plan.exceeded != nil {
+			fmt.Printf("which is more than the quota of %s\n", plan.exceeded)
+		}
+	}
+}
+
+// dollars formats a cost in USD
+func dollars(amount float64) string {
+	return fmt.Sprintf("$%.2f", amount)
+}
+
+// trafficCaveat explains that a cost estimate leaves out the traffic, when we didn't read it
+func trafficCaveat(cost *costEstimate) string {
+	if cost.traffic {
+		return ""
+	}
+	return " (hourly charges only, since -usage-window wasn't given)"
+}
+
+// describeELBs lists the ELBs that the LB replaces, with the hostnames which point at each of them
+func describeELBs(lb *LB) []string {
+	unused := make(map[string]struct{})
+	for _, name := range lb.ProbablyUnused() {
+		unused[name] = struct{}{}
 	}
+
+	res := make([]string, 0, len(lb.ELBs()))
+	for _, name := range lb.ELBs() {
+		if hostnames := lb.Hostnames(name); len(hostnames) > 0 {
+			name += " (" + strings.Join(hostnames, ", ") + ")"
+		} else if _, ok := unused[name]; ok {
+			name += " (no DNS records point at it, so it's probably unused)"
+		}
+		res = append(res, name)
+	}
+	return res
+}
+
+// joinPorts lists the ports, separated by commas
+func joinPorts(ports []int) string {
+	res := make([]string, len(ports))
+	for i := range ports {
+		res[i] = strconv.Itoa(ports[i])
+	}
+	return strings.Join(res, ", ")
 }
 
 func parseAndVerifyArgs() *arguments {
 	var (
-		help bool
+		help         bool
+		quotasFile   string
+		usageWindow  string
+		rules        int
+		certificates int
+		targetGroups int
+		listeners    int
 	)
 
 	res := &arguments{}
 
 	flag.BoolVar(&help, "help", false, "Display this help message")
 	flag.StringVar(&res.profile, "profile", "", "The AWS profile name to use")
+	flag.StringVar(&res.accounts, "accounts", "", "Comma-separated list of AWS account IDs to scan by assuming -role-name in each")
+	flag.BoolVar(&res.org, "org", false, "Scan every active account in the AWS Organization by assuming -role-name in each")
+	flag.StringVar(&res.roleName, "role-name", "", "The name of a read-only IAM role to assume in each account when using -accounts or -org")
+	flag.StringVar(&res.input, "input", "", "Read a snapshot file instead of AWS. No AWS credentials are needed")
+	flag.StringVar(&res.out, "out", "elb-pruner-snapshot.json", "The file that the snapshot subcommand writes to")
+	flag.StringVar(&res.output, "output", outputText, "The format of the recommendations: \"text\" or \"json\"")
+	flag.StringVar(&res.emit, "emit", "", "Also generate infrastructure code for the recommended load balancers: "+strings.Join(emitterNames(), ", "))
+	flag.StringVar(&res.emitDir, "emit-dir", ".", "The directory that -emit writes to")
+	flag.StringVar(&res.sgPolicy, "sg-policy", sgPolicyEquivalent, "When ELBs with different security groups can share a load balancer: \"equivalent\" if they allow the same ingress, or \"subset\" if one allows everything that the other does (the extra access is reported)")
+	flag.StringVar(&res.solver, "solver", solverGreedy, "How ELBs are grouped into load balancers: \"greedy\" or \"optimal\", which searches for the fewest load balancers and reports how many the greedy solver would use")
+	flag.StringVar(&quotasFile, "quotas", "", "A JSON file of ALB quotas which override the AWS defaults, with any of \"rulesPerListener\", \"certificatesPerListener\", \"targetGroupsPerLoadBalancer\" and \"listenersPerLoadBalancer\"")
+	flag.IntVar(&rules, "max-rules", 0, "The most host-based rules on each listener of an ALB, overriding -quotas and the AWS default of 100")
+	flag.IntVar(&certificates, "max-certificates", 0, "The most certificates on each listener of an ALB besides its default, overriding -quotas and the AWS default of 25")
+	flag.IntVar(&targetGroups, "max-target-groups", 0, "The most target groups on each ALB, overriding -quotas and the AWS default of 100")
+	flag.IntVar(&listeners, "max-listeners", 0, "The most listeners on each ALB, overriding -quotas and the AWS default of 50")
+	flag.StringVar(&res.prices, "pricing", "", "A JSON file of load balancer rates in the same format as the bundled pricing.json, or \"api\" to read the current rates from the AWS Pricing API")
+	flag.StringVar(&usageWindow, "usage-window", "", "Read each ELB's traffic and healthy hosts over this window from CloudWatch, such as \"14d\", and recommend deleting the unused ones")
+	flag.StringVar(&res.regions, "regions", "", "Comma-separated list of AWS regions to scan, or \"all\" for every enabled region (defaults to the profile's region)")
 
 	flag.Usage = func() {
 		basename := filepath.Base(os.Args[0])
-		fmt.Printf("Usage: %s\n", basename)
+		fmt.Printf("Usage: %s [%s] [flags]\n", basename, snapshotCommand)
 		fmt.Printf("A utility to examine ELB usage in an AWS account and recommend ways of consolidating ELBs into ALBs and NLBs")
 		flag.PrintDefaults()
 	}
 
-	flag.Parse()
+	cmdArgs := os.Args[1:]
+	if len(cmdArgs) > 0 && cmdArgs[0] == snapshotCommand {
+		res.command = snapshotCommand
+		cmdArgs = cmdArgs[1:]
+	}
+
+	// flag.Parse would stop at the subcommand, so we parse what follows it instead
+	_ = flag.CommandLine.Parse(cmdArgs)
 
 	if help {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	return res
-}
+	if (res.accounts != "" || res.org) && res.roleName == "" {
+		fmt.Println("-role-name is required when using -accounts or -org")
+		flag.Usage()
+		os.Exit(1)
+	}
 
-func panicOnAwsError(err error) {
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			fmt.Println(err.Error())
+	if res.output != outputText && res.output != outputJSON {
+		fmt.Printf("Unknown -output %q\n", res.output)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if res.sgPolicy != sgPolicyEquivalent && res.sgPolicy != sgPolicySubset {
+		fmt.Printf("Unknown -sg-policy %q\n", res.sgPolicy)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if res.solver != solverGreedy && res.solver != solverOptimal {
+		fmt.Printf("Unknown -solver %q\n", res.solver)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if res.solver == solverOptimal && res.sgPolicy == sgPolicySubset {
+		fmt.Println("-solver optimal can't be used with -sg-policy subset")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	res.quotas = defaultALBQuotas()
+	if quotasFile != "" {
+		if err := loadALBQuotas(quotasFile, res.quotas); err != nil {
+			fmt.Println(err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if rules != 0 {
+		res.quotas.Rules = rules
+	}
+	if certificates != 0 {
+		res.quotas.Certificates = certificates
+	}
+	if targetGroups != 0 {
+		res.quotas.TargetGroups = targetGroups
+	}
+	if listeners != 0 {
+		res.quotas.Listeners = listeners
+	}
+	if err := res.quotas.validate(); err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	switch res.prices {
+	case "", pricingAPI:
+		var err error
+		if res.pricing, err = defaultPricing(); err != nil {
+			exitWithError(err)
+		}
+	default:
+		var err error
+		if res.pricing, err = loadPricing(res.prices); err != nil {
+			fmt.Println(err)
+			flag.Usage()
+			os.Exit(1)
 		}
-		panic("Oh noes!")
 	}
+
+	if usageWindow != "" {
+		var err error
+		if res.usage, err = parseUsageWindow(usageWindow); err != nil {
+			fmt.Println(err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+
+	if _, ok := emitters[res.emit]; res.emit != "" && !ok {
+		fmt.Printf("Unknown -emit %q\n", res.emit)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if res.command == snapshotCommand && res.input != "" {
+		fmt.Println("-input can't be used when taking a snapshot")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if res.input != "" && (res.profile != "" || res.accounts != "" || res.org || res.regions != "" || res.usage != 0) {
+		fmt.Println("-input can't be combined with -profile, -accounts, -org, -regions or -usage-window")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	return res
 }
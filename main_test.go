@@ -1,16 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
 	"github.com/stretchr/testify/assert"
 )
 
 type listenerDescription struct {
-	protocol string
-	port     int64
+	protocol         string
+	port             int64
+	instanceProtocol string
+	instancePort     int64
+	certificate      string
 }
 
 type elbBuilder struct {
@@ -18,17 +27,32 @@ type elbBuilder struct {
 	listenerDescriptions []*elb.ListenerDescription
 	subnets              []*string
 	securityGroups       []*string
+	vpcID                *string
+	scheme               *string
+	dnsName              *string
+	instances            []*elb.Instance
+	healthCheck          *elb.HealthCheck
 }
 
 func (b *elbBuilder) withListenerDescriptions(listenerDescriptions ...listenerDescription) *elbBuilder {
 	b.listenerDescriptions = make([]*elb.ListenerDescription, 0)
 
 	for i := range listenerDescriptions {
+		listener := &elb.Listener{
+			LoadBalancerPort: &listenerDescriptions[i].port,
+			Protocol:         &listenerDescriptions[i].protocol,
+		}
+		if listenerDescriptions[i].instanceProtocol != "" {
+			listener.InstanceProtocol = &listenerDescriptions[i].instanceProtocol
+		}
+		if listenerDescriptions[i].instancePort != 0 {
+			listener.InstancePort = &listenerDescriptions[i].instancePort
+		}
+		if listenerDescriptions[i].certificate != "" {
+			listener.SSLCertificateId = &listenerDescriptions[i].certificate
+		}
 		b.listenerDescriptions = append(b.listenerDescriptions, &elb.ListenerDescription{
-			Listener: &elb.Listener{
-				LoadBalancerPort: &listenerDescriptions[i].port,
-				Protocol:         &listenerDescriptions[i].protocol,
-			},
+			Listener: listener,
 		})
 	}
 
@@ -51,6 +75,41 @@ func (b *elbBuilder) withSubnets(subnets ...string) *elbBuilder {
 	return b
 }
 
+func (b *elbBuilder) withVPC(vpcID string) *elbBuilder {
+	b.vpcID = &vpcID
+	return b
+}
+
+func (b *elbBuilder) withScheme(scheme string) *elbBuilder {
+	b.scheme = &scheme
+	return b
+}
+
+func (b *elbBuilder) withDNSName(dnsName string) *elbBuilder {
+	b.dnsName = &dnsName
+	return b
+}
+
+func (b *elbBuilder) withInstances(instanceIDs ...string) *elbBuilder {
+	b.instances = make([]*elb.Instance, 0)
+	for i := range instanceIDs {
+		b.instances = append(b.instances, &elb.Instance{InstanceId: &instanceIDs[i]})
+	}
+	return b
+}
+
+// withHealthCheck checks the target every 30 seconds, timing out after 5, with thresholds of 2 and 10
+func (b *elbBuilder) withHealthCheck(target string) *elbBuilder {
+	b.healthCheck = &elb.HealthCheck{
+		Target:             &target,
+		Interval:           aws.Int64(30),
+		Timeout:            aws.Int64(5),
+		HealthyThreshold:   aws.Int64(2),
+		UnhealthyThreshold: aws.Int64(10),
+	}
+	return b
+}
+
 func (b *elbBuilder) build() *elb.LoadBalancerDescription {
 	if b.subnets == nil || len(b.subnets) == 0 {
 		panic("ELB must have at least one subnet")
@@ -65,6 +124,11 @@ func (b *elbBuilder) build() *elb.LoadBalancerDescription {
 		Subnets:              b.subnets,
 		ListenerDescriptions: b.listenerDescriptions,
 		SecurityGroups:       b.securityGroups,
+		VPCId:                b.vpcID,
+		Scheme:               b.scheme,
+		DNSName:              b.dnsName,
+		Instances:            b.instances,
+		HealthCheck:          b.healthCheck,
 	}
 }
 
@@ -74,6 +138,25 @@ func createELB(name string) *elbBuilder {
 	}
 }
 
+// elbInTier creates an ELB with the listeners in subnet a of vpc-1, behind sg-1, so that every ELB
+// made this way can share a load balancer
+func elbInTier(name string, listeners ...listenerDescription) *elbBuilder {
+	return createELB(name).
+		withSubnets("a").
+		withVPC("vpc-1").
+		withSecurityGroups("sg-1").
+		withListenerDescriptions(listeners...)
+}
+
+// tierInventory is an inventory of eu-west-1 with the ELBs, for tests to add the details they need
+func tierInventory(elbs ...*elb.LoadBalancerDescription) *inventory {
+	return &inventory{
+		region:         "eu-west-1",
+		elbs:           elbs,
+		securityGroups: make(map[string]*ec2.SecurityGroup),
+	}
+}
+
 func TestSameSubnetsAreInTheSamePartition(t *testing.T) {
 	// ELBs in the same subnet should be in the same partition because they're the same network tier.
 	elbs := []*elb.LoadBalancerDescription{
@@ -96,7 +179,8 @@ func TestSameSubnetsAreInTheSamePartition(t *testing.T) {
 	}
 
 	sgs := make(map[string]*ec2.SecurityGroup)
-	recommendations := generateRecommendations(elbs, sgs)
+	recommendations, err := generateRecommendations(elbs, sgs)
+	assert.NoError(t, err)
 
 	assert.Equal(t, 1, len(recommendations), "We have a single recommendation")
 
@@ -133,7 +217,8 @@ func TestIntersectingSubnetsAreInTheSamePartition(t *testing.T) {
 	}
 
 	sgs := make(map[string]*ec2.SecurityGroup)
-	recommendations := generateRecommendations(elbs, sgs)
+	recommendations, err := generateRecommendations(elbs, sgs)
+	assert.NoError(t, err)
 
 	assert.Equal(t, 1, len(recommendations), "We have a single recommendation: %#v", recommendations)
 
@@ -146,6 +231,155 @@ func TestIntersectingSubnetsAreInTheSamePartition(t *testing.T) {
 	assert.Equal(t, 2, len(lb.ELBs()), "There are 2 ELBs being replaced")
 }
 
+func TestAnELBWhichBridgesTwoTiersMergesThem(t *testing.T) {
+	elbs := []*elb.LoadBalancerDescription{
+		createELB("first").
+			withSubnets("a").
+			withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("second").
+			withSubnets("b").
+			withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("bridge").
+			withSubnets("a", "b").
+			withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).
+			withSecurityGroups("sg-1").
+			build(),
+	}
+
+	recommendations, err := generateRecommendations(elbs, make(map[string]*ec2.SecurityGroup))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, len(recommendations), "The tiers for a and b are merged")
+
+	answer := recommendations[0]
+	assert.Equal(t, []string{"a", "b"}, answer.Subnets())
+	assert.Equal(t, 1, len(answer.ALBs()), "ALBs")
+	assert.Equal(t, []string{"bridge", "first", "second"}, answer.ALBs()[0].ELBs())
+}
+
+func TestTiersDoNotDependOnTheOrderOfTheELBs(t *testing.T) {
+	// c and d are only joined by the last ELB, after each has its own load balancers
+	elbs := []*elb.LoadBalancerDescription{
+		createELB("first").withSubnets("a").withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).withSecurityGroups("sg-1").build(),
+		createELB("second").withSubnets("c").withListenerDescriptions(listenerDescription{port: 5432, protocol: "TCP"}).withSecurityGroups("sg-1").build(),
+		createELB("third").withSubnets("d").withListenerDescriptions(listenerDescription{port: 6379, protocol: "TCP"}).withSecurityGroups("sg-1").build(),
+		createELB("fourth").withSubnets("b", "a").withListenerDescriptions(listenerDescription{port: 80, protocol: "HTTP"}).withSecurityGroups("sg-1").build(),
+		createELB("fifth").withSubnets("d", "c").withListenerDescriptions(listenerDescription{port: 3306, protocol: "TCP"}).withSecurityGroups("sg-1").build(),
+	}
+
+	partitions := func(elbs []*elb.LoadBalancerDescription) map[string][]string {
+		res := make(map[string][]string)
+		recommendations, _ := generateRecommendations(elbs, make(map[string]*ec2.SecurityGroup))
+		for _, r := range recommendations {
+			names := make([]string, 0)
+			for _, lbs := range [][]*LB{r.ALBs(), r.NLBs(), r.ELBs()} {
+				for _, lb := range lbs {
+					names = append(names, lb.ELBs()...)
+				}
+			}
+			sort.Strings(names)
+			res[strings.Join(r.Subnets(), ",")] = names
+		}
+		return res
+	}
+
+	expected := map[string][]string{
+		"a,b": {"first", "fourth"},
+		"c,d": {"fifth", "second", "third"},
+	}
+	assert.Equal(t, expected, partitions(elbs))
+
+	reversed := make([]*elb.LoadBalancerDescription, len(elbs))
+	for i := range elbs {
+		reversed[len(elbs)-1-i] = elbs[i]
+	}
+	assert.Equal(t, expected, partitions(reversed))
+
+	// The merged tier has a single NLB, rather than one left over from each of the original tiers
+	recommendations, err := generateRecommendations(elbs, make(map[string]*ec2.SecurityGroup))
+	assert.NoError(t, err)
+	for _, r := range recommendations {
+		if strings.Join(r.Subnets(), ",") == "c,d" {
+			assert.Equal(t, 1, len(r.NLBs()), "NLBs")
+		}
+	}
+}
+
+// randomInventory builds an inventory with enough ELBs, subnets and overlapping security groups to
+// exercise every part of the consolidation
+func randomInventory(seed int64) *inventory {
+	rnd := rand.New(rand.NewSource(seed))
+
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": securityGroup("sg-1", tcpFrom(443, 443, "10.0.0.0/8")),
+		"sg-2": securityGroup("sg-2", tcpFrom(443, 443, "10.0.0.0/9", "10.128.0.0/9")),
+		"sg-3": securityGroup("sg-3", tcpFrom(443, 443, "10.0.0.0/16")),
+		"sg-4": securityGroup("sg-4", tcpFrom(443, 443, "192.168.0.0/16")),
+		"sg-5": securityGroup("sg-5", tcpFrom(0, 65535, "0.0.0.0/0")),
+	}
+	groups := []string{"sg-1", "sg-2", "sg-3", "sg-4", "sg-5"}
+	subnets := []string{"a", "b", "c", "d", "e", "f"}
+	listeners := []listenerDescription{
+		{port: 80, protocol: "HTTP"},
+		{port: 443, protocol: "HTTPS"},
+		{port: 8080, protocol: "HTTP"},
+		{port: 5432, protocol: "TCP"},
+		{port: 6379, protocol: "TCP"},
+	}
+
+	elbs := make([]*elb.LoadBalancerDescription, 0)
+	for i := 0; i < 40; i++ {
+		builder := createELB(fmt.Sprintf("elb-%02d", i)).
+			withSubnets(subnets[rnd.Intn(len(subnets))], subnets[rnd.Intn(len(subnets))]).
+			withScheme([]string{"internal", "internet-facing"}[rnd.Intn(2)]).
+			withListenerDescriptions(listeners[rnd.Intn(len(listeners))], listeners[rnd.Intn(len(listeners))]).
+			withSecurityGroups(groups[rnd.Intn(len(groups))], groups[rnd.Intn(len(groups))])
+		elbs = append(elbs, builder.build())
+	}
+
+	return &inventory{region: "eu-west-1", elbs: elbs, securityGroups: sgs}
+}
+
+func TestRecommendationsDoNotDependOnTheOrderOfTheInput(t *testing.T) {
+	for _, options := range []*analysisOptions{
+		{sgPolicy: sgPolicyEquivalent, solver: solverGreedy},
+		{sgPolicy: sgPolicySubset, solver: solverGreedy},
+		{sgPolicy: sgPolicyEquivalent, solver: solverOptimal},
+	} {
+		render := func(inv *inventory) string {
+			var buf bytes.Buffer
+			assert.NoError(t, writeJSONReports(&buf, generateReports([]*inventory{inv}, options)))
+			return buf.String()
+		}
+
+		for seed := int64(0); seed < 10; seed++ {
+			expected := render(randomInventory(seed))
+
+			for i := 0; i < 10; i++ {
+				shuffled := randomInventory(seed)
+				rnd := rand.New(rand.NewSource(int64(i)))
+				rnd.Shuffle(len(shuffled.elbs), func(a, b int) {
+					shuffled.elbs[a], shuffled.elbs[b] = shuffled.elbs[b], shuffled.elbs[a]
+				})
+				for _, lb := range shuffled.elbs {
+					rnd.Shuffle(len(lb.Subnets), func(a, b int) {
+						lb.Subnets[a], lb.Subnets[b] = lb.Subnets[b], lb.Subnets[a]
+					})
+					rnd.Shuffle(len(lb.SecurityGroups), func(a, b int) {
+						lb.SecurityGroups[a], lb.SecurityGroups[b] = lb.SecurityGroups[b], lb.SecurityGroups[a]
+					})
+				}
+
+				assert.Equal(t, expected, render(shuffled), "options %+v, seed %d, shuffle %d", *options, seed, i)
+			}
+		}
+	}
+}
+
 func TestDistinctSubnetsAreInDifferentPartitions(t *testing.T) {
 	// An ELB in the subnets [a, ... , z] and an ELB in the subnets [A, ... , Z] should be in
 	// different partitions
@@ -167,7 +401,8 @@ func TestDistinctSubnetsAreInDifferentPartitions(t *testing.T) {
 	}
 
 	sgs := make(map[string]*ec2.SecurityGroup)
-	recommendations := generateRecommendations(elbs, sgs)
+	recommendations, err := generateRecommendations(elbs, sgs)
+	assert.NoError(t, err)
 
 	assert.Equal(t, 2, len(recommendations), "We have 2 recommendations")
 
@@ -188,6 +423,126 @@ func TestDistinctSubnetsAreInDifferentPartitions(t *testing.T) {
 	assert.Equal(t, 1, len(lb.ELBs()), "There is 1 ELB being replaced")
 }
 
+func TestTiersArePartitionedByVPC(t *testing.T) {
+	// Subnet IDs are unique, but we shouldn't rely on that to keep load balancers in their own VPC
+	elbs := []*elb.LoadBalancerDescription{
+		createELB("first").
+			withSubnets("a").
+			withVPC("vpc-1").
+			withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("second").
+			withSubnets("a").
+			withVPC("vpc-2").
+			withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("third").
+			withSubnets("a").
+			withVPC("vpc-1").
+			withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).
+			withSecurityGroups("sg-1").
+			build(),
+	}
+
+	recommendations, err := generateRecommendations(elbs, make(map[string]*ec2.SecurityGroup))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, len(recommendations), "We have a recommendation for each VPC")
+
+	answer := recommendations[0]
+	assert.Equal(t, "vpc-1", answer.VPC())
+	assert.Equal(t, []string{"a"}, answer.Subnets())
+	assert.Equal(t, 1, len(answer.ALBs()), "ALBs")
+	assert.Equal(t, []string{"first", "third"}, answer.ALBs()[0].ELBs())
+
+	answer = recommendations[1]
+	assert.Equal(t, "vpc-2", answer.VPC())
+	assert.Equal(t, []string{"a"}, answer.Subnets())
+	assert.Equal(t, 1, len(answer.ALBs()), "ALBs")
+	assert.Equal(t, []string{"second"}, answer.ALBs()[0].ELBs())
+}
+
+func TestInternalAndInternetFacingELBsAreNeverCombined(t *testing.T) {
+	elbs := []*elb.LoadBalancerDescription{
+		createELB("public").
+			withSubnets("a", "b").
+			withScheme("internet-facing").
+			withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("private").
+			withSubnets("a", "b").
+			withScheme("internal").
+			withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("also-public").
+			withSubnets("b").
+			withScheme("internet-facing").
+			withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).
+			withSecurityGroups("sg-1").
+			build(),
+	}
+
+	recommendations, err := generateRecommendations(elbs, make(map[string]*ec2.SecurityGroup))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, len(recommendations), "We have a recommendation for each scheme")
+
+	answer := recommendations[0]
+	assert.Equal(t, "internal", answer.Scheme())
+	assert.Equal(t, []string{"a", "b"}, answer.Subnets())
+	assert.Equal(t, 1, len(answer.ALBs()), "ALBs")
+	assert.Equal(t, []string{"private"}, answer.ALBs()[0].ELBs())
+	assert.Equal(t, "internal", answer.ALBs()[0].Scheme())
+
+	answer = recommendations[1]
+	assert.Equal(t, "internet-facing", answer.Scheme())
+	assert.Equal(t, []string{"a", "b"}, answer.Subnets())
+	assert.Equal(t, 1, len(answer.ALBs()), "ALBs")
+	assert.Equal(t, []string{"also-public", "public"}, answer.ALBs()[0].ELBs())
+	assert.Equal(t, "internet-facing", answer.ALBs()[0].Scheme())
+}
+
+func TestMixedSchemesAreSeparatedForEveryTypeOfLoadBalancer(t *testing.T) {
+	elbs := make([]*elb.LoadBalancerDescription, 0)
+	for _, scheme := range []string{"internal", "internet-facing"} {
+		elbs = append(elbs,
+			createELB(scheme+"-tcp").
+				withSubnets("a").
+				withScheme(scheme).
+				withListenerDescriptions(listenerDescription{port: 5432, protocol: "TCP"}).
+				withSecurityGroups("sg-1").
+				build(),
+			createELB(scheme+"-mixed").
+				withSubnets("a").
+				withScheme(scheme).
+				withListenerDescriptions(
+					listenerDescription{port: 443, protocol: "HTTPS"},
+					listenerDescription{port: 6379, protocol: "TCP"},
+				).
+				withSecurityGroups("sg-1").
+				build(),
+		)
+	}
+
+	recommendations, err := generateRecommendations(elbs, make(map[string]*ec2.SecurityGroup))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, len(recommendations))
+	for _, answer := range recommendations {
+		assert.Equal(t, 1, len(answer.NLBs()), "NLBs")
+		assert.Equal(t, []string{answer.Scheme() + "-tcp"}, answer.NLBs()[0].ELBs())
+		assert.Equal(t, answer.Scheme(), answer.NLBs()[0].Scheme())
+
+		assert.Equal(t, 1, len(answer.ELBs()), "ELBs")
+		assert.Equal(t, []string{answer.Scheme() + "-mixed"}, answer.ELBs()[0].ELBs())
+		assert.Equal(t, answer.Scheme(), answer.ELBs()[0].Scheme())
+	}
+}
+
 func TestTheSameSecurityGroupIsEquivalent(t *testing.T) {
 	elbs := []*elb.LoadBalancerDescription{
 		createELB("first").
@@ -209,7 +564,8 @@ func TestTheSameSecurityGroupIsEquivalent(t *testing.T) {
 	}
 
 	sgs := make(map[string]*ec2.SecurityGroup)
-	recommendations := generateRecommendations(elbs, sgs)
+	recommendations, err := generateRecommendations(elbs, sgs)
+	assert.NoError(t, err)
 
 	assert.Equal(t, 1, len(recommendations))
 
@@ -273,7 +629,8 @@ func TestDifferentSecurityGroupsWithDistinctCidrsAreSeparate(t *testing.T) {
 		},
 	}
 
-	recommendations := generateRecommendations(elbs, sgs)
+	recommendations, err := generateRecommendations(elbs, sgs)
+	assert.NoError(t, err)
 
 	assert.Equal(t, 1, len(recommendations))
 
@@ -301,10 +658,10 @@ func int64Ptr(i int64) *int64 {
 	return &i
 }
 
-func TestSecurityGroupsWithTheSameSrcCIDRsAreEquivalent(t *testing.T) {
-	// Allowing port 443 and port 80 from the same src seem like the same security group. Also
-	// allowing port 22 from that src again seems like the same security group. So the key should
-	// be a hash of the canonical source CIDRs
+func TestSecurityGroupsWithTheSameSrcCIDRsOnDifferentPortsAreDistinct(t *testing.T) {
+	// Allowing port 443 and port 80 from the same src are different security groups. Treating them
+	// as the same would expose the backends behind the first ELB on a port that the second ELB's
+	// clients weren't allowed to use.
 
 	elbs := []*elb.LoadBalancerDescription{
 		createELB("first").
@@ -354,19 +711,28 @@ func TestSecurityGroupsWithTheSameSrcCIDRsAreEquivalent(t *testing.T) {
 		},
 	}
 
-	recommendations := generateRecommendations(elbs, sgs)
+	recommendations, err := generateRecommendations(elbs, sgs)
+	assert.NoError(t, err)
 
 	assert.Equal(t, 1, len(recommendations))
 
 	answer := recommendations[0]
 	assert.Equal(t, 1, len(answer.Subnets()), "Subnets")
-	assert.Equal(t, 1, len(answer.ALBs()), "ALBs")
+	assert.Equal(t, 2, len(answer.ALBs()), "ALBs")
 	assert.Equal(t, 0, len(answer.NLBs()), "NLBs")
 
 	lb := answer.ALBs()[0]
-	assert.Equal(t, 2, len(lb.ELBs()), "ELBs")
-	assert.Equal(t, []string{"80", "443"}, lb.Ports())
-	assert.Equal(t, []string{"sg-1", "sg-2"}, lb.SecurityGroups())
+	assert.Equal(t, 1, len(lb.ELBs()), "ELBs")
+	assert.Equal(t, []string{"80"}, lb.Ports())
+	assert.Equal(t, []string{"sg-1"}, lb.SecurityGroups())
+
+	lb = answer.ALBs()[1]
+	assert.Equal(t, 1, len(lb.ELBs()), "ELBs")
+	assert.Equal(t, []string{"443"}, lb.Ports())
+	assert.Equal(t, []string{"sg-2"}, lb.SecurityGroups())
+	assert.Equal(t, []string{
+		"second: sg-2 has different ingress to sg-1: only sg-1 allows tcp/443 from 10.0.0.0/8; only sg-2 allows tcp/80 from 10.0.0.0/8",
+	}, lb.Rationale())
 }
 
 func TestOverlappingSecurityGroupsAreCoalesced(t *testing.T) {
@@ -450,7 +816,8 @@ func TestOverlappingSecurityGroupsAreCoalesced(t *testing.T) {
 		},
 	}
 
-	recommendations := generateRecommendations(elbs, sgs)
+	recommendations, err := generateRecommendations(elbs, sgs)
+	assert.NoError(t, err)
 
 	assert.Equal(t, 1, len(recommendations))
 
@@ -463,6 +830,7 @@ func TestOverlappingSecurityGroupsAreCoalesced(t *testing.T) {
 	assert.Equal(t, 2, len(lb.ELBs()), "ELBs")
 	assert.Equal(t, []string{"443"}, lb.Ports())
 	assert.Equal(t, []string{"sg-1", "sg-2", "sg-3"}, lb.SecurityGroups())
+	assert.Equal(t, []string{"second: sg-3 has the same ingress as sg-1 (1 rule(s))"}, lb.Rationale())
 }
 
 func TestSubsetOfSrcIngressToUniquePortIsMeaningfulDistinction(t *testing.T) {
@@ -514,10 +882,113 @@ func TestSubsetOfSrcIngressToUniquePortIsMeaningfulDistinction(t *testing.T) {
 		},
 	}
 
-	tiers := newTiers(sgs)
+	tiers := newTiers(sgs, nil)
 	assert.False(t, tiers.hasSameIngress("sg-1", "sg-2"))
 }
 
+func subsetFixture() ([]*elb.LoadBalancerDescription, map[string]*ec2.SecurityGroup) {
+	elbs := []*elb.LoadBalancerDescription{
+		createELB("wide").
+			withSubnets("a").
+			withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).
+			withSecurityGroups("sg-wide").
+			build(),
+		createELB("narrow").
+			withSubnets("a").
+			withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).
+			withSecurityGroups("sg-narrow").
+			build(),
+	}
+
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-wide":   securityGroup("sg-wide", tcpFrom(443, 443, "10.0.0.0/8")),
+		"sg-narrow": securityGroup("sg-narrow", tcpFrom(443, 443, "10.0.0.0/9")),
+	}
+
+	return elbs, sgs
+}
+
+func TestSubsetsAreDistinctByDefault(t *testing.T) {
+	elbs, sgs := subsetFixture()
+
+	recommendations, err := generateRecommendations(elbs, sgs)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, len(recommendations[0].ALBs()), "ALBs")
+}
+
+func TestSubsetPolicyLetsANarrowerELBJoinAndReportsTheWidening(t *testing.T) {
+	elbs, sgs := subsetFixture()
+
+	recommendations, _ := analyse(&inventory{elbs: elbs, securityGroups: sgs}, &analysisOptions{sgPolicy: sgPolicySubset})
+
+	answer := recommendations[0]
+	assert.Equal(t, 1, len(answer.ALBs()), "ALBs")
+
+	lb := answer.ALBs()[0]
+	assert.Equal(t, []string{"wide", "narrow"}, lb.ELBs())
+	assert.Equal(t, []string{"sg-narrow", "sg-wide"}, lb.SecurityGroups())
+	assert.Equal(t, []string{"narrow: sg-wide allows everything that sg-narrow does, and more"}, lb.Rationale())
+	assert.Equal(t, []widening{
+		{elb: "narrow", clients: []ingressRule{{portRange: portRange{protocol: "tcp", fromPort: 443, toPort: 443}, source: "10.128.0.0/9"}}},
+	}, lb.Widenings())
+}
+
+func TestSubsetPolicyLetsAnELBJoinAGroupWhichAllowsAllTraffic(t *testing.T) {
+	elbs, sgs := subsetFixture()
+	sgs["sg-wide"] = securityGroup("sg-wide", &ec2.IpPermission{
+		IpProtocol: sPtr("-1"),
+		IpRanges:   []*ec2.IpRange{{CidrIp: sPtr("10.0.0.0/8")}},
+	})
+
+	recommendations, _ := analyse(&inventory{elbs: elbs, securityGroups: sgs}, &analysisOptions{sgPolicy: sgPolicySubset})
+
+	lb := recommendations[0].ALBs()[0]
+	assert.Equal(t, []string{"wide", "narrow"}, lb.ELBs())
+	assert.Equal(t, []widening{
+		{elb: "narrow", clients: []ingressRule{{portRange: portRange{protocol: allProtocols}, source: "10.0.0.0/8"}}},
+	}, lb.Widenings())
+}
+
+func TestSubsetPolicyConsidersWiderELBsFirst(t *testing.T) {
+	elbs, sgs := subsetFixture()
+	elbs[0], elbs[1] = elbs[1], elbs[0]
+
+	recommendations, _ := analyse(&inventory{elbs: elbs, securityGroups: sgs}, &analysisOptions{sgPolicy: sgPolicySubset})
+
+	answer := recommendations[0]
+	assert.Equal(t, 1, len(answer.ALBs()), "ALBs")
+	assert.Equal(t, []string{"wide", "narrow"}, answer.ALBs()[0].ELBs())
+}
+
+func TestSubsetPolicyDoesNotCombineELBsWhenNeitherIsASubset(t *testing.T) {
+	elbs, sgs := subsetFixture()
+	sgs["sg-narrow"] = securityGroup("sg-narrow", tcpFrom(443, 443, "10.0.0.0/9", "192.168.0.0/16"))
+
+	recommendations, _ := analyse(&inventory{elbs: elbs, securityGroups: sgs}, &analysisOptions{sgPolicy: sgPolicySubset})
+
+	answer := recommendations[0]
+	assert.Equal(t, 2, len(answer.ALBs()), "ALBs")
+	assert.Equal(t, []string{"narrow"}, answer.ALBs()[0].ELBs())
+	assert.Equal(t, []string{"wide"}, answer.ALBs()[1].ELBs())
+	assert.Equal(t, []string{
+		"wide: sg-wide has different ingress to sg-narrow: only sg-narrow allows tcp/443 from 192.168.0.0/16; only sg-wide allows tcp/443 from 10.128.0.0/9",
+	}, answer.ALBs()[1].Rationale())
+	assert.Equal(t, []widening{}, answer.ALBs()[0].Widenings())
+}
+
+func TestSubsetPolicyComparesAllOfTheELBsSecurityGroups(t *testing.T) {
+	elbs, sgs := subsetFixture()
+	sgs["sg-other"] = securityGroup("sg-other", tcpFrom(443, 443, "192.168.0.0/16"))
+	elbs[1].SecurityGroups = []*string{sPtr("sg-narrow"), sPtr("sg-other")}
+
+	recommendations, _ := analyse(&inventory{elbs: elbs, securityGroups: sgs}, &analysisOptions{sgPolicy: sgPolicySubset})
+
+	// sg-narrow alone would be a subset of sg-wide, but sg-other also allows 192.168.0.0/16
+	answer := recommendations[0]
+	assert.Equal(t, 2, len(answer.ALBs()), "ALBs")
+}
+
 func Test2ELBsWithPortCollisionBecome2NLBs(t *testing.T) {
 	elbs := []*elb.LoadBalancerDescription{
 		createELB("first").
@@ -567,7 +1038,8 @@ func Test2ELBsWithPortCollisionBecome2NLBs(t *testing.T) {
 		},
 	}
 
-	recommendations := generateRecommendations(elbs, sgs)
+	recommendations, err := generateRecommendations(elbs, sgs)
+	assert.NoError(t, err)
 
 	assert.Equal(t, 1, len(recommendations), "Both ELBs are in the same subnet, so a single tier")
 
@@ -637,7 +1109,8 @@ func TestELBDoingDifferentProtocolsIsRetained(t *testing.T) {
 		},
 	}
 
-	recommendations := generateRecommendations(elbs, sgs)
+	recommendations, err := generateRecommendations(elbs, sgs)
+	assert.NoError(t, err)
 
 	assert.Equal(t, 1, len(recommendations))
 
@@ -718,7 +1191,8 @@ func TestELBsWithMultipleProtocolsButWithoutPortCollisionsAreConsolidated(t *tes
 		},
 	}
 
-	recommendations := generateRecommendations(elbs, sgs)
+	recommendations, err := generateRecommendations(elbs, sgs)
+	assert.NoError(t, err)
 
 	assert.Equal(t, 1, len(recommendations))
 
@@ -801,7 +1275,8 @@ func TestELBsWithCollidingPortsCannotBeConsolidated(t *testing.T) {
 		},
 	}
 
-	recommendations := generateRecommendations(elbs, sgs)
+	recommendations, err := generateRecommendations(elbs, sgs)
+	assert.NoError(t, err)
 
 	assert.Equal(t, 1, len(recommendations))
 
@@ -831,7 +1306,9 @@ func TestTCPOverPort80IsTreatedAsHTTP(t *testing.T) {
 			listenerDescription{port: 80, protocol: "TCP"},
 		).
 		build()
-	assert.Equal(t, ALB, inspectListeners(lb))
+	targetLB, err := inspectListeners(lb)
+	assert.NoError(t, err)
+	assert.Equal(t, ALB, targetLB)
 }
 
 func TestTCPOverPort443IsTreatedAsHTTPS(t *testing.T) {
@@ -841,7 +1318,9 @@ func TestTCPOverPort443IsTreatedAsHTTPS(t *testing.T) {
 			listenerDescription{port: 443, protocol: "TCP"},
 		).
 		build()
-	assert.Equal(t, ALB, inspectListeners(lb))
+	targetLB, err := inspectListeners(lb)
+	assert.NoError(t, err)
+	assert.Equal(t, ALB, targetLB)
 }
 
 func TestTCPOverPort8080IsTreatedAsTCP(t *testing.T) {
@@ -851,5 +1330,107 @@ func TestTCPOverPort8080IsTreatedAsTCP(t *testing.T) {
 			listenerDescription{port: 8080, protocol: "TCP"},
 		).
 		build()
-	assert.Equal(t, NLB, inspectListeners(lb))
+	targetLB, err := inspectListeners(lb)
+	assert.NoError(t, err)
+	assert.Equal(t, NLB, targetLB)
+}
+
+func TestSSLIsTreatedAsTLSOnAnNLB(t *testing.T) {
+	lb := createELB("first").
+		withSubnets("a").
+		withListenerDescriptions(
+			listenerDescription{port: 443, protocol: "SSL", certificate: "arn:cert/first"},
+		).
+		build()
+	targetLB, err := inspectListeners(lb)
+	assert.NoError(t, err)
+	assert.Equal(t, NLB, targetLB)
+}
+
+func TestSSLAndHTTPTogetherAreRetained(t *testing.T) {
+	lb := createELB("first").
+		withSubnets("a").
+		withListenerDescriptions(
+			listenerDescription{port: 80, protocol: "HTTP"},
+			listenerDescription{port: 443, protocol: "SSL", certificate: "arn:cert/first"},
+		).
+		build()
+	targetLB, err := inspectListeners(lb)
+	assert.NoError(t, err)
+	assert.Equal(t, ELB, targetLB)
+}
+
+func TestTheNLBTerminatesTLSWithTheCertificatesOfSSLListeners(t *testing.T) {
+	elbs := []*elb.LoadBalancerDescription{
+		createELB("ldap").
+			withSubnets("a").
+			withListenerDescriptions(
+				listenerDescription{port: 636, protocol: "SSL", instanceProtocol: "TCP", instancePort: 389, certificate: "arn:cert/ldap"},
+			).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("mq").
+			withSubnets("a").
+			withListenerDescriptions(
+				listenerDescription{port: 5671, protocol: "SSL", instanceProtocol: "SSL", instancePort: 5671},
+				listenerDescription{port: 5672, protocol: "TCP", instanceProtocol: "TCP", instancePort: 5672},
+			).
+			withSecurityGroups("sg-1").
+			build(),
+	}
+
+	recommendations, err := generateRecommendations(elbs, make(map[string]*ec2.SecurityGroup))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(recommendations))
+
+	answer := recommendations[0]
+	assert.Equal(t, 0, len(answer.ALBs()), "ALBs")
+	assert.Equal(t, 1, len(answer.NLBs()), "NLBs")
+
+	nlb := answer.NLBs()[0]
+	assert.Equal(t, []string{"ldap", "mq"}, nlb.ELBs())
+	assert.Equal(t, []tlsListener{
+		{port: 636, certificate: "arn:cert/ldap"},
+		{port: 5671},
+	}, nlb.TLSListeners())
+}
+
+// backendsFixture is two ELBs which share port 443 but reach different backends, where one of them
+// also reaches its instances on two ports
+func backendsFixture() []*elb.LoadBalancerDescription {
+	return []*elb.LoadBalancerDescription{
+		elbInTier("api",
+			listenerDescription{port: 443, protocol: "HTTPS", instanceProtocol: "HTTP", instancePort: 9000},
+		).build(),
+		elbInTier("web",
+			listenerDescription{port: 80, protocol: "HTTP", instanceProtocol: "HTTP", instancePort: 8080},
+			listenerDescription{port: 443, protocol: "HTTPS", instanceProtocol: "HTTP", instancePort: 8080},
+			listenerDescription{port: 8443, protocol: "HTTPS", instanceProtocol: "HTTPS", instancePort: 8443},
+		).build(),
+	}
+}
+
+func TestEachBackendOfAnELBHasItsOwnTargetGroup(t *testing.T) {
+	recommendations, err := generateRecommendations(backendsFixture(), make(map[string]*ec2.SecurityGroup))
+	assert.NoError(t, err)
+
+	alb := recommendations[0].ALBs()[0]
+	assert.Equal(t, []targetGroup{
+		{name: "api", elb: "api", protocol: "HTTP", port: 9000, ports: []int{443}},
+		{name: "web-http-8080", elb: "web", protocol: "HTTP", port: 8080, ports: []int{80, 443}},
+		{name: "web-https-8443", elb: "web", protocol: "HTTPS", port: 8443, ports: []int{8443}},
+	}, alb.TargetGroups())
+
+	plan := planLB(alb, "application", []string{"alb"}, []string{"a"})
+	assert.Equal(t, 3, len(plan.targetGroups))
+
+	// Both ELBs share port 443, so it routes to each of their target groups by host
+	assert.Equal(t, 443, plan.listeners[1].port)
+	assert.Equal(t, []string{"alb", "api"}, plan.listeners[1].rules[0].target.name)
+	assert.Equal(t, []string{"alb", "web-http-8080"}, plan.listeners[1].rules[1].target.name)
+
+	assert.Equal(t, 8443, plan.listeners[2].port)
+	assert.Equal(t, []string{"alb", "web-https-8443"}, plan.listeners[2].defaultTarget.name)
+	assert.Equal(t, "HTTPS", plan.listeners[2].defaultTarget.protocol)
+	assert.Equal(t, int64(8443), plan.listeners[2].defaultTarget.port)
 }
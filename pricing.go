@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+)
+
+// pricingAPI is the value of the -pricing flag which reads the current rates from the AWS Pricing API
+const pricingAPI = "api"
+
+// hoursPerMonth is the average number of hours in a month, which AWS uses in its own estimates
+const hoursPerMonth = 730
+
+// The LCU dimensions that we can measure for an ALB. Each LCU-hour covers up to 1 GB processed per
+// hour, or 25 new connections per second, whichever is used more.
+const (
+	gbPerCapacityUnit          = 1
+	connectionsPerCapacityUnit = 25
+)
+
+// bundledPricing is the on-demand rates that were current when the file was last updated. -pricing
+// replaces it with another file, or with the Pricing API.
+//
+//go:embed pricing.json
+var bundledPricing []byte
+
+// lbPricing is the rates for each type of load balancer, keyed by region
+type lbPricing struct {
+	Regions map[string]*regionRates `json:"regions"`
+}
+
+// regionRates are the rates for each type of load balancer in a region
+type regionRates struct {
+	ALB *lbRates `json:"alb"`
+	NLB *lbRates `json:"nlb"`
+	ELB *lbRates `json:"elb"`
+}
+
+// lbRates are the USD rates for a type of load balancer
+type lbRates struct {
+	Hourly          float64 `json:"hourly"`                    // for each hour that the load balancer runs
+	CapacityUnit    float64 `json:"capacityUnit,omitempty"`    // for each LCU-hour of an ALB, or NLCU-hour of an NLB
+	DataProcessedGB float64 `json:"dataProcessedGB,omitempty"` // for each GB that an ELB processes
+}
+
+// defaultPricing returns the rates which are bundled with the tool
+func defaultPricing() (*lbPricing, error) {
+	res, err := readPricing(bytes.NewReader(bundledPricing))
+	if err != nil {
+		return nil, &dataError{fmt.Errorf("the bundled pricing is invalid: %w", err)}
+	}
+	return res, nil
+}
+
+// readPricing reads the rates from a JSON document in the same format as pricing.json
+func readPricing(r io.Reader) (*lbPricing, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	res := &lbPricing{}
+	if err := decoder.Decode(res); err != nil {
+		return nil, fmt.Errorf("unable to read pricing: %w", err)
+	}
+
+	return res, res.validate()
+}
+
+// loadPricing reads the rates from the named file
+func loadPricing(filename string) (*lbPricing, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readPricing(f)
+}
+
+// validate checks that every region has an hourly rate for each type of load balancer
+func (p *lbPricing) validate() error {
+	regions := make([]string, 0, len(p.Regions))
+	for region := range p.Regions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	for _, region := range regions {
+		rates := p.Regions[region]
+		if rates == nil || !rates.ALB.valid() || !rates.NLB.valid() || !rates.ELB.valid() {
+			return fmt.Errorf("the pricing for %s must have positive hourly rates for the alb, nlb and elb", region)
+		}
+	}
+	return nil
+}
+
+func (r *lbRates) valid() bool {
+	return r != nil && r.Hourly > 0 && r.CapacityUnit >= 0 && r.DataProcessedGB >= 0
+}
+
+// ratesFor returns the rates for the region, or nil if we don't know them
+func (p *lbPricing) ratesFor(region string) *regionRates {
+	if p == nil {
+		return nil
+	}
+	return p.Regions[region]
+}
+
+// fetchPricing reads the rates for each of the regions from the Pricing API. A region which the API
+// doesn't have every rate for keeps its rates from the fallback, if any.
+func fetchPricing(pricingSvc pricingiface.PricingAPI, regions []string, fallback *lbPricing) (*lbPricing, error) {
+	res := &lbPricing{Regions: make(map[string]*regionRates)}
+
+	for _, region := range regions {
+		rates := &regionRates{ALB: &lbRates{}, NLB: &lbRates{}, ELB: &lbRates{}}
+
+		input := &pricing.GetProductsInput{
+			ServiceCode: aws.String("AWSELB"),
+			Filters: []*pricing.Filter{
+				{Field: aws.String("regionCode"), Type: aws.String(pricing.FilterTypeTermMatch), Value: aws.String(region)},
+			},
+		}
+		err := pricingSvc.GetProductsPages(input, func(page *pricing.GetProductsOutput, lastPage bool) bool {
+			for _, item := range page.PriceList {
+				addProductRate(rates, item)
+			}
+			return !lastPage
+		})
+		if err != nil {
+			return nil, newAWSError("GetProducts", err)
+		}
+
+		if rates.ALB.valid() && rates.NLB.valid() && rates.ELB.valid() && rates.ELB.DataProcessedGB > 0 {
+			res.Regions[region] = rates
+		} else if known := fallback.ratesFor(region); known != nil {
+			res.Regions[region] = known
+		}
+	}
+
+	return res, nil
+}
+
+// addProductRate records the rate of a product from the Pricing API, if it's one that we use. The
+// product family tells the types of load balancer apart, and the usage type says what is charged for.
+func addProductRate(rates *regionRates, item aws.JSONValue) {
+	attributes := jsonObject(jsonObject(item["product"])["attributes"])
+	family, _ := attributes["productFamily"].(string)
+	usageType, _ := attributes["usagetype"].(string)
+
+	var lb *lbRates
+	switch family {
+	case "Load Balancer-Application":
+		lb = rates.ALB
+	case "Load Balancer-Network":
+		lb = rates.NLB
+	case "Load Balancer":
+		lb = rates.ELB
+	default:
+		return
+	}
+
+	price, ok := onDemandPrice(item)
+	if !ok {
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(usageType, "LoadBalancerUsage"):
+		lb.Hourly = price
+	case strings.HasSuffix(usageType, "LCUUsage"):
+		lb.CapacityUnit = price
+	case strings.HasSuffix(usageType, "DataProcessing-Bytes"):
+		lb.DataProcessedGB = price
+	}
+}
+
+// onDemandPrice finds the USD price of the on-demand term of a product. Load balancers only have one.
+func onDemandPrice(item aws.JSONValue) (float64, bool) {
+	for _, term := range jsonObject(jsonObject(item["terms"])["OnDemand"]) {
+		for _, dimension := range jsonObject(jsonObject(term)["priceDimensions"]) {
+			usd, _ := jsonObject(jsonObject(dimension)["pricePerUnit"])["USD"].(string)
+			if price, err := strconv.ParseFloat(usd, 64); err == nil {
+				return price, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// jsonObject returns the value as a JSON object, or an empty one if it isn't an object
+func jsonObject(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case aws.JSONValue:
+		return v
+	case map[string]interface{}:
+		return v
+	}
+	return map[string]interface{}{}
+}
+
+// costEstimate is the estimated monthly cost in USD of some ELBs today, and of what we recommend
+// instead
+type costEstimate struct {
+	current   float64
+	projected float64
+	traffic   bool // whether the traffic of the ELBs is included, rather than only the hourly charges
+}
+
+func (c *costEstimate) add(other *costEstimate) {
+	c.current += other.current
+	c.projected += other.projected
+	c.traffic = c.traffic && other.traffic
+}
+
+// monthly is the cost of running one load balancer of this type for a month, with the capacity units
+// and data that it processes each hour
+func (r *lbRates) monthly(capacityUnits, gb float64) float64 {
+	return (r.Hourly + capacityUnits*r.CapacityUnit + gb*r.DataProcessedGB) * hoursPerMonth
+}
+
+// estimateLB is the cost of the ELBs that the LB replaces today, and of the LB itself. The LB
+// processes all of their traffic.
+func (r *regionRates) estimateLB(lb *LB, lbType string, usage map[string]*elbUsage) *costEstimate {
+	res := &costEstimate{traffic: usage != nil}
+
+	var gb, requests float64
+	for _, name := range lb.ELBs() {
+		elbGB, elbRequests := usage[name].hourlyTraffic()
+		gb += elbGB
+		requests += elbRequests
+		res.current += r.ELB.monthly(0, elbGB)
+	}
+
+	switch lbType {
+	case "ALB":
+		// Treating each request as a new connection overestimates, but keeps the estimate cautious
+		connections := requests / 3600
+		lcus := gb / gbPerCapacityUnit
+		if connections/connectionsPerCapacityUnit > lcus {
+			lcus = connections / connectionsPerCapacityUnit
+		}
+		res.projected = r.ALB.monthly(lcus, 0)
+	case "NLB":
+		res.projected = r.NLB.monthly(gb/gbPerCapacityUnit, 0)
+	default:
+		res.projected = r.ELB.monthly(0, gb)
+	}
+
+	return res
+}
+
+// estimateCost works out the cost of each recommended load balancer and of the whole recommendation.
+// Deleting an ELB saves everything that it costs today.
+func (r *recommendation) estimateCost(rates *regionRates, usage map[string]*elbUsage) {
+	r.cost = &costEstimate{traffic: usage != nil}
+
+	for _, group := range []struct {
+		lbs    []*LB
+		lbType string
+	}{
+		{r.ALBs(), "ALB"},
+		{r.NLBs(), "NLB"},
+		{r.ELBs(), "ELB"},
+	} {
+		for _, lb := range group.lbs {
+			lb.cost = rates.estimateLB(lb, group.lbType, usage)
+			r.cost.add(lb.cost)
+		}
+	}
+
+	for _, d := range r.deletions {
+		gb, _ := usage[d.elb].hourlyTraffic()
+		r.cost.current += rates.ELB.monthly(0, gb)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePricing is a stand-in for the Pricing API which returns a fixed price list for each region
+type fakePricing struct {
+	pricingiface.PricingAPI
+	products map[string][]aws.JSONValue // keyed by regionCode
+}
+
+func (f *fakePricing) GetProductsPages(input *pricing.GetProductsInput, fn func(*pricing.GetProductsOutput, bool) bool) error {
+	fn(&pricing.GetProductsOutput{PriceList: f.products[*input.Filters[0].Value]}, true)
+	return nil
+}
+
+// product is a price list item in the shape that the Pricing API returns
+func product(family, usageType, usd string) aws.JSONValue {
+	return aws.JSONValue{
+		"product": map[string]interface{}{
+			"attributes": map[string]interface{}{"productFamily": family, "usagetype": usageType},
+		},
+		"terms": map[string]interface{}{
+			"OnDemand": map[string]interface{}{
+				"SKU.TERM": map[string]interface{}{
+					"priceDimensions": map[string]interface{}{
+						"SKU.TERM.DIMENSION": map[string]interface{}{
+							"pricePerUnit": map[string]interface{}{"USD": usd},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// mustDefaultPricing returns the bundled rates, failing the test if they can't be read
+func mustDefaultPricing(t *testing.T) *lbPricing {
+	p, err := defaultPricing()
+	assert.NoError(t, err)
+	return p
+}
+
+func TestTheBundledPricingIsValid(t *testing.T) {
+	p := mustDefaultPricing(t)
+
+	assert.Equal(t, &regionRates{
+		ALB: &lbRates{Hourly: 0.0252, CapacityUnit: 0.008},
+		NLB: &lbRates{Hourly: 0.0252, CapacityUnit: 0.006},
+		ELB: &lbRates{Hourly: 0.028, DataProcessedGB: 0.008},
+	}, p.ratesFor("eu-west-1"))
+	assert.Nil(t, p.ratesFor("xx-nowhere-1"))
+}
+
+func TestPricingFilesAreValidated(t *testing.T) {
+	_, err := readPricing(strings.NewReader(`{"regions": {"eu-west-1": {"alb": {"hourly": 0.1}, "nlb": {"hourly": 0.1}}}}`))
+	assert.EqualError(t, err, "the pricing for eu-west-1 must have positive hourly rates for the alb, nlb and elb")
+
+	_, err = readPricing(strings.NewReader(`{"regions": {}, "currency": "GBP"}`))
+	assert.Error(t, err)
+}
+
+func TestRatesAreReadFromThePricingAPI(t *testing.T) {
+	svc := &fakePricing{products: map[string][]aws.JSONValue{
+		"eu-west-1": {
+			product("Load Balancer-Application", "EU-LoadBalancerUsage", "0.0300000000"),
+			product("Load Balancer-Application", "EU-LCUUsage", "0.0100000000"),
+			product("Load Balancer-Network", "EU-LoadBalancerUsage", "0.0300000000"),
+			product("Load Balancer-Network", "EU-LCUUsage", "0.0070000000"),
+			product("Load Balancer", "EU-LoadBalancerUsage", "0.0350000000"),
+			product("Load Balancer", "EU-DataProcessing-Bytes", "0.0090000000"),
+			product("Load Balancer-Gateway", "EU-LoadBalancerUsage", "0.0125000000"),
+		},
+	}}
+
+	p, err := fetchPricing(svc, []string{"eu-west-1", "us-east-1", "xx-nowhere-1"}, mustDefaultPricing(t))
+	assert.NoError(t, err)
+
+	assert.Equal(t, &regionRates{
+		ALB: &lbRates{Hourly: 0.03, CapacityUnit: 0.01},
+		NLB: &lbRates{Hourly: 0.03, CapacityUnit: 0.007},
+		ELB: &lbRates{Hourly: 0.035, DataProcessedGB: 0.009},
+	}, p.ratesFor("eu-west-1"))
+	assert.Equal(t, mustDefaultPricing(t).ratesFor("us-east-1"), p.ratesFor("us-east-1"), "Regions without prices fall back")
+	assert.Nil(t, p.ratesFor("xx-nowhere-1"))
+}
+
+// pricedFixture is two HTTP ELBs which could share an ALB
+func pricedFixture() *inventory {
+	http := listenerDescription{port: 80, protocol: "HTTP"}
+	return tierInventory(
+		elbInTier("api", http).withInstances("i-api").build(),
+		elbInTier("web", http).withInstances("i-web").build(),
+	)
+}
+
+func TestHourlyChargesAreEstimatedWithoutUsage(t *testing.T) {
+	recommendations, _ := analyse(pricedFixture(), &analysisOptions{sgPolicy: sgPolicyEquivalent, pricing: mustDefaultPricing(t)})
+
+	expected := &costEstimate{current: 2 * 0.028 * hoursPerMonth, projected: 0.0252 * hoursPerMonth}
+	assert.InDelta(t, expected.current, recommendations[0].Cost().current, 1e-9)
+	assert.InDelta(t, expected.projected, recommendations[0].Cost().projected, 1e-9)
+	assert.False(t, recommendations[0].Cost().traffic)
+	assert.Equal(t, recommendations[0].Cost(), recommendations[0].ALBs()[0].Cost())
+
+	tally := tallyRecommendations(recommendations)
+	assert.InDelta(t, 55, tally.saving(), 0.1)
+}
+
+func TestTrafficIsIncludedInTheEstimate(t *testing.T) {
+	inv := pricedFixture()
+	// 1 GB an hour each, and enough requests from web for 3 LCUs
+	inv.usage = map[string]*elbUsage{
+		"api": {Window: "10d", ProcessedBytes: 240e9, HealthyHosts: 1},
+		"web": {Window: "10d", ProcessedBytes: 240e9, Requests: 240 * 3 * connectionsPerCapacityUnit * 3600, HealthyHosts: 1},
+	}
+
+	recommendations, _ := analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent, pricing: mustDefaultPricing(t)})
+	cost := recommendations[0].Cost()
+
+	assert.InDelta(t, 2*(0.028+0.008)*hoursPerMonth, cost.current, 1e-9)
+	assert.InDelta(t, (0.0252+3*0.008)*hoursPerMonth, cost.projected, 1e-9)
+	assert.True(t, cost.traffic)
+}
+
+func TestDeletedELBsSaveWhatTheyCost(t *testing.T) {
+	inv := pricedFixture()
+	inv.usage = map[string]*elbUsage{
+		"api": {Window: "10d", HealthyHosts: 1},
+		"web": {Window: "10d", ProcessedBytes: 240e9, HealthyHosts: 1},
+	}
+
+	recommendations, _ := analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent, pricing: mustDefaultPricing(t)})
+
+	cost := recommendations[0].Cost()
+	assert.InDelta(t, (0.028+(0.028+0.008))*hoursPerMonth, cost.current, 1e-9)
+	assert.InDelta(t, (0.0252+0.008)*hoursPerMonth, cost.projected, 1e-9)
+}
+
+func TestTheSavingNeedsPricesForEveryRegion(t *testing.T) {
+	recommendations, _ := analyse(pricedFixture(), &analysisOptions{sgPolicy: sgPolicyEquivalent, pricing: mustDefaultPricing(t)})
+	priced := tallyRecommendations(recommendations)
+
+	unknown := pricedFixture()
+	unknown.region = "xx-nowhere-1"
+	recommendations, _ = analyse(unknown, &analysisOptions{sgPolicy: sgPolicyEquivalent, pricing: mustDefaultPricing(t)})
+	unpriced := tallyRecommendations(recommendations)
+	assert.Nil(t, unpriced.costs())
+
+	total := &tally{}
+	total.add(priced)
+	total.add(unpriced)
+	assert.Nil(t, total.costs())
+	assert.Equal(t, saving(4, 2, 0, 0), total.saving())
+}
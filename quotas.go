@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/service/elb"
+)
+
+// The names of the ALB quotas, as they appear in a quotas file
+const (
+	quotaRules        = "rulesPerListener"
+	quotaCertificates = "certificatesPerListener"
+	quotaTargetGroups = "targetGroupsPerLoadBalancer"
+	quotaListeners    = "listenersPerLoadBalancer"
+)
+
+// quotaDescriptions say what each quota limits, for the reports
+var quotaDescriptions = map[string]string{
+	quotaRules:        "rules per listener, besides the default rule",
+	quotaCertificates: "certificates per listener, besides the default certificate",
+	quotaTargetGroups: "target groups per load balancer",
+	quotaListeners:    "listeners per load balancer",
+}
+
+// albQuotas are the AWS limits on a single ALB which packing many ELBs onto it can run into. Each
+// backend of a replaced ELB needs its own target group, each port needs a listener, and each ELB
+// sharing a port needs a host-based rule on its listener and brings its certificate with it. Like
+// AWS, a listener's default rule and default certificate aren't counted. The certificates quota
+// doesn't split ALBs, but is flagged by planCertificates.
+type albQuotas struct {
+	Rules        int `json:"rulesPerListener"`
+	Certificates int `json:"certificatesPerListener"`
+	TargetGroups int `json:"targetGroupsPerLoadBalancer"`
+	Listeners    int `json:"listenersPerLoadBalancer"`
+}
+
+// defaultALBQuotas returns the default AWS quotas
+func defaultALBQuotas() *albQuotas {
+	return &albQuotas{
+		Rules:        100,
+		Certificates: 25,
+		TargetGroups: 100,
+		Listeners:    50,
+	}
+}
+
+// quotaSplit records that an ALB was started because the ELB didn't fit on an existing one without
+// going over a quota
+type quotaSplit struct {
+	quota string // the name of the quota
+	limit int    // the value of the quota
+}
+
+func (s *quotaSplit) String() string {
+	return fmt.Sprintf("%d %s", s.limit, quotaDescriptions[s.quota])
+}
+
+// readALBQuotas overrides the quotas with any that are given in the JSON document
+func readALBQuotas(r io.Reader, quotas *albQuotas) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(quotas); err != nil {
+		return fmt.Errorf("unable to read quotas: %w", err)
+	}
+
+	return quotas.validate()
+}
+
+// loadALBQuotas overrides the quotas with any that are given in the named file
+func loadALBQuotas(filename string, quotas *albQuotas) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return readALBQuotas(f, quotas)
+}
+
+// validate checks that every quota allows at least one of what it limits
+func (q *albQuotas) validate() error {
+	for _, quota := range []struct {
+		name  string
+		value int
+	}{
+		{quotaRules, q.Rules},
+		{quotaCertificates, q.Certificates},
+		{quotaTargetGroups, q.TargetGroups},
+		{quotaListeners, q.Listeners},
+	} {
+		if quota.value < 1 {
+			return fmt.Errorf("the %s quota must be at least 1, not %d", quota.name, quota.value)
+		}
+	}
+	return nil
+}
+
+// exceededBy returns the first quota that the ALB would go over if it also replaced the ELB, or nil
+// if the ELB fits
+func (q *albQuotas) exceededBy(lb *LB, candidate *elb.LoadBalancerDescription) *quotaSplit {
+	descriptions := append(append([]*elb.LoadBalancerDescription{}, lb.descriptions...), candidate)
+
+	targetGroups := 0
+	for _, description := range descriptions {
+		targetGroups += len(backendTargetGroups(description))
+	}
+	if targetGroups > q.TargetGroups {
+		return &quotaSplit{quota: quotaTargetGroups, limit: q.TargetGroups}
+	}
+
+	elbsByPort := make(map[int64]int)
+	for _, description := range descriptions {
+		for _, ld := range description.ListenerDescriptions {
+			elbsByPort[*ld.Listener.LoadBalancerPort]++
+		}
+	}
+
+	if len(elbsByPort) > q.Listeners {
+		return &quotaSplit{quota: quotaListeners, limit: q.Listeners}
+	}
+
+	// A port that only one ELB listens on forwards to it by default, without any rules
+	for _, count := range elbsByPort {
+		if count > 1 && count > q.Rules {
+			return &quotaSplit{quota: quotaRules, limit: q.Rules}
+		}
+	}
+
+	return nil
+}
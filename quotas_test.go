@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotasFileOverridesTheDefaults(t *testing.T) {
+	quotas := defaultALBQuotas()
+	assert.NoError(t, readALBQuotas(strings.NewReader(`{"rulesPerListener": 10}`), quotas))
+
+	assert.Equal(t, &albQuotas{Rules: 10, Certificates: 25, TargetGroups: 100, Listeners: 50}, quotas)
+}
+
+func TestQuotasFileIsValidated(t *testing.T) {
+	assert.Error(t, readALBQuotas(strings.NewReader(`{"rulesPerListner": 10}`), defaultALBQuotas()))
+	assert.Error(t, readALBQuotas(strings.NewReader(`{"targetGroupsPerLoadBalancer": 0}`), defaultALBQuotas()))
+	assert.Error(t, readALBQuotas(strings.NewReader(`not json`), defaultALBQuotas()))
+}
+
+func TestQuotaExceededByEachLimit(t *testing.T) {
+	first := createELB("first").
+		withSubnets("a").
+		withListenerDescriptions(
+			listenerDescription{port: 80, protocol: "HTTP"},
+			listenerDescription{port: 443, protocol: "HTTPS", certificate: "cert-1"},
+		).
+		build()
+	second := createELB("second").
+		withSubnets("a").
+		withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS", certificate: "cert-2"}).
+		build()
+	third := createELB("third").
+		withSubnets("a").
+		withListenerDescriptions(listenerDescription{port: 8080, protocol: "HTTP"}).
+		build()
+
+	lb := newLB(first)
+
+	assert.Nil(t, defaultALBQuotas().exceededBy(lb, second))
+
+	assert.Equal(t, &quotaSplit{quota: quotaTargetGroups, limit: 1},
+		(&albQuotas{Rules: 100, Certificates: 25, TargetGroups: 1, Listeners: 50}).exceededBy(lb, third))
+
+	// An ELB which reaches its instances on two ports needs a target group for each
+	split := createELB("split").
+		withSubnets("a").
+		withListenerDescriptions(
+			listenerDescription{port: 80, protocol: "HTTP", instanceProtocol: "HTTP", instancePort: 8080},
+			listenerDescription{port: 443, protocol: "HTTPS", instanceProtocol: "HTTP", instancePort: 9000},
+		).
+		build()
+	assert.Equal(t, &quotaSplit{quota: quotaTargetGroups, limit: 2},
+		(&albQuotas{Rules: 100, Certificates: 25, TargetGroups: 2, Listeners: 50}).exceededBy(lb, split))
+
+	// A port that the ALB doesn't listen on yet needs another listener
+	assert.Equal(t, &quotaSplit{quota: quotaListeners, limit: 2},
+		(&albQuotas{Rules: 100, Certificates: 25, TargetGroups: 100, Listeners: 2}).exceededBy(lb, third))
+	assert.Nil(t, (&albQuotas{Rules: 100, Certificates: 25, TargetGroups: 100, Listeners: 2}).exceededBy(lb, second))
+
+	// Two ELBs on port 443 need a rule each
+	quotas := &albQuotas{Rules: 1, Certificates: 25, TargetGroups: 100, Listeners: 50}
+	assert.Equal(t, &quotaSplit{quota: quotaRules, limit: 1}, quotas.exceededBy(lb, second))
+	assert.Nil(t, quotas.exceededBy(lb, third))
+
+	// Certificates don't split an ALB, however many there are
+	quotas = &albQuotas{Rules: 100, Certificates: 1, TargetGroups: 100, Listeners: 50}
+	lb.replaceELB(second)
+	fourth := createELB("fourth").
+		withSubnets("a").
+		withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS", certificate: "cert-3"}).
+		build()
+	assert.Nil(t, quotas.exceededBy(lb, fourth))
+}
+
+func TestRulesAreCountedPerListener(t *testing.T) {
+	first := createELB("first").
+		withSubnets("a").
+		withListenerDescriptions(
+			listenerDescription{port: 80, protocol: "HTTP"},
+			listenerDescription{port: 443, protocol: "HTTPS"},
+		).
+		build()
+	second := createELB("second").
+		withSubnets("a").
+		withListenerDescriptions(
+			listenerDescription{port: 80, protocol: "HTTP"},
+			listenerDescription{port: 443, protocol: "HTTPS"},
+		).
+		build()
+
+	lb := newLB(first)
+
+	// The ALB needs four rules, but each listener only needs two
+	assert.Nil(t, (&albQuotas{Rules: 2, Certificates: 25, TargetGroups: 100, Listeners: 50}).exceededBy(lb, second))
+	assert.Equal(t, &quotaSplit{quota: quotaRules, limit: 1},
+		(&albQuotas{Rules: 1, Certificates: 25, TargetGroups: 100, Listeners: 50}).exceededBy(lb, second))
+}
+
+// manyALBs are ELBs which would all share a single ALB without any quotas
+func manyALBs(n int) []*elb.LoadBalancerDescription {
+	res := make([]*elb.LoadBalancerDescription, 0, n)
+	for i := 0; i < n; i++ {
+		res = append(res, createELB(fmt.Sprintf("elb-%02d", i)).
+			withSubnets("a").
+			withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).
+			withSecurityGroups("sg-1").
+			build())
+	}
+	return res
+}
+
+func TestALBsAreSplitToStayWithinQuotas(t *testing.T) {
+	quotas := &albQuotas{Rules: 100, Certificates: 25, TargetGroups: 4, Listeners: 50}
+
+	for _, solver := range []string{solverGreedy, solverOptimal} {
+		inv := &inventory{elbs: manyALBs(10), securityGroups: make(map[string]*ec2.SecurityGroup)}
+		recommendations, _ := analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent, solver: solver, quotas: quotas})
+
+		albs := recommendations[0].ALBs()
+		assert.Equal(t, 3, len(albs), solver)
+		assert.Equal(t, []string{"elb-00", "elb-01", "elb-02", "elb-03"}, albs[0].ELBs(), solver)
+		assert.Nil(t, albs[0].Split(), solver)
+		assert.Equal(t, []string{"elb-04", "elb-05", "elb-06", "elb-07"}, albs[1].ELBs(), solver)
+		assert.Equal(t, &quotaSplit{quota: quotaTargetGroups, limit: 4}, albs[1].Split(), solver)
+		assert.Equal(t, []string{"elb-08", "elb-09"}, albs[2].ELBs(), solver)
+	}
+}
+
+func TestDefaultQuotasAllowLargeALBs(t *testing.T) {
+	recommendations, err := generateRecommendations(manyALBs(100), make(map[string]*ec2.SecurityGroup))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(recommendations[0].ALBs()))
+
+	recommendations, err = generateRecommendations(manyALBs(101), make(map[string]*ec2.SecurityGroup))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(recommendations[0].ALBs()))
+	assert.Equal(t, "100 target groups per load balancer", recommendations[0].ALBs()[1].Split().String())
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+)
+
+// dnsRecords are the Route 53 alias and CNAME records in an account, keyed by the normalised DNS name
+// that they point at. Route 53 is global, so they're read once for all of the regions.
+type dnsRecords map[string][]dnsRecord
+
+// dnsRecord is a Route 53 record which might point at an ELB
+type dnsRecord struct {
+	hostname string // the name of the record
+	zoneID   string // the hosted zone of the alias target, or empty for a CNAME
+}
+
+// collectHostnames reads the Route 53 names of the ELBs in the inventories of an account. If we
+// aren't allowed to read Route 53, the hostnames are left unknown, with a warning, so that the
+// generated rules ask for them and no ELB is flagged as probably unused.
+func collectHostnames(inventories []*inventory, r53Svc route53iface.Route53API) error {
+	records, err := collectDNSRecords(r53Svc)
+	if isAccessDenied(err) {
+		for _, inv := range inventories {
+			inv.hostnames = nil
+			inv.warnings = append(inv.warnings, fmt.Sprintf("unable to read Route 53, so the hostnames of the ELBs are unknown: %v", err))
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, inv := range inventories {
+		inv.hostnames = records.hostnamesFor(inv.elbs)
+	}
+	return nil
+}
+
+// collectDNSRecords reads the alias and CNAME records of every hosted zone in the account
+func collectDNSRecords(r53Svc route53iface.Route53API) (dnsRecords, error) {
+	res := make(dnsRecords)
+
+	zones := make([]*route53.HostedZone, 0)
+	err := r53Svc.ListHostedZonesPages(&route53.ListHostedZonesInput{}, func(page *route53.ListHostedZonesOutput, lastPage bool) bool {
+		zones = append(zones, page.HostedZones...)
+		return !lastPage
+	})
+	if err != nil {
+		return nil, newAWSError("ListHostedZones", err)
+	}
+
+	for _, zone := range zones {
+		input := &route53.ListResourceRecordSetsInput{HostedZoneId: zone.Id}
+		err := r53Svc.ListResourceRecordSetsPages(input, func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+			for _, record := range page.ResourceRecordSets {
+				hostname := recordName(aws.StringValue(record.Name))
+
+				if record.AliasTarget != nil {
+					target := normaliseDNSName(aws.StringValue(record.AliasTarget.DNSName))
+					zoneID := aws.StringValue(record.AliasTarget.HostedZoneId)
+					res[target] = append(res[target], dnsRecord{hostname: hostname, zoneID: zoneID})
+					continue
+				}
+
+				if aws.StringValue(record.Type) == route53.RRTypeCname {
+					for _, value := range record.ResourceRecords {
+						target := normaliseDNSName(aws.StringValue(value.Value))
+						res[target] = append(res[target], dnsRecord{hostname: hostname})
+					}
+				}
+			}
+			return !lastPage
+		})
+		if err != nil {
+			return nil, newAWSError("ListResourceRecordSets", err)
+		}
+	}
+
+	return res, nil
+}
+
+// hostnamesFor returns the sorted hostnames which point at each ELB, keyed by LoadBalancerName.
+// ELBs without any aren't included. An alias only matches if it's for the ELB's hosted zone, as well
+// as its DNS name.
+func (records dnsRecords) hostnamesFor(elbs []*elb.LoadBalancerDescription) map[string][]string {
+	res := make(map[string][]string)
+
+	for _, lb := range elbs {
+		zoneID := aws.StringValue(lb.CanonicalHostedZoneNameID)
+
+		seen := make(map[string]struct{})
+		hostnames := make([]string, 0)
+		for _, record := range records[normaliseDNSName(aws.StringValue(lb.DNSName))] {
+			if record.zoneID != "" && zoneID != "" && record.zoneID != zoneID {
+				continue
+			}
+			if _, ok := seen[record.hostname]; !ok {
+				seen[record.hostname] = struct{}{}
+				hostnames = append(hostnames, record.hostname)
+			}
+		}
+
+		if len(hostnames) > 0 {
+			sort.Strings(hostnames)
+			res[*lb.LoadBalancerName] = hostnames
+		}
+	}
+
+	return res
+}
+
+// normaliseDNSName lets us compare the DNS name of an ELB with an alias target. Route 53 writes
+// names in lower case with a trailing dot, and aliases for IPv6 clients point at the "dualstack."
+// name of the ELB.
+func normaliseDNSName(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	return strings.TrimPrefix(name, "dualstack.")
+}
+
+// recordName turns the name of a record into a hostname which can be used in a host header rule.
+// Route 53 escapes the * of a wildcard record as \052.
+func recordName(name string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(name, "."), `\052`, "*")
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// fakeRoute53 is a stand-in for the Route 53 API which returns a fixed set of records for each zone
+type fakeRoute53 struct {
+	route53iface.Route53API
+	records map[string][]*route53.ResourceRecordSet // keyed by hosted zone ID
+	err     error                                   // returned when listing the zones, if set
+}
+
+func (f *fakeRoute53) ListHostedZonesPages(input *route53.ListHostedZonesInput, fn func(*route53.ListHostedZonesOutput, bool) bool) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	zones := make([]string, 0, len(f.records))
+	for id := range f.records {
+		zones = append(zones, id)
+	}
+
+	// Return each zone on its own page, to check that we read all of them
+	for i := range zones {
+		page := &route53.ListHostedZonesOutput{HostedZones: []*route53.HostedZone{{Id: &zones[i]}}}
+		if !fn(page, i == len(zones)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeRoute53) ListResourceRecordSetsPages(input *route53.ListResourceRecordSetsInput, fn func(*route53.ListResourceRecordSetsOutput, bool) bool) error {
+	fn(&route53.ListResourceRecordSetsOutput{ResourceRecordSets: f.records[*input.HostedZoneId]}, true)
+	return nil
+}
+
+func alias(name, target string) *route53.ResourceRecordSet {
+	return &route53.ResourceRecordSet{
+		Name:        sPtr(name),
+		Type:        sPtr(route53.RRTypeA),
+		AliasTarget: &route53.AliasTarget{DNSName: sPtr(target), HostedZoneId: sPtr("Z32O12XQLNTSW2")},
+	}
+}
+
+// mustCollectDNSRecords reads the records, failing the test if that fails
+func mustCollectDNSRecords(t *testing.T, r53Svc route53iface.Route53API) dnsRecords {
+	records, err := collectDNSRecords(r53Svc)
+	assert.NoError(t, err)
+	return records
+}
+
+func TestAliasRecordsAreMatchedToELBs(t *testing.T) {
+	r53 := &fakeRoute53{records: map[string][]*route53.ResourceRecordSet{
+		"/hostedzone/Z1": {
+			alias("www.example.com.", "web-123.eu-west-1.elb.amazonaws.com."),
+			alias("www.example.com.", "dualstack.web-123.eu-west-1.elb.amazonaws.com."),
+			alias(`\052.example.com.`, "Web-123.EU-West-1.elb.amazonaws.com."),
+			{Name: sPtr("example.com."), Type: sPtr("MX")},
+		},
+		"/hostedzone/Z2": {
+			alias("api.internal.", "internal-api-456.eu-west-1.elb.amazonaws.com."),
+		},
+	}}
+
+	elbs := []*elb.LoadBalancerDescription{
+		createELB("web").withSubnets("a").withDNSName("web-123.eu-west-1.elb.amazonaws.com").build(),
+		createELB("api").withSubnets("a").withDNSName("internal-api-456.eu-west-1.elb.amazonaws.com").build(),
+		createELB("unused").withSubnets("a").withDNSName("unused-789.eu-west-1.elb.amazonaws.com").build(),
+	}
+
+	assert.Equal(t, map[string][]string{
+		"web": {"*.example.com", "www.example.com"},
+		"api": {"api.internal"},
+	}, mustCollectDNSRecords(t, r53).hostnamesFor(elbs))
+}
+
+func cname(name, target string) *route53.ResourceRecordSet {
+	return &route53.ResourceRecordSet{
+		Name:            sPtr(name),
+		Type:            sPtr(route53.RRTypeCname),
+		ResourceRecords: []*route53.ResourceRecord{{Value: sPtr(target)}},
+	}
+}
+
+func TestCNAMEsAreMatchedToELBs(t *testing.T) {
+	r53 := &fakeRoute53{records: map[string][]*route53.ResourceRecordSet{
+		"/hostedzone/Z1": {
+			cname("legacy.example.com.", "web-123.eu-west-1.elb.amazonaws.com"),
+			alias("www.example.com.", "web-123.eu-west-1.elb.amazonaws.com."),
+		},
+	}}
+
+	web := createELB("web").withSubnets("a").withDNSName("web-123.eu-west-1.elb.amazonaws.com").build()
+	web.CanonicalHostedZoneNameID = sPtr("Z32O12XQLNTSW2")
+
+	assert.Equal(t, map[string][]string{
+		"web": {"legacy.example.com", "www.example.com"},
+	}, mustCollectDNSRecords(t, r53).hostnamesFor([]*elb.LoadBalancerDescription{web}))
+}
+
+func TestAliasesForAnotherHostedZoneAreIgnored(t *testing.T) {
+	r53 := &fakeRoute53{records: map[string][]*route53.ResourceRecordSet{
+		"/hostedzone/Z1": {alias("www.example.com.", "web-123.eu-west-1.elb.amazonaws.com.")},
+	}}
+
+	web := createELB("web").withSubnets("a").withDNSName("web-123.eu-west-1.elb.amazonaws.com").build()
+	web.CanonicalHostedZoneNameID = sPtr("ZHURV8PSTC4K8")
+
+	assert.Equal(t, map[string][]string{}, mustCollectDNSRecords(t, r53).hostnamesFor([]*elb.LoadBalancerDescription{web}))
+}
+
+func TestELBsWithoutDNSRecordsAreProbablyUnused(t *testing.T) {
+	recommendations, _ := analyse(routedFixture(), &analysisOptions{sgPolicy: sgPolicyEquivalent})
+
+	alb := recommendations[0].ALBs()[0]
+	assert.Equal(t, []string{"api"}, alb.ProbablyUnused())
+	assert.Equal(t, []string{"example.com", "www.example.com"}, alb.Hostnames("web"))
+	assert.Equal(t, []string{}, alb.Hostnames("api"))
+	assert.Equal(t, []string{
+		"api (no DNS records point at it, so it's probably unused)",
+		"web (example.com, www.example.com)",
+	}, describeELBs(alb))
+
+	// Without Route 53, we can't tell
+	inv := routedFixture()
+	inv.hostnames = nil
+	recommendations, _ = analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent})
+	assert.Equal(t, []string{}, recommendations[0].ALBs()[0].ProbablyUnused())
+}
+
+// routedFixture is two ELBs which share ports, so that an ALB replacing them needs host-based rules
+func routedFixture() *inventory {
+	return &inventory{
+		region: "eu-west-1",
+		elbs: []*elb.LoadBalancerDescription{
+			createELB("web").
+				withSubnets("a").
+				withVPC("vpc-1").
+				withDNSName("web-123.eu-west-1.elb.amazonaws.com").
+				withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS", certificate: "arn:cert/web"}).
+				withSecurityGroups("sg-1").
+				build(),
+			createELB("api").
+				withSubnets("a").
+				withVPC("vpc-1").
+				withDNSName("api-456.eu-west-1.elb.amazonaws.com").
+				withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS", certificate: "arn:cert/api"}).
+				withSecurityGroups("sg-1").
+				build(),
+		},
+		securityGroups: make(map[string]*ec2.SecurityGroup),
+		hostnames: map[string][]string{
+			"web": {"example.com", "www.example.com"},
+		},
+	}
+}
+
+func TestALBsHaveARoutingRuleForEachELB(t *testing.T) {
+	recommendations, _ := analyse(routedFixture(), &analysisOptions{sgPolicy: sgPolicyEquivalent})
+
+	assert.Equal(t, []routingRule{
+		{elb: "api", dnsName: "api-456.eu-west-1.elb.amazonaws.com", hostnames: []string{}, ports: []int{443}},
+		{elb: "web", dnsName: "web-123.eu-west-1.elb.amazonaws.com", hostnames: []string{"example.com", "www.example.com"}, ports: []int{443}},
+	}, recommendations[0].ALBs()[0].RoutingRules())
+}
+
+func TestKnownHostnamesAreUsedInTheGeneratedRules(t *testing.T) {
+	reports := generateReports([]*inventory{routedFixture()}, &analysisOptions{sgPolicy: sgPolicyEquivalent})
+	tiers := planReports(reports)
+
+	terraform := string(renderTerraform(tiers))
+	assert.Contains(t, terraform, `values = ["example.com", "www.example.com"]`)
+	assert.Contains(t, terraform, `values = var.eu_west_1_tier1_alb1_443_api_host_headers`)
+	assert.Contains(t, terraform, `variable "eu_west_1_tier1_alb1_443_api_host_headers"`)
+	assert.NotContains(t, terraform, `variable "eu_west_1_tier1_alb1_443_web_host_headers"`)
+
+	content, err := renderCloudFormation(tiers[0], "yaml")
+	assert.NoError(t, err)
+
+	var template cfnTemplate
+	assert.NoError(t, yaml.Unmarshal(content, &template))
+
+	conditions := template.Resources["Alb1Listener443RuleWeb"].Properties["Conditions"].([]any)
+	assert.Equal(t, map[string]any{"Values": []any{"example.com", "www.example.com"}},
+		conditions[0].(map[string]any)["HostHeaderConfig"])
+	assert.NotContains(t, template.Parameters, "Alb1Listener443RuleWebHostHeaders")
+	assert.Contains(t, template.Parameters, "Alb1Listener443RuleApiHostHeaders")
+}
+
+func TestHostnamesAreUnknownWhenRoute53CantBeRead(t *testing.T) {
+	inv := routedFixture()
+	r53 := &fakeRoute53{records: map[string][]*route53.ResourceRecordSet{
+		"/hostedzone/Z1": {alias("www.example.com.", "web-123.eu-west-1.elb.amazonaws.com.")},
+	}}
+	assert.NoError(t, collectHostnames([]*inventory{inv}, r53))
+	assert.Equal(t, map[string][]string{"web": {"www.example.com"}}, inv.hostnames)
+	assert.Equal(t, 0, len(inv.warnings))
+
+	r53.err = awserr.New("AccessDenied", "denied", nil)
+	assert.NoError(t, collectHostnames([]*inventory{inv}, r53))
+	assert.Nil(t, inv.hostnames)
+	assert.Equal(t, 1, len(inv.warnings))
+
+	// Every rule asks for its hostnames, and no ELB is flagged as probably unused
+	reports := generateReports([]*inventory{inv}, &analysisOptions{sgPolicy: sgPolicyEquivalent})
+	assert.Equal(t, []string{}, reports[0].recommendations[0].ALBs()[0].ProbablyUnused())
+	terraform := string(renderTerraform(planReports(reports)))
+	assert.Contains(t, terraform, `variable "eu_west_1_tier1_alb1_443_api_host_headers"`)
+	assert.Contains(t, terraform, `variable "eu_west_1_tier1_alb1_443_web_host_headers"`)
+
+	r53.err = awserr.New("Throttling", "slow down", nil)
+	assert.Equal(t, exitThrottled, exitCodeFor(collectHostnames([]*inventory{inv}, r53)))
+}
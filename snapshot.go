@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+)
+
+// snapshotVersion is the version of the snapshot file format that we write. It should be incremented
+// whenever a change is made which older versions of the tool would misread. Version 1 has the load
+// balancers, security groups, prefix lists, subnets and tags, and version 2 adds the hostnames, usage
+// and certificate names.
+const snapshotVersion = 2
+
+// snapshotVersionWithHostnames is the first version which has the hostnames, usage and certificate
+// names. Older snapshots didn't collect them, so they're unknown, even if an empty section is present.
+const snapshotVersionWithHostnames = 2
+
+// snapshot is a point-in-time capture of one or more regions of one or more AWS accounts. It lets us
+// generate recommendations without needing AWS credentials, which is handy when tuning the engine
+// or attaching real-world cases to bug reports.
+type snapshot struct {
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Regions   []*regionSnapshot `json:"regions"`
+}
+
+// regionSnapshot is the serialised form of an inventory. The AWS types are written as-is, so that a
+// snapshot contains everything that the AWS APIs told us.
+type regionSnapshot struct {
+	Account        string                         `json:"account,omitempty"`
+	Region         string                         `json:"region"`
+	LoadBalancers  []*elb.LoadBalancerDescription `json:"loadBalancers"`
+	SecurityGroups map[string]*ec2.SecurityGroup  `json:"securityGroups"`
+	PrefixLists    map[string][]string            `json:"prefixLists"`
+	Subnets        map[string]*ec2.Subnet         `json:"subnets"`
+	Tags           map[string][]*elb.Tag          `json:"tags"`
+	Hostnames      map[string][]string            `json:"hostnames"`
+	Usage          map[string]*elbUsage           `json:"usage,omitempty"`
+	Certificates   map[string][]string            `json:"certificates"`
+}
+
+// writeSnapshot serialises the inventories as versioned JSON
+func writeSnapshot(w io.Writer, inventories []*inventory) error {
+	res := &snapshot{
+		Version:   snapshotVersion,
+		CreatedAt: time.Now().UTC(),
+		Regions:   make([]*regionSnapshot, 0, len(inventories)),
+	}
+
+	for _, inv := range inventories {
+		res.Regions = append(res.Regions, &regionSnapshot{
+			Account:        inv.account,
+			Region:         inv.region,
+			LoadBalancers:  inv.elbs,
+			SecurityGroups: inv.securityGroups,
+			PrefixLists:    inv.prefixLists,
+			Subnets:        inv.subnets,
+			Tags:           inv.tags,
+			Hostnames:      inv.hostnames,
+			Usage:          inv.usage,
+			Certificates:   inv.certificates,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(res)
+}
+
+// readSnapshot deserialises inventories which were previously written by writeSnapshot
+func readSnapshot(r io.Reader) ([]*inventory, error) {
+	var snap snapshot
+
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, &dataError{fmt.Errorf("unable to read snapshot: %w", err)}
+	}
+
+	if snap.Version < 1 || snap.Version > snapshotVersion {
+		return nil, &dataError{fmt.Errorf("unsupported snapshot version %d, expected %d or older", snap.Version, snapshotVersion)}
+	}
+
+	res := make([]*inventory, 0, len(snap.Regions))
+
+	for _, region := range snap.Regions {
+		inv := &inventory{
+			account:        region.Account,
+			region:         region.Region,
+			elbs:           region.LoadBalancers,
+			securityGroups: region.SecurityGroups,
+			prefixLists:    region.PrefixLists,
+			subnets:        region.Subnets,
+			tags:           region.Tags,
+			hostnames:      region.Hostnames,
+			usage:          region.Usage,
+			certificates:   region.Certificates,
+		}
+
+		if snap.Version < snapshotVersionWithHostnames {
+			inv.hostnames, inv.usage, inv.certificates = nil, nil, nil
+		}
+
+		if inv.elbs == nil {
+			inv.elbs = make([]*elb.LoadBalancerDescription, 0)
+		}
+		if inv.securityGroups == nil {
+			inv.securityGroups = make(map[string]*ec2.SecurityGroup)
+		}
+		if inv.prefixLists == nil {
+			inv.prefixLists = make(map[string][]string)
+		}
+		if inv.subnets == nil {
+			inv.subnets = make(map[string]*ec2.Subnet)
+		}
+		if inv.tags == nil {
+			inv.tags = make(map[string][]*elb.Tag)
+		}
+
+		res = append(res, inv)
+	}
+
+	return res, nil
+}
+
+// saveSnapshot writes the inventories to the named file
+func saveSnapshot(filename string, inventories []*inventory) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := writeSnapshot(f, inventories); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// loadSnapshot reads inventories from the named file
+func loadSnapshot(filename string) ([]*inventory, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readSnapshot(f)
+}
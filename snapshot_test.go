@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotsRoundTrip(t *testing.T) {
+	original := &inventory{
+		account: "111111111111",
+		region:  "eu-west-1",
+		elbs: []*elb.LoadBalancerDescription{
+			createELB("first").
+				withSubnets("a").
+				withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS", certificate: "arn:cert/payments"}).
+				withSecurityGroups("sg-1").
+				build(),
+			createELB("second").
+				withSubnets("a").
+				withListenerDescriptions(listenerDescription{port: 443, protocol: "HTTPS"}).
+				withSecurityGroups("sg-2").
+				build(),
+		},
+		securityGroups: map[string]*ec2.SecurityGroup{
+			"sg-1": {
+				GroupId: sPtr("sg-1"),
+				IpPermissions: []*ec2.IpPermission{
+					{
+						FromPort:   int64Ptr(443),
+						IpProtocol: sPtr("tcp"),
+						IpRanges:   []*ec2.IpRange{{CidrIp: sPtr("10.0.0.0/8")}},
+					},
+				},
+			},
+			"sg-2": {
+				GroupId: sPtr("sg-2"),
+				IpPermissions: []*ec2.IpPermission{
+					{
+						FromPort:   int64Ptr(443),
+						IpProtocol: sPtr("tcp"),
+						IpRanges:   []*ec2.IpRange{{CidrIp: sPtr("192.168.0.1/32")}},
+					},
+				},
+			},
+		},
+		prefixLists: map[string][]string{
+			"pl-1": {"10.0.0.0/8", "172.16.0.0/12"},
+		},
+		subnets: map[string]*ec2.Subnet{
+			"a": {SubnetId: sPtr("a"), VpcId: sPtr("vpc-1")},
+		},
+		tags: map[string][]*elb.Tag{
+			"first": {{Key: sPtr("team"), Value: sPtr("payments")}},
+		},
+		hostnames: map[string][]string{
+			"first": {"payments.example.com"},
+		},
+		usage: map[string]*elbUsage{
+			"first": {Window: "14d", Requests: 1200, ProcessedBytes: 65536, HealthyHosts: 2},
+		},
+		certificates: map[string][]string{
+			"arn:cert/payments": {"*.example.com", "example.com"},
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeSnapshot(&buf, []*inventory{original}))
+
+	inventories, err := readSnapshot(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(inventories))
+
+	restored := inventories[0]
+	assert.Equal(t, original, restored)
+	assert.Equal(t,
+		generateReports([]*inventory{original}, &analysisOptions{sgPolicy: sgPolicyEquivalent}),
+		generateReports([]*inventory{restored}, &analysisOptions{sgPolicy: sgPolicyEquivalent}),
+		"A snapshot produces the same recommendations as the live account")
+}
+
+func TestSnapshotsWithAnUnknownVersionAreRejected(t *testing.T) {
+	_, err := readSnapshot(strings.NewReader(`{"version": 999, "regions": []}`))
+	assert.Error(t, err)
+}
+
+func TestSnapshotsWithMissingSectionsAreUsable(t *testing.T) {
+	inventories, err := readSnapshot(strings.NewReader(`{"version": 2, "regions": [{"region": "eu-west-2"}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(inventories))
+	assert.Equal(t, "eu-west-2", inventories[0].region)
+	assert.Equal(t, 0, len(generateReports(inventories, &analysisOptions{sgPolicy: sgPolicyEquivalent})[0].recommendations))
+}
+
+func TestOlderSnapshotsDoNotKnowTheHostnames(t *testing.T) {
+	content := `{"regions": [{"region": "eu-west-1", "hostnames": {}, "usage": {}, "certificates": {}}]}`
+
+	inventories, err := readSnapshot(strings.NewReader(`{"version": 1, ` + content[1:]))
+	assert.NoError(t, err)
+	assert.Nil(t, inventories[0].hostnames, "Version 1 snapshots were taken before hostnames were collected")
+	assert.Nil(t, inventories[0].usage)
+	assert.Nil(t, inventories[0].certificates)
+
+	inventories, err = readSnapshot(strings.NewReader(`{"version": 2, ` + content[1:]))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{}, inventories[0].hostnames, "An empty section means that nothing was found")
+	assert.Equal(t, map[string][]string{}, inventories[0].certificates)
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/elb"
+)
+
+// The ways of grouping the ELBs in a tier into load balancers
+const (
+	// solverGreedy adds each ELB to the first load balancer that it's compatible with, or starts a new
+	// one if there isn't one
+	solverGreedy = "greedy"
+	// solverOptimal searches for the fewest load balancers that can replace the ELBs
+	solverOptimal = "optimal"
+)
+
+// searchLimit is the most steps that the search for the fewest load balancers takes for each class
+// of ELBs. Beyond that, it keeps the best grouping that it has found, which is never worse than the
+// greedy solver's.
+const searchLimit = 100000
+
+// solverComparison is the number of load balancers that each solver recommends for a tier
+type solverComparison struct {
+	greedy  int
+	optimal int
+}
+
+// solveOptimally recommends the fewest load balancers for the ELBs of a tier, which must be sorted by
+// name, given the greedy solver's recommendation for them.
+//
+// ELBs share a load balancer on the same terms as in addELBv2: each ELB must share a security group
+// with one that's already on it, or have a security group with equivalent ingress, the ELBs mustn't
+// listen on the same port unless it's an ALB, and an ALB mustn't go over its quotas. So ELBs which
+// will be replaced by the same type of load balancer are split into classes of ELBs which are
+// connected by compatible security groups, and the fewest groups of each class which can share a load
+// balancer are searched for. The search starts from the greedy solver's groups, so it can't do worse.
+func (t *tiers) solveOptimally(elbs []*elb.LoadBalancerDescription, greedy *recommendation) *recommendation {
+	res := newRecommendation()
+
+	byType := make(map[lbType][]*elb.LoadBalancerDescription)
+	for _, lb := range elbs {
+		// The greedy solver has already reported any ELB that can't be classified
+		if targetLB, err := inspectListeners(lb); err == nil {
+			byType[targetLB] = append(byType[targetLB], lb)
+		}
+	}
+	greedyLBs := map[lbType][]*LB{ALB: greedy.albs, NLB: greedy.nlbs, ELB: greedy.elbs}
+
+	for _, targetLB := range []lbType{ALB, NLB, ELB} {
+		strategy, _ := replacementStrategyFor(res, targetLB, t.quotas)
+
+		classes := make([][][]*elb.LoadBalancerDescription, 0)
+		groups := make([][]*elb.LoadBalancerDescription, 0)
+		for _, class := range t.compatibilityClasses(byType[targetLB]) {
+			classGroups := t.searchGroups(class, strategy, greedyLBs[targetLB])
+			classes = append(classes, classGroups)
+			groups = append(groups, classGroups...)
+		}
+
+		// Each group starts with its first ELB by name, so ordering by it keeps the output stable
+		sort.Slice(groups, func(i, j int) bool {
+			return *groups[i][0].LoadBalancerName < *groups[j][0].LoadBalancerName
+		})
+
+		added := make(map[string]*LB)
+		for _, group := range groups {
+			added[*group[0].LoadBalancerName] = t.addGroup(strategy, group)
+		}
+
+		// The ELBs of a class could only need more than one ALB because of its quotas, so say which
+		for _, classGroups := range classes {
+			for k := 1; k < len(classGroups); k++ {
+				for _, earlier := range classGroups[:k] {
+					if split := strategy.exceededQuota(added[*earlier[0].LoadBalancerName], classGroups[k][0]); split != nil {
+						added[*classGroups[k][0].LoadBalancerName].split = split
+						break
+					}
+				}
+			}
+		}
+	}
+
+	return res
+}
+
+// compatible is true if the ELBs' security groups let them share a load balancer: they have a
+// security group in common, or security groups with equivalent ingress
+func (t *tiers) compatible(a, b *elb.LoadBalancerDescription) bool {
+	_, ok := t.sharingReason(a, b)
+	return ok
+}
+
+// sharingReason explains why ELB b can share a load balancer with ELB a. The reason is empty if they
+// have a security group in common, since that needs no explanation.
+func (t *tiers) sharingReason(a, b *elb.LoadBalancerDescription) (string, bool) {
+	aGroups := securityGroupIDs(a)
+	bGroups := securityGroupIDs(b)
+
+	for _, sgA := range aGroups {
+		for _, sgB := range bGroups {
+			if sgA == sgB {
+				return "", true
+			}
+		}
+	}
+
+	for _, sgA := range aGroups {
+		for _, sgB := range bGroups {
+			if comparison := t.compareIngress(sgA, sgB); comparison.equivalent() {
+				return fmt.Sprintf("%s: %s", *b.LoadBalancerName, comparison.reason()), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// compatibilityClasses splits the ELBs into the connected components of the compatible relation,
+// keeping the ELBs of each class, and the classes themselves, in the order of the input
+func (t *tiers) compatibilityClasses(elbs []*elb.LoadBalancerDescription) [][]*elb.LoadBalancerDescription {
+	parent := make([]int, len(elbs))
+	for i := range parent {
+		parent[i] = i
+	}
+
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range elbs {
+		for j := i + 1; j < len(elbs); j++ {
+			if find(i) != find(j) && t.compatible(elbs[i], elbs[j]) {
+				// Keep the lowest index as the root, so that classes are ordered by their first ELB
+				a, b := find(i), find(j)
+				if b < a {
+					a, b = b, a
+				}
+				parent[b] = a
+			}
+		}
+	}
+
+	res := make([][]*elb.LoadBalancerDescription, 0)
+	indexes := make(map[int]int)
+	for i, lb := range elbs {
+		root := find(i)
+		if _, ok := indexes[root]; !ok {
+			indexes[root] = len(res)
+			res = append(res, make([]*elb.LoadBalancerDescription, 0))
+		}
+		res[indexes[root]] = append(res[indexes[root]], lb)
+	}
+
+	return res
+}
+
+// portsCollide is true if the ELBs listen on any of the same ports
+func portsCollide(a, b *elb.LoadBalancerDescription) bool {
+	ports := make(map[int64]struct{})
+	for _, ld := range a.ListenerDescriptions {
+		ports[*ld.Listener.LoadBalancerPort] = struct{}{}
+	}
+	for _, ld := range b.ListenerDescriptions {
+		if _, ok := ports[*ld.Listener.LoadBalancerPort]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// addGroup adds a load balancer which replaces every ELB in the group. Each ELB after the first must
+// be able to share it with an earlier one, and the rationale explains why when they don't have a
+// security group in common.
+func (t *tiers) addGroup(strategy elbReplacementStrategy, group []*elb.LoadBalancerDescription) *LB {
+	res := newLB(group[0])
+	strategy.add(res)
+	strategy.associate(res, group[0].SecurityGroups)
+
+	for i, lb := range group[1:] {
+		if reason := t.joinReason(group[:i+1], lb); reason != "" {
+			res.rationale = append(res.rationale, reason)
+		}
+		res.replaceELB(lb)
+		strategy.associate(res, lb.SecurityGroups)
+	}
+
+	return res
+}
+
+// joinReason explains why the ELB can share a load balancer with the members. It's empty if the ELB
+// has a security group in common with one of them.
+func (t *tiers) joinReason(members []*elb.LoadBalancerDescription, lb *elb.LoadBalancerDescription) string {
+	res := ""
+	for _, member := range members {
+		reason, ok := t.sharingReason(member, lb)
+		if ok && reason == "" {
+			return ""
+		}
+		if ok && res == "" {
+			res = reason
+		}
+	}
+	return res
+}
+
+// groupSearch is a branch and bound search for the fewest groups of a class of ELBs, where each group
+// can share a load balancer
+type groupSearch struct {
+	elbs       []*elb.LoadBalancerDescription
+	strategy   elbReplacementStrategy
+	compatible [][]bool // whether each pair of ELBs has a security group in common, or equivalent ones
+	best       [][]int  // the fewest groups found so far, as indexes into elbs
+	steps      int
+}
+
+// searchGroups returns the fewest groups of the class that we can find, where each group can share a
+// load balancer and is in an order that its ELBs could join it. The search starts from the better of
+// the greedy solver's groups and a DSATUR colouring of the ELBs whose ports collide.
+func (t *tiers) searchGroups(class []*elb.LoadBalancerDescription, strategy elbReplacementStrategy, greedy []*LB) [][]*elb.LoadBalancerDescription {
+	s := t.newGroupSearch(class, strategy)
+	s.best = s.greedyGroups(greedy)
+	if coloured := s.colouredGroups(); len(coloured) < len(s.best) && s.valid(coloured) {
+		s.best = coloured
+	}
+	s.search(0, make([][]int, 0))
+
+	res := make([][]*elb.LoadBalancerDescription, 0, len(s.best))
+	for _, group := range s.best {
+		ordered := make([]*elb.LoadBalancerDescription, 0, len(group))
+		for _, i := range s.joinOrder(group) {
+			ordered = append(ordered, class[i])
+		}
+		res = append(res, ordered)
+	}
+	return res
+}
+
+func (t *tiers) newGroupSearch(class []*elb.LoadBalancerDescription, strategy elbReplacementStrategy) *groupSearch {
+	res := &groupSearch{elbs: class, strategy: strategy, compatible: make([][]bool, len(class))}
+	for i := range class {
+		res.compatible[i] = make([]bool, len(class))
+		for j := range class {
+			res.compatible[i][j] = i != j && t.compatible(class[i], class[j])
+		}
+	}
+	return res
+}
+
+// greedyGroups returns the ELBs of the class which the greedy solver put on each load balancer
+func (s *groupSearch) greedyGroups(lbs []*LB) [][]int {
+	indexes := make(map[string]int)
+	for i, lb := range s.elbs {
+		indexes[*lb.LoadBalancerName] = i
+	}
+
+	res := make([][]int, 0)
+	for _, lb := range lbs {
+		group := make([]int, 0)
+		for _, name := range lb.elbs {
+			if i, ok := indexes[name]; ok {
+				group = append(group, i)
+			}
+		}
+		if len(group) > 0 {
+			sort.Ints(group)
+			res = append(res, group)
+		}
+	}
+	return res
+}
+
+// colouredGroups colours the ELBs so that ELBs whose ports collide have different colours, where that
+// matters, and splits each colour into the ELBs which are connected by compatible security groups
+func (s *groupSearch) colouredGroups() [][]int {
+	collisions := make([][]bool, len(s.elbs))
+	for i := range s.elbs {
+		collisions[i] = make([]bool, len(s.elbs))
+		for j := range s.elbs {
+			collisions[i][j] = i != j && !s.strategy.supportsPortCollisions() && portsCollide(s.elbs[i], s.elbs[j])
+		}
+	}
+
+	colours := dsatur(collisions)
+	res := make([][]int, 0)
+	for c := 0; c < countColours(colours); c++ {
+		group := make([]int, 0)
+		for i := range colours {
+			if colours[i] == c {
+				group = append(group, i)
+			}
+		}
+		res = append(res, s.components(group)...)
+	}
+	return res
+}
+
+// search puts ELB i, and each one after it, into one of the groups or a new one of its own, keeping
+// the fewest groups which can each share a load balancer
+func (s *groupSearch) search(i int, groups [][]int) {
+	if len(groups) >= len(s.best) || s.steps >= searchLimit {
+		return
+	}
+	s.steps++
+
+	if i == len(s.elbs) {
+		if s.valid(groups) {
+			s.best = make([][]int, len(groups))
+			for g := range groups {
+				s.best[g] = append([]int(nil), groups[g]...)
+			}
+		}
+		return
+	}
+
+	for g := range groups {
+		if s.fits(groups[g], i) {
+			groups[g] = append(groups[g], i)
+			s.search(i+1, groups)
+			groups[g] = groups[g][:len(groups[g])-1]
+		}
+	}
+	s.search(i+1, append(groups, []int{i}))
+}
+
+// fits is true if ELB i can be on the same load balancer as the group without their ports colliding,
+// where that matters, or going over a quota
+func (s *groupSearch) fits(group []int, i int) bool {
+	if !s.strategy.supportsPortCollisions() {
+		for _, j := range group {
+			if portsCollide(s.elbs[i], s.elbs[j]) {
+				return false
+			}
+		}
+	}
+
+	lb := newLB(s.elbs[group[0]])
+	for _, j := range group[1:] {
+		lb.replaceELB(s.elbs[j])
+	}
+	return s.strategy.exceededQuota(lb, s.elbs[i]) == nil
+}
+
+// valid is true if the ELBs of every group are connected by compatible security groups, and fit on
+// one load balancer
+func (s *groupSearch) valid(groups [][]int) bool {
+	for _, group := range groups {
+		if len(s.components(group)) > 1 {
+			return false
+		}
+		for k := 1; k < len(group); k++ {
+			if !s.fits(group[:k], group[k]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// joinOrder orders a connected group so that each ELB after the first is compatible with an earlier
+// one, taking the first such ELB in the order of the group each time
+func (s *groupSearch) joinOrder(group []int) []int {
+	res := []int{group[0]}
+	added := map[int]bool{group[0]: true}
+
+	for len(res) < len(group) {
+		next := -1
+		for _, i := range group {
+			if added[i] {
+				continue
+			}
+			for _, j := range res {
+				if s.compatible[i][j] {
+					next = i
+					break
+				}
+			}
+			if next != -1 {
+				break
+			}
+		}
+		res = append(res, next)
+		added[next] = true
+	}
+
+	return res
+}
+
+// components splits the group into the ELBs which are connected by compatible security groups, each
+// in the order of the group
+func (s *groupSearch) components(group []int) [][]int {
+	seen := make(map[int]bool)
+	res := make([][]int, 0)
+
+	for _, i := range group {
+		if seen[i] {
+			continue
+		}
+		seen[i] = true
+		members := []int{i}
+		for k := 0; k < len(members); k++ {
+			for _, j := range group {
+				if !seen[j] && s.compatible[members[k]][j] {
+					seen[j] = true
+					members = append(members, j)
+				}
+			}
+		}
+		sort.Ints(members)
+		res = append(res, members)
+	}
+
+	return res
+}
+
+// countColours returns the number of colours used by a colouring
+func countColours(colours []int) int {
+	res := 0
+	for _, c := range colours {
+		if c+1 > res {
+			res = c + 1
+		}
+	}
+	return res
+}
+
+// dsatur is Brélaz's heuristic: repeatedly colour the vertex whose neighbours already have the most
+// distinct colours, breaking ties by degree and then by position, with the lowest colour available.
+func dsatur(adjacent [][]bool) []int {
+	n := len(adjacent)
+	res := make([]int, n)
+	for i := range res {
+		res[i] = -1
+	}
+
+	degree := make([]int, n)
+	for i := range adjacent {
+		for j := range adjacent[i] {
+			if adjacent[i][j] {
+				degree[i]++
+			}
+		}
+	}
+
+	for coloured := 0; coloured < n; coloured++ {
+		next, nextSaturation := -1, -1
+		for v := 0; v < n; v++ {
+			if res[v] != -1 {
+				continue
+			}
+			saturation := len(neighbourColours(adjacent, res, v))
+			if saturation > nextSaturation || (saturation == nextSaturation && degree[v] > degree[next]) {
+				next, nextSaturation = v, saturation
+			}
+		}
+
+		used := neighbourColours(adjacent, res, next)
+		c := 0
+		for {
+			if _, ok := used[c]; !ok {
+				break
+			}
+			c++
+		}
+		res[next] = c
+	}
+
+	return res
+}
+
+// neighbourColours returns the set of colours already given to the neighbours of a vertex
+func neighbourColours(adjacent [][]bool, colours []int, v int) map[int]struct{} {
+	res := make(map[int]struct{})
+	for u := range adjacent[v] {
+		if adjacent[v][u] && colours[u] != -1 {
+			res[colours[u]] = struct{}{}
+		}
+	}
+	return res
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/stretchr/testify/assert"
+)
+
+// collidingNLBs are ELBs which will become NLBs, where greedy first-fit needs three load balancers but
+// two are enough: [a, c] and [b, d]
+func collidingNLBs() []*elb.LoadBalancerDescription {
+	return []*elb.LoadBalancerDescription{
+		createELB("a").
+			withSubnets("subnet-1").
+			withListenerDescriptions(listenerDescription{port: 8001, protocol: "TCP"}).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("b").
+			withSubnets("subnet-1").
+			withListenerDescriptions(listenerDescription{port: 8001, protocol: "TCP"}).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("c").
+			withSubnets("subnet-1").
+			withListenerDescriptions(listenerDescription{port: 8002, protocol: "TCP"}).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("d").
+			withSubnets("subnet-1").
+			withListenerDescriptions(listenerDescription{port: 8002, protocol: "TCP"}).
+			withSecurityGroups("sg-1").
+			build(),
+	}
+}
+
+func TestGreedySolverIsTheDefault(t *testing.T) {
+	recommendations, err := generateRecommendations(collidingNLBs(), make(map[string]*ec2.SecurityGroup))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, len(recommendations))
+	assert.Equal(t, 3, len(recommendations[0].NLBs()))
+	assert.Nil(t, recommendations[0].solvers)
+}
+
+func TestOptimalSolverUsesFewerLoadBalancers(t *testing.T) {
+	inv := &inventory{elbs: collidingNLBs(), securityGroups: make(map[string]*ec2.SecurityGroup)}
+	recommendations, _ := analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent, solver: solverOptimal})
+
+	assert.Equal(t, 1, len(recommendations))
+	nlbs := recommendations[0].NLBs()
+	assert.Equal(t, 2, len(nlbs))
+	assert.Equal(t, []string{"a", "c"}, nlbs[0].ELBs())
+	assert.Equal(t, []string{"b", "d"}, nlbs[1].ELBs())
+	assert.Equal(t, &solverComparison{greedy: 3, optimal: 2}, recommendations[0].solvers)
+
+	total := tallyRecommendations(recommendations)
+	assert.Equal(t, &tally{currentElbs: 4, nlbs: 2, solved: true, greedy: 3, optimal: 2, uncosted: 1}, total)
+}
+
+func TestOptimalSolverOnlyGroupsCompatibleSecurityGroups(t *testing.T) {
+	sgs := map[string]*ec2.SecurityGroup{
+		"sg-1": securityGroup("sg-1", tcpFrom(8001, 8002, "10.0.0.0/8")),
+		"sg-2": securityGroup("sg-2", tcpFrom(8001, 8002, "10.0.0.0/9", "10.128.0.0/9")),
+		"sg-3": securityGroup("sg-3", tcpFrom(8001, 8002, "192.168.0.0/16")),
+	}
+	elbs := []*elb.LoadBalancerDescription{
+		createELB("a").
+			withSubnets("subnet-1").
+			withListenerDescriptions(listenerDescription{port: 8001, protocol: "TCP"}).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("b").
+			withSubnets("subnet-1").
+			withListenerDescriptions(listenerDescription{port: 8002, protocol: "TCP"}).
+			withSecurityGroups("sg-2").
+			build(),
+		createELB("c").
+			withSubnets("subnet-1").
+			withListenerDescriptions(listenerDescription{port: 8003, protocol: "TCP"}).
+			withSecurityGroups("sg-3").
+			build(),
+	}
+
+	inv := &inventory{elbs: elbs, securityGroups: sgs}
+	recommendations, _ := analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent, solver: solverOptimal})
+
+	nlbs := recommendations[0].NLBs()
+	assert.Equal(t, 2, len(nlbs))
+	assert.Equal(t, []string{"a", "b"}, nlbs[0].ELBs())
+	assert.Equal(t, 1, len(nlbs[0].Rationale()))
+	assert.Contains(t, nlbs[0].Rationale()[0], "b: ")
+	assert.Equal(t, []string{"c"}, nlbs[1].ELBs())
+}
+
+// cycle returns the adjacency matrix of a cycle with n vertices
+func cycle(n int) [][]bool {
+	res := make([][]bool, n)
+	for i := range res {
+		res[i] = make([]bool, n)
+	}
+	for i := 0; i < n; i++ {
+		j := (i + 1) % n
+		res[i][j] = true
+		res[j][i] = true
+	}
+	return res
+}
+
+// assertValidColouring checks that no two adjacent vertices have the same colour
+func assertValidColouring(t *testing.T, adjacent [][]bool, colours []int) {
+	for i := range adjacent {
+		for j := range adjacent[i] {
+			if adjacent[i][j] {
+				assert.NotEqual(t, colours[i], colours[j], "vertices %d and %d", i, j)
+			}
+		}
+	}
+}
+
+func TestDSATURColoursEvenCyclesWithTwoColours(t *testing.T) {
+	for _, n := range []int{4, 10, 30} {
+		colours := dsatur(cycle(n))
+
+		assertValidColouring(t, cycle(n), colours)
+		// DSATUR is exact for bipartite graphs, such as even cycles
+		assert.Equal(t, 2, countColours(colours), "cycle of %d", n)
+	}
+}
+
+func TestOptimalSolverSharesLikeGreedy(t *testing.T) {
+	// a and c have no security group in common, but both have one in common with b
+	elbs := []*elb.LoadBalancerDescription{
+		elbInTier("a", listenerDescription{port: 8001, protocol: "TCP"}).withSecurityGroups("sg-1").build(),
+		elbInTier("b", listenerDescription{port: 8002, protocol: "TCP"}).withSecurityGroups("sg-1", "sg-2").build(),
+		elbInTier("c", listenerDescription{port: 8003, protocol: "TCP"}).withSecurityGroups("sg-2").build(),
+	}
+
+	recommendations, _ := analyse(tierInventory(elbs...), &analysisOptions{sgPolicy: sgPolicyEquivalent, solver: solverOptimal})
+
+	nlbs := recommendations[0].NLBs()
+	assert.Equal(t, 1, len(nlbs))
+	assert.Equal(t, []string{"a", "b", "c"}, nlbs[0].ELBs())
+	assert.Equal(t, &solverComparison{greedy: 1, optimal: 1}, recommendations[0].solvers)
+}
+
+// randomTier returns ELBs which listen on random ports with random security groups, so that some of
+// them collide and some can't share a load balancer
+func randomTier(r *rand.Rand, n int) []*elb.LoadBalancerDescription {
+	res := make([]*elb.LoadBalancerDescription, 0, n)
+	for i := 0; i < n; i++ {
+		protocol := "TCP"
+		if r.Intn(3) == 0 {
+			protocol = "HTTP"
+		}
+		listeners := make([]listenerDescription, 0)
+		for _, port := range r.Perm(4)[:1+r.Intn(2)] {
+			listeners = append(listeners, listenerDescription{port: int64(8001 + port), protocol: protocol})
+		}
+		sgs := make([]string, 0)
+		for _, sg := range r.Perm(4)[:1+r.Intn(2)] {
+			sgs = append(sgs, fmt.Sprintf("sg-%d", sg))
+		}
+		res = append(res, elbInTier(fmt.Sprintf("lb-%02d", i), listeners...).withSecurityGroups(sgs...).build())
+	}
+	return res
+}
+
+func TestOptimalSolverIsNeverWorseThanGreedy(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		inv := tierInventory(randomTier(r, 4+r.Intn(12))...)
+		recommendations, _ := analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent, solver: solverOptimal})
+
+		solvers := recommendations[0].solvers
+		assert.LessOrEqual(t, solvers.optimal, solvers.greedy, "tier %d", i)
+		assert.Equal(t, solvers.optimal, recommendations[0].loadBalancerCount(), "tier %d", i)
+	}
+}
+
+func TestGroupSearchStopsAtTheLimit(t *testing.T) {
+	class := randomTier(rand.New(rand.NewSource(1)), 40)
+	for _, lb := range class {
+		lb.SecurityGroups = aws.StringSlice([]string{"sg-1"})
+		for _, listener := range lb.ListenerDescriptions {
+			listener.Listener.Protocol = aws.String("TCP")
+		}
+	}
+	tiers := newTiers(make(map[string]*ec2.SecurityGroup), nil)
+	strategy, _ := replacementStrategyFor(newRecommendation(), NLB, tiers.quotas)
+
+	s := tiers.newGroupSearch(class, strategy)
+	s.best = s.colouredGroups()
+	s.search(0, make([][]int, 0))
+
+	assert.Equal(t, searchLimit, s.steps)
+	assert.True(t, s.valid(s.best))
+}
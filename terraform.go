@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// terraformFile is the name of the file that the Terraform emitter writes
+const terraformFile = "elb-pruner.tf"
+
+// terraformEmitter renders each recommended ALB and NLB as aws_lb, aws_lb_listener,
+// aws_lb_listener_rule and aws_lb_target_group resources.
+type terraformEmitter struct{}
+
+func (e *terraformEmitter) emit(dir string, reports []report) error {
+	return writeFile(dir, terraformFile, renderTerraform(planReports(reports)))
+}
+
+// renderTerraform renders the plans as a single Terraform file, with a provider for each account
+// and region.
+func renderTerraform(tiers []*tierPlan) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("# Generated by elb-pruner. Review the resources, and set the variables, before applying.\n")
+
+	providers := make(map[string]struct{})
+	variables := make([]*hclBlock, 0)
+	names := newTerraformNames()
+
+	for _, tier := range tiers {
+		provider := terraformProvider(tier)
+
+		if _, ok := providers[provider]; !ok {
+			providers[provider] = struct{}{}
+
+			block := newHCLBlock("provider", "aws").
+				attr("alias", hclString(provider)).
+				attr("region", hclString(tier.region))
+			if tier.account != "" {
+				block.comment = fmt.Sprintf("Credentials for account %s need to be configured for this provider", tier.account)
+				block.attr("allowed_account_ids", hclList([]string{tier.account}))
+			}
+			block.write(&buf)
+		}
+
+		for _, plan := range tier.lbs {
+			for _, block := range terraformResources(plan, provider, names) {
+				block.write(&buf)
+			}
+
+			variables = append(variables, terraformVariables(plan, names)...)
+		}
+	}
+
+	for _, block := range variables {
+		block.write(&buf)
+	}
+
+	return buf.Bytes()
+}
+
+// terraformProvider is the alias of the provider for the tier's account and region
+func terraformProvider(tier *tierPlan) string {
+	if tier.account == "" {
+		return identifier("_", tier.region)
+	}
+	return identifier("_", "account", tier.account, tier.region)
+}
+
+// terraformNames hands out the names of the resources and of the variables, so that none of them are
+// the same even when the plans' names clean up to the same identifier
+type terraformNames struct {
+	resources *uniqueIdentifiers
+	variables *uniqueIdentifiers
+}
+
+func newTerraformNames() *terraformNames {
+	return &terraformNames{resources: newUniqueIdentifiers("_"), variables: newUniqueIdentifiers("_")}
+}
+
+// resource returns the name of the plan's resource
+func (n *terraformNames) resource(plan any, name []string) string {
+	return n.resources.of(plan, identifier("_", name...))
+}
+
+// variable returns the name of the plan's variable
+func (n *terraformNames) variable(plan any, name []string) string {
+	return n.variables.of(plan, identifier("_", name...))
+}
+
+// terraformResources renders the load balancer, target groups, listeners and rules of a plan
+func terraformResources(plan *lbPlan, provider string, names *terraformNames) []*hclBlock {
+	res := make([]*hclBlock, 0)
+	providerRef := "aws." + provider
+	lbName := names.resource(plan, plan.name)
+
+	lb := newHCLBlock("resource", "aws_lb", lbName).
+		attr("provider", providerRef).
+		attr("load_balancer_type", hclString(plan.lbType)).
+		attr("internal", strconv.FormatBool(plan.internal)).
+		attr("subnets", hclList(plan.subnets))
+	if plan.lbType == "application" {
+		lb.attr("security_groups", hclList(plan.securityGroups))
+	}
+	lb.comment = "Replaces the ELBs: " + strings.Join(plan.elbs, ", ")
+	res = append(res, lb)
+
+	for _, tg := range plan.targetGroups {
+		block := newHCLBlock("resource", "aws_lb_target_group", names.resource(tg, tg.name)).
+			attr("provider", providerRef).
+			attr("port", strconv.FormatInt(tg.port, 10)).
+			attr("protocol", hclString(tg.protocol)).
+			attr("vpc_id", hclString(tg.vpc))
+		block.comment = "Register the instances of the ELB " + tg.elb
+		if tg.review != "" {
+			block.comment += ". Before applying, " + tg.review
+		}
+		if tg.healthCheck != nil {
+			terraformHealthCheck(block, tg)
+		}
+		res = append(res, block)
+	}
+
+	for _, listener := range plan.listeners {
+		listenerName := names.resource(listener, listener.name)
+
+		block := newHCLBlock("resource", "aws_lb_listener", listenerName).
+			attr("provider", providerRef).
+			attr("load_balancer_arn", fmt.Sprintf("aws_lb.%s.arn", lbName)).
+			attr("port", strconv.Itoa(listener.port)).
+			attr("protocol", hclString(listener.protocol))
+
+		if listener.terminatesTLS() {
+			block.attr("certificate_arn", terraformCertificate(listener, names))
+		}
+
+		action := block.block("default_action")
+		if listener.defaultTarget != nil {
+			action.attr("type", hclString("forward")).
+				attr("target_group_arn", terraformTargetGroupARN(listener.defaultTarget, names))
+		} else {
+			action.attr("type", hclString("fixed-response"))
+			action.block("fixed_response").
+				attr("content_type", hclString("text/plain")).
+				attr("status_code", hclString("404"))
+		}
+		res = append(res, block)
+
+		for i, cert := range listener.certificates {
+			if i == 0 {
+				continue
+			}
+			res = append(res, newHCLBlock("resource", "aws_lb_listener_certificate", names.resources.unique(identifier("_", listenerName, strconv.Itoa(i+1)))).
+				attr("provider", providerRef).
+				attr("listener_arn", fmt.Sprintf("aws_lb_listener.%s.arn", listenerName)).
+				attr("certificate_arn", hclString(cert)))
+		}
+
+		for _, rule := range listener.rules {
+			block := newHCLBlock("resource", "aws_lb_listener_rule", names.resource(rule, rule.name)).
+				attr("provider", providerRef).
+				attr("listener_arn", fmt.Sprintf("aws_lb_listener.%s.arn", listenerName)).
+				attr("priority", strconv.Itoa(rule.priority))
+			block.block("action").
+				attr("type", hclString("forward")).
+				attr("target_group_arn", terraformTargetGroupARN(rule.target, names))
+			block.block("condition").
+				block("host_header").
+				attr("values", terraformHostHeaders(rule, names))
+			res = append(res, block)
+		}
+	}
+
+	return res
+}
+
+// terraformVariables declares the inputs that we can't work out from the account
+func terraformVariables(plan *lbPlan, names *terraformNames) []*hclBlock {
+	res := make([]*hclBlock, 0)
+
+	for _, listener := range plan.listeners {
+		if listener.terminatesTLS() && len(listener.certificates) == 0 {
+			res = append(res, newHCLBlock("variable", terraformCertificateVariable(listener, names)).
+				attr("description", hclString(fmt.Sprintf("The ARN of the certificate for port %d of %s", listener.port, names.resource(plan, plan.name)))).
+				attr("type", "string"))
+		}
+
+		for _, rule := range listener.rules {
+			if len(rule.hostnames) > 0 {
+				continue
+			}
+			res = append(res, newHCLBlock("variable", terraformHostHeadersVariable(rule, names)).
+				attr("description", hclString(fmt.Sprintf("The hostnames served by the ELB %s on port %d", rule.target.elb, listener.port))).
+				attr("type", "list(string)"))
+		}
+	}
+
+	return res
+}
+
+func terraformCertificate(listener *listenerPlan, names *terraformNames) string {
+	if len(listener.certificates) > 0 {
+		return hclString(listener.certificates[0])
+	}
+	return "var." + terraformCertificateVariable(listener, names)
+}
+
+func terraformCertificateVariable(listener *listenerPlan, names *terraformNames) string {
+	return names.variable(listener, extend(listener.name, "certificate_arn"))
+}
+
+// terraformHostHeaders is the Route 53 names for the rule's ELB, or a variable if we didn't find any
+func terraformHostHeaders(rule *rulePlan, names *terraformNames) string {
+	if len(rule.hostnames) > 0 {
+		return hclList(rule.hostnames)
+	}
+	return "var." + terraformHostHeadersVariable(rule, names)
+}
+
+func terraformHostHeadersVariable(rule *rulePlan, names *terraformNames) string {
+	return names.variable(rule, extend(rule.name, "host_headers"))
+}
+
+func terraformTargetGroupARN(tg *targetGroupPlan, names *terraformNames) string {
+	return fmt.Sprintf("aws_lb_target_group.%s.arn", names.resource(tg, tg.name))
+}
+
+// terraformHealthCheck adds the target group's health check to its block, with a comment on how it
+// differs from the ELB's
+func terraformHealthCheck(block *hclBlock, tg *targetGroupPlan) {
+	hc := tg.healthCheck
+	check := block.block("health_check")
+
+	if hc.protocol != "" {
+		check.attr("protocol", hclString(hc.protocol)).
+			attr("port", hclString(tg.healthCheckPort()))
+	}
+	if hc.path != "" {
+		check.attr("path", hclString(hc.path))
+	}
+	if hc.matcher != "" {
+		check.attr("matcher", hclString(hc.matcher))
+	}
+	check.attr("interval", strconv.Itoa(hc.interval)).
+		attr("timeout", strconv.Itoa(hc.timeout)).
+		attr("healthy_threshold", strconv.Itoa(hc.healthyThreshold)).
+		attr("unhealthy_threshold", strconv.Itoa(hc.unhealthyThreshold))
+
+	if len(hc.differences) > 0 {
+		check.comment = "Unlike the ELB's health check, " + strings.Join(hc.differences, "; ")
+	}
+}
+
+// hclBlock is a block of HCL, which we write in the same layout as `terraform fmt`
+type hclBlock struct {
+	comment    string
+	header     string
+	attributes [][2]string
+	blocks     []*hclBlock
+}
+
+func newHCLBlock(blockType string, labels ...string) *hclBlock {
+	header := blockType
+	for _, label := range labels {
+		header += " " + hclString(label)
+	}
+	return &hclBlock{header: header}
+}
+
+// attr adds an attribute with a value which is already in HCL syntax
+func (b *hclBlock) attr(name, value string) *hclBlock {
+	b.attributes = append(b.attributes, [2]string{name, value})
+	return b
+}
+
+// block adds a nested block, and returns it
+func (b *hclBlock) block(blockType string) *hclBlock {
+	res := &hclBlock{header: blockType}
+	b.blocks = append(b.blocks, res)
+	return res
+}
+
+// write writes the block as a top-level block, preceded by a blank line
+func (b *hclBlock) write(buf *bytes.Buffer) {
+	buf.WriteString("\n")
+	b.writeIndented(buf, "")
+}
+
+func (b *hclBlock) writeIndented(buf *bytes.Buffer, indent string) {
+	if b.comment != "" {
+		fmt.Fprintf(buf, "%s# %s\n", indent, b.comment)
+	}
+	fmt.Fprintf(buf, "%s%s {\n", indent, b.header)
+
+	width := 0
+	for _, a := range b.attributes {
+		if len(a[0]) > width {
+			width = len(a[0])
+		}
+	}
+	for _, a := range b.attributes {
+		fmt.Fprintf(buf, "%s  %-*s = %s\n", indent, width, a[0], a[1])
+	}
+
+	for i, nested := range b.blocks {
+		if i > 0 || len(b.attributes) > 0 {
+			buf.WriteString("\n")
+		}
+		nested.writeIndented(buf, indent+"  ")
+	}
+
+	fmt.Fprintf(buf, "%s}\n", indent)
+}
+
+func hclString(s string) string {
+	return strconv.Quote(s)
+}
+
+func hclList(values []string) string {
+	quoted := make([]string, len(values))
+	for i := range values {
+		quoted[i] = hclString(values[i])
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/stretchr/testify/assert"
+)
+
+func terraformFixture() []report {
+	elbs := []*elb.LoadBalancerDescription{
+		createELB("web").
+			withSubnets("subnet-a", "subnet-b").
+			withVPC("vpc-1").
+			withListenerDescriptions(
+				listenerDescription{port: 80, protocol: "HTTP", instanceProtocol: "HTTP", instancePort: 8080},
+				listenerDescription{port: 443, protocol: "HTTPS", instanceProtocol: "HTTP", instancePort: 8080, certificate: "arn:cert/web"},
+			).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("api").
+			withSubnets("subnet-a", "subnet-b").
+			withVPC("vpc-1").
+			withListenerDescriptions(
+				listenerDescription{port: 443, protocol: "HTTPS", instanceProtocol: "HTTP", instancePort: 9000, certificate: "arn:cert/api"},
+			).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("cache").
+			withSubnets("subnet-a", "subnet-b").
+			withVPC("vpc-1").
+			withListenerDescriptions(
+				listenerDescription{port: 11211, protocol: "TCP", instanceProtocol: "TCP", instancePort: 11211},
+			).
+			withSecurityGroups("sg-1").
+			build(),
+		createELB("ldap").
+			withSubnets("subnet-a", "subnet-b").
+			withVPC("vpc-1").
+			withListenerDescriptions(
+				listenerDescription{port: 636, protocol: "SSL", instanceProtocol: "TCP", instancePort: 389, certificate: "arn:cert/ldap"},
+			).
+			withSecurityGroups("sg-1").
+			build(),
+	}
+
+	recommendations, _ := generateRecommendations(elbs, make(map[string]*ec2.SecurityGroup))
+
+	return []report{
+		{
+			region:          "eu-west-1",
+			recommendations: recommendations,
+		},
+	}
+}
+
+const expectedTerraform = `# Generated by elb-pruner. Review the resources, and set the variables, before applying.
+
+provider "aws" {
+  alias  = "eu_west_1"
+  region = "eu-west-1"
+}
+
+# Replaces the ELBs: api, web
+resource "aws_lb" "eu_west_1_tier1_alb1" {
+  provider           = aws.eu_west_1
+  load_balancer_type = "application"
+  internal           = false
+  subnets            = ["subnet-a", "subnet-b"]
+  security_groups    = ["sg-1"]
+}
+
+# Register the instances of the ELB api
+resource "aws_lb_target_group" "eu_west_1_tier1_alb1_api" {
+  provider = aws.eu_west_1
+  port     = 9000
+  protocol = "HTTP"
+  vpc_id   = "vpc-1"
+}
+
+# Register the instances of the ELB web
+resource "aws_lb_target_group" "eu_west_1_tier1_alb1_web" {
+  provider = aws.eu_west_1
+  port     = 8080
+  protocol = "HTTP"
+  vpc_id   = "vpc-1"
+}
+
+resource "aws_lb_listener" "eu_west_1_tier1_alb1_80" {
+  provider          = aws.eu_west_1
+  load_balancer_arn = aws_lb.eu_west_1_tier1_alb1.arn
+  port              = 80
+  protocol          = "HTTP"
+
+  default_action {
+    type             = "forward"
+    target_group_arn = aws_lb_target_group.eu_west_1_tier1_alb1_web.arn
+  }
+}
+
+resource "aws_lb_listener" "eu_west_1_tier1_alb1_443" {
+  provider          = aws.eu_west_1
+  load_balancer_arn = aws_lb.eu_west_1_tier1_alb1.arn
+  port              = 443
+  protocol          = "HTTPS"
+  certificate_arn   = "arn:cert/api"
+
+  default_action {
+    type = "fixed-response"
+
+    fixed_response {
+      content_type = "text/plain"
+      status_code  = "404"
+    }
+  }
+}
+
+resource "aws_lb_listener_certificate" "eu_west_1_tier1_alb1_443_2" {
+  provider        = aws.eu_west_1
+  listener_arn    = aws_lb_listener.eu_west_1_tier1_alb1_443.arn
+  certificate_arn = "arn:cert/web"
+}
+
+resource "aws_lb_listener_rule" "eu_west_1_tier1_alb1_443_api" {
+  provider     = aws.eu_west_1
+  listener_arn = aws_lb_listener.eu_west_1_tier1_alb1_443.arn
+  priority     = 1
+
+  action {
+    type             = "forward"
+    target_group_arn = aws_lb_target_group.eu_west_1_tier1_alb1_api.arn
+  }
+
+  condition {
+    host_header {
+      values = var.eu_west_1_tier1_alb1_443_api_host_headers
+    }
+  }
+}
+
+resource "aws_lb_listener_rule" "eu_west_1_tier1_alb1_443_web" {
+  provider     = aws.eu_west_1
+  listener_arn = aws_lb_listener.eu_west_1_tier1_alb1_443.arn
+  priority     = 2
+
+  action {
+    type             = "forward"
+    target_group_arn = aws_lb_target_group.eu_west_1_tier1_alb1_web.arn
+  }
+
+  condition {
+    host_header {
+      values = var.eu_west_1_tier1_alb1_443_web_host_headers
+    }
+  }
+}
+
+# Replaces the ELBs: cache, ldap
+resource "aws_lb" "eu_west_1_tier1_nlb1" {
+  provider           = aws.eu_west_1
+  load_balancer_type = "network"
+  internal           = false
+  subnets            = ["subnet-a", "subnet-b"]
+}
+
+# Register the instances of the ELB cache
+resource "aws_lb_target_group" "eu_west_1_tier1_nlb1_cache" {
+  provider = aws.eu_west_1
+  port     = 11211
+  protocol = "TCP"
+  vpc_id   = "vpc-1"
+}
+
+# Register the instances of the ELB ldap
+resource "aws_lb_target_group" "eu_west_1_tier1_nlb1_ldap" {
+  provider = aws.eu_west_1
+  port     = 389
+  protocol = "TCP"
+  vpc_id   = "vpc-1"
+}
+
+resource "aws_lb_listener" "eu_west_1_tier1_nlb1_636" {
+  provider          = aws.eu_west_1
+  load_balancer_arn = aws_lb.eu_west_1_tier1_nlb1.arn
+  port              = 636
+  protocol          = "TLS"
+  certificate_arn   = "arn:cert/ldap"
+
+  default_action {
+    type             = "forward"
+    target_group_arn = aws_lb_target_group.eu_west_1_tier1_nlb1_ldap.arn
+  }
+}
+
+resource "aws_lb_listener" "eu_west_1_tier1_nlb1_11211" {
+  provider          = aws.eu_west_1
+  load_balancer_arn = aws_lb.eu_west_1_tier1_nlb1.arn
+  port              = 11211
+  protocol          = "TCP"
+
+  default_action {
+    type             = "forward"
+    target_group_arn = aws_lb_target_group.eu_west_1_tier1_nlb1_cache.arn
+  }
+}
+
+variable "eu_west_1_tier1_alb1_443_api_host_headers" {
+  description = "The hostnames served by the ELB api on port 443"
+  type        = list(string)
+}
+
+variable "eu_west_1_tier1_alb1_443_web_host_headers" {
+  description = "The hostnames served by the ELB web on port 443"
+  type        = list(string)
+}
+`
+
+func TestTerraformIsRenderedForEachALBAndNLB(t *testing.T) {
+	assert.Equal(t, expectedTerraform, string(renderTerraform(planReports(terraformFixture()))))
+}
+
+func TestTerraformIsDeterministic(t *testing.T) {
+	first := renderTerraform(planReports(terraformFixture()))
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, first, renderTerraform(planReports(terraformFixture())))
+	}
+}
+
+func TestTerraformEmitterWritesAFile(t *testing.T) {
+	dir := t.TempDir()
+
+	assert.NoError(t, emitters["terraform"].emit(dir, terraformFixture()))
+
+	content, err := os.ReadFile(filepath.Join(dir, terraformFile))
+	assert.NoError(t, err)
+	assert.Equal(t, expectedTerraform, string(content))
+}
+
+func TestTerraformProvidersAreScopedToAccountAndRegion(t *testing.T) {
+	reports := terraformFixture()
+	reports[0].account = "111111111111"
+
+	rendered := string(renderTerraform(planReports(reports)))
+
+	assert.Contains(t, rendered, `provider "aws" {
+  alias               = "account_111111111111_eu_west_1"
+  region              = "eu-west-1"
+  allowed_account_ids = ["111111111111"]
+}`)
+	assert.Contains(t, rendered, `resource "aws_lb" "account_111111111111_eu_west_1_tier1_alb1" {`)
+}
+
+func TestTerraformHasATargetGroupForEachBackend(t *testing.T) {
+	recommendations, _ := generateRecommendations(backendsFixture(), make(map[string]*ec2.SecurityGroup))
+	rendered := string(renderTerraform(planReports([]report{{region: "eu-west-1", recommendations: recommendations}})))
+
+	assert.Contains(t, rendered, `# Register the instances of the ELB web
+resource "aws_lb_target_group" "eu_west_1_tier1_alb1_web_https_8443" {
+  provider = aws.eu_west_1
+  port     = 8443
+  protocol = "HTTPS"
+  vpc_id   = "vpc-1"
+}`)
+	assert.Contains(t, rendered, `resource "aws_lb_listener" "eu_west_1_tier1_alb1_8443" {
+  provider          = aws.eu_west_1
+  load_balancer_arn = aws_lb.eu_west_1_tier1_alb1.arn
+  port              = 8443
+  protocol          = "HTTPS"
+  certificate_arn   = var.eu_west_1_tier1_alb1_8443_certificate_arn
+
+  default_action {
+    type             = "forward"
+    target_group_arn = aws_lb_target_group.eu_west_1_tier1_alb1_web_https_8443.arn
+  }
+}`)
+	assert.Contains(t, rendered, `target_group_arn = aws_lb_target_group.eu_west_1_tier1_alb1_web_http_8080.arn`)
+}
+
+func TestTerraformTargetGroupsHaveTheELBsHealthChecks(t *testing.T) {
+	recommendations, _ := generateRecommendations(healthChecksFixture(), make(map[string]*ec2.SecurityGroup))
+	rendered := string(renderTerraform(planReports([]report{{region: "eu-west-1", recommendations: recommendations}})))
+
+	assert.Contains(t, rendered, `resource "aws_lb_target_group" "eu_west_1_tier1_alb1_web" {
+  provider = aws.eu_west_1
+  port     = 8080
+  protocol = "HTTP"
+  vpc_id   = "vpc-1"
+
+  health_check {
+    protocol            = "HTTP"
+    port                = "traffic-port"
+    path                = "/health"
+    matcher             = "200"
+    interval            = 30
+    timeout             = 5
+    healthy_threshold   = 2
+    unhealthy_threshold = 10
+  }
+}`)
+	assert.Contains(t, rendered, `  # Unlike the ELB's health check, NLBs can't check SSL, so it only checks that port 389 accepts TCP connections
+  health_check {
+    protocol            = "TCP"
+    port                = "traffic-port"
+    interval            = 30`)
+}
+
+func TestTerraformTargetGroupsKeepTLSWhichTheELBPassedThrough(t *testing.T) {
+	elbs := []*elb.LoadBalancerDescription{
+		createELB("secure").
+			withSubnets("a").
+			withVPC("vpc-1").
+			withSecurityGroups("sg-1").
+			withListenerDescriptions(
+				listenerDescription{port: 443, protocol: "TCP", instanceProtocol: "TCP", instancePort: 443},
+			).
+			build(),
+		createELB("unsure").
+			withSubnets("a").
+			withVPC("vpc-1").
+			withSecurityGroups("sg-1").
+			withListenerDescriptions(
+				listenerDescription{port: 443, protocol: "TCP", instanceProtocol: "TCP", instancePort: 8443},
+			).
+			build(),
+	}
+	recommendations, _ := generateRecommendations(elbs, make(map[string]*ec2.SecurityGroup))
+	rendered := string(renderTerraform(planReports([]report{{region: "eu-west-1", recommendations: recommendations}})))
+
+	assert.Contains(t, rendered, `# Register the instances of the ELB secure
+resource "aws_lb_target_group" "eu_west_1_tier1_alb1_secure" {
+  provider = aws.eu_west_1
+  port     = 443
+  protocol = "HTTPS"
+  vpc_id   = "vpc-1"
+}`)
+	assert.Contains(t, rendered, `# Register the instances of the ELB unsure. Before applying, the ELB passed TCP through from port 443 to port 8443, so check whether the instances expect HTTP or HTTPS
+resource "aws_lb_target_group" "eu_west_1_tier1_alb1_unsure" {`)
+}
+
+func TestTerraformNamesAreUnique(t *testing.T) {
+	rendered := string(renderTerraform(planReports(collidingNamesFixture())))
+
+	assert.Contains(t, rendered, `# Register the instances of the ELB web
+resource "aws_lb_target_group" "eu_west_1_tier1_alb1_web_http_8080" {`)
+	assert.Contains(t, rendered, `# Register the instances of the ELB web-http-8080
+resource "aws_lb_target_group" "eu_west_1_tier1_alb1_web_http_8080_2" {`)
+	assert.Contains(t, rendered, `resource "aws_lb_listener_rule" "eu_west_1_tier1_alb1_443_web_http_8080" {
+  provider     = aws.eu_west_1
+  listener_arn = aws_lb_listener.eu_west_1_tier1_alb1_443.arn
+  priority     = 3
+
+  action {
+    type             = "forward"
+    target_group_arn = aws_lb_target_group.eu_west_1_tier1_alb1_web_http_8080_2.arn
+  }`)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/elb"
+)
+
+// elbUsage is what CloudWatch says an ELB did during the usage window
+type elbUsage struct {
+	Window         string  `json:"window"`         // the usage window, such as "14d"
+	Requests       float64 `json:"requests"`       // the total RequestCount, which is only counted for HTTP(S) listeners
+	ProcessedBytes float64 `json:"processedBytes"` // the total EstimatedProcessedBytes, for every listener
+	HealthyHosts   float64 `json:"healthyHosts"`   // the most that HealthyHostCount reached
+}
+
+// parseUsageWindow parses the value of -usage-window, which is a Go duration, or a whole number of
+// days such as "14d"
+func parseUsageWindow(value string) (time.Duration, error) {
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid usage window %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	res, err := time.ParseDuration(value)
+	if err != nil || res < time.Hour {
+		return 0, fmt.Errorf("invalid usage window %q, which must be at least an hour", value)
+	}
+	return res, nil
+}
+
+// formatUsageWindow writes the window in days if it's a whole number of them
+func formatUsageWindow(window time.Duration) string {
+	day := 24 * time.Hour
+	if window%day == 0 {
+		return fmt.Sprintf("%dd", window/day)
+	}
+	return window.String()
+}
+
+// collectUsage reads the traffic and healthy hosts of each ELB from CloudWatch, over the window up to
+// now. The result is keyed by LoadBalancerName.
+func collectUsage(elbs []*elb.LoadBalancerDescription, cwSvc cloudwatchiface.CloudWatchAPI, window time.Duration, now time.Time) (map[string]*elbUsage, error) {
+	res := make(map[string]*elbUsage)
+
+	// CloudWatch returns at most 1440 datapoints for each call
+	period := time.Hour
+	if window/period > 1440 {
+		period = 24 * time.Hour
+	}
+
+	for _, lb := range elbs {
+		statistic := func(metric string, statistic string) ([]*cloudwatch.Datapoint, error) {
+			result, err := cwSvc.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+				Namespace:  aws.String("AWS/ELB"),
+				MetricName: aws.String(metric),
+				Dimensions: []*cloudwatch.Dimension{
+					{Name: aws.String("LoadBalancerName"), Value: lb.LoadBalancerName},
+				},
+				StartTime:  aws.Time(now.Add(-window)),
+				EndTime:    aws.Time(now),
+				Period:     aws.Int64(int64(period / time.Second)),
+				Statistics: []*string{aws.String(statistic)},
+			})
+			if err != nil {
+				return nil, newAWSError("GetMetricStatistics", err)
+			}
+			return result.Datapoints, nil
+		}
+
+		usage := &elbUsage{Window: formatUsageWindow(window)}
+
+		requests, err := statistic("RequestCount", cloudwatch.StatisticSum)
+		if err != nil {
+			return nil, err
+		}
+		for _, datapoint := range requests {
+			usage.Requests += aws.Float64Value(datapoint.Sum)
+		}
+
+		processed, err := statistic("EstimatedProcessedBytes", cloudwatch.StatisticSum)
+		if err != nil {
+			return nil, err
+		}
+		for _, datapoint := range processed {
+			usage.ProcessedBytes += aws.Float64Value(datapoint.Sum)
+		}
+
+		healthyHosts, err := statistic("HealthyHostCount", cloudwatch.StatisticMaximum)
+		if err != nil {
+			return nil, err
+		}
+		for _, datapoint := range healthyHosts {
+			if healthy := aws.Float64Value(datapoint.Maximum); healthy > usage.HealthyHosts {
+				usage.HealthyHosts = healthy
+			}
+		}
+
+		res[*lb.LoadBalancerName] = usage
+	}
+
+	return res, nil
+}
+
+// hourlyTraffic is the average GB processed and requests made in each hour of the usage window,
+// which is nothing if we didn't read the usage
+func (u *elbUsage) hourlyTraffic() (float64, float64) {
+	if u == nil {
+		return 0, 0
+	}
+	window, err := parseUsageWindow(u.Window)
+	if err != nil {
+		return 0, 0
+	}
+	hours := window.Hours()
+	return u.ProcessedBytes / 1e9 / hours, u.Requests / hours
+}
+
+// unusedReasons explains why an ELB can be deleted rather than replaced, or returns nothing if it's
+// in use
+func unusedReasons(lb *elb.LoadBalancerDescription, usage *elbUsage) []string {
+	res := make([]string, 0)
+
+	if len(lb.Instances) == 0 {
+		res = append(res, "it has no registered instances")
+	} else if usage.HealthyHosts == 0 {
+		res = append(res, fmt.Sprintf("it had no healthy instances in the last %s", usage.Window))
+	}
+
+	if usage.Requests == 0 && usage.ProcessedBytes == 0 {
+		res = append(res, fmt.Sprintf("it had no traffic in the last %s", usage.Window))
+	}
+
+	return res
+}
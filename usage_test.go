@@ -0,0 +1,176 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCloudWatch is a stand-in for the CloudWatch API which returns fixed datapoints for each ELB
+// and metric
+type fakeCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	datapoints map[string]map[string][]float64 // keyed by LoadBalancerName, then MetricName
+	inputs     []*cloudwatch.GetMetricStatisticsInput
+}
+
+func (f *fakeCloudWatch) GetMetricStatistics(input *cloudwatch.GetMetricStatisticsInput) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	f.inputs = append(f.inputs, input)
+
+	res := &cloudwatch.GetMetricStatisticsOutput{}
+	for _, value := range f.datapoints[*input.Dimensions[0].Value][*input.MetricName] {
+		res.Datapoints = append(res.Datapoints, &cloudwatch.Datapoint{Sum: aws.Float64(value), Maximum: aws.Float64(value)})
+	}
+	return res, nil
+}
+
+func TestUsageWindowsAreParsed(t *testing.T) {
+	window, err := parseUsageWindow("14d")
+	assert.NoError(t, err)
+	assert.Equal(t, 14*24*time.Hour, window)
+	assert.Equal(t, "14d", formatUsageWindow(window))
+
+	window, err = parseUsageWindow("36h")
+	assert.NoError(t, err)
+	assert.Equal(t, 36*time.Hour, window)
+	assert.Equal(t, "36h0m0s", formatUsageWindow(window))
+
+	for _, value := range []string{"0d", "-1d", "fortnight", "30m"} {
+		_, err := parseUsageWindow(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestUsageIsReadFromCloudWatch(t *testing.T) {
+	cw := &fakeCloudWatch{datapoints: map[string]map[string][]float64{
+		"web": {
+			"RequestCount":            {100, 20},
+			"EstimatedProcessedBytes": {4096, 1024},
+			"HealthyHostCount":        {1, 3, 2},
+		},
+	}}
+	now := time.Date(2023, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	usage, err := collectUsage([]*elb.LoadBalancerDescription{
+		createELB("web").withSubnets("a").build(),
+		createELB("idle").withSubnets("a").build(),
+	}, cw, 14*24*time.Hour, now)
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]*elbUsage{
+		"web":  {Window: "14d", Requests: 120, ProcessedBytes: 5120, HealthyHosts: 3},
+		"idle": {Window: "14d"},
+	}, usage)
+
+	assert.Equal(t, 6, len(cw.inputs))
+	assert.Equal(t, "AWS/ELB", *cw.inputs[0].Namespace)
+	assert.Equal(t, now.Add(-14*24*time.Hour), *cw.inputs[0].StartTime)
+	assert.Equal(t, int64(3600), *cw.inputs[0].Period)
+}
+
+func TestLongUsageWindowsAreReadDaily(t *testing.T) {
+	cw := &fakeCloudWatch{}
+	_, err := collectUsage([]*elb.LoadBalancerDescription{createELB("web").withSubnets("a").build()}, cw, 90*24*time.Hour, time.Now())
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(86400), *cw.inputs[0].Period)
+}
+
+// usageFixture is three ELBs which could share an ALB, two of which are unused
+func usageFixture() *inventory {
+	http := listenerDescription{port: 80, protocol: "HTTP"}
+	inv := tierInventory(
+		elbInTier("web", http).withInstances("i-web").build(),
+		elbInTier("idle", http).withInstances("i-idle").build(),
+		elbInTier("empty", http).build(),
+	)
+	inv.usage = map[string]*elbUsage{
+		"web":   {Window: "14d", Requests: 1000, ProcessedBytes: 65536, HealthyHosts: 2},
+		"idle":  {Window: "14d", HealthyHosts: 2},
+		"empty": {Window: "14d", Requests: 5, ProcessedBytes: 512},
+	}
+	return inv
+}
+
+func TestUnusedELBsAreDeletedRatherThanReplaced(t *testing.T) {
+	recommendations, _ := analyse(usageFixture(), &analysisOptions{sgPolicy: sgPolicyEquivalent})
+
+	assert.Equal(t, 1, len(recommendations))
+	assert.Equal(t, []deletion{
+		{elb: "empty", reasons: []string{"it has no registered instances"}},
+		{elb: "idle", reasons: []string{"it had no traffic in the last 14d"}},
+	}, recommendations[0].Deletions())
+	assert.Equal(t, []string{"web"}, recommendations[0].ALBs()[0].ELBs())
+
+	// Without the usage, every ELB is consolidated
+	inv := usageFixture()
+	inv.usage = nil
+	recommendations, _ = analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent})
+	assert.Equal(t, []deletion{}, recommendations[0].Deletions())
+	assert.Equal(t, []string{"empty", "idle", "web"}, recommendations[0].ALBs()[0].ELBs())
+}
+
+func TestUnusedELBsAreDeletedEvenIfTheirListenersCantBeClassified(t *testing.T) {
+	inv := usageFixture()
+	inv.elbs = append(inv.elbs, elbInTier("dns", listenerDescription{port: 53, protocol: "UDP"}).withInstances("i-dns").build())
+	inv.usage["dns"] = &elbUsage{Window: "14d", HealthyHosts: 1}
+
+	recommendations, skipped := analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent})
+	assert.Equal(t, 0, len(skipped))
+	assert.Equal(t, deletion{elb: "dns", reasons: []string{"it had no traffic in the last 14d"}}, recommendations[0].Deletions()[0])
+
+	// With traffic, it can't be deleted or replaced
+	inv.usage["dns"].Requests = 10
+	recommendations, skipped = analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent})
+	assert.Equal(t, "dns", skipped[0].elb)
+	assert.Equal(t, 2, len(recommendations[0].Deletions()))
+}
+
+func TestInstancesWhichWereNeverHealthyMakeAnELBUnused(t *testing.T) {
+	lb := createELB("broken").withSubnets("a").withInstances("i-1").build()
+
+	assert.Equal(t, []string{"it had no healthy instances in the last 14d", "it had no traffic in the last 14d"},
+		unusedReasons(lb, &elbUsage{Window: "14d"}))
+	assert.Equal(t, []string{}, unusedReasons(lb, &elbUsage{Window: "14d", HealthyHosts: 1, ProcessedBytes: 1}))
+}
+
+func TestUnusedELBsDoNotMergeTiers(t *testing.T) {
+	inv := &inventory{
+		region: "eu-west-1",
+		elbs: []*elb.LoadBalancerDescription{
+			createELB("first").withSubnets("a").withListenerDescriptions(listenerDescription{port: 80, protocol: "HTTP"}).withInstances("i-1").build(),
+			createELB("bridge").withSubnets("b", "a").withListenerDescriptions(listenerDescription{port: 80, protocol: "HTTP"}).withInstances("i-2").build(),
+			createELB("second").withSubnets("b").withListenerDescriptions(listenerDescription{port: 80, protocol: "HTTP"}).withInstances("i-3").build(),
+		},
+		securityGroups: make(map[string]*ec2.SecurityGroup),
+		usage: map[string]*elbUsage{
+			"first":  {Window: "14d", Requests: 1, HealthyHosts: 1},
+			"bridge": {Window: "14d", HealthyHosts: 1},
+			"second": {Window: "14d", Requests: 1, HealthyHosts: 1},
+		},
+	}
+
+	recommendations, _ := analyse(inv, &analysisOptions{sgPolicy: sgPolicyEquivalent})
+
+	assert.Equal(t, 2, len(recommendations))
+	assert.Equal(t, []string{"a"}, recommendations[0].Subnets())
+	assert.Equal(t, "bridge", recommendations[0].Deletions()[0].elb)
+	assert.Equal(t, []string{"b"}, recommendations[1].Subnets())
+	assert.Equal(t, []deletion{}, recommendations[1].Deletions())
+}
+
+func TestDeletionsAreCountedInTheSaving(t *testing.T) {
+	recommendations, _ := analyse(usageFixture(), &analysisOptions{sgPolicy: sgPolicyEquivalent})
+	tally := tallyRecommendations(recommendations)
+
+	assert.Equal(t, 3, tally.currentElbs)
+	assert.Equal(t, 2, tally.deleted)
+	assert.Equal(t, 1, tally.albs)
+	assert.Equal(t, saving(3, 1, 0, 0), tally.saving())
+}